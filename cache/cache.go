@@ -0,0 +1,25 @@
+// Package cache stores encoded values by key for a while, in memory or in a
+// Redis server shared by every replica. It knows nothing of what it stores:
+// callers choose the keys, encode the values and decide when to forget them.
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// Backend stores values by key. A value set with a ttl of zero is kept until
+// it is deleted or, by a bounded backend, evicted.
+type Backend interface {
+	// Get returns the value stored under key, and false if there is none.
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, keys ...string) error
+}
+
+// Purger is a Backend that can drop every value it holds at once. Only a
+// backend private to the process is one: a shared one would drop the values
+// of the other replicas too.
+type Purger interface {
+	Purge()
+}
@@ -0,0 +1,119 @@
+//go:build unit
+// +build unit
+
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// backends returns each Backend, the Redis one talking to a server of its
+// own on a local port, and a function that moves its clock on.
+func backends(t *testing.T) map[string]func() (Backend, func(time.Duration)) {
+	return map[string]func() (Backend, func(time.Duration)){
+		"lru": func() (Backend, func(time.Duration)) {
+			c := NewLRU(10)
+			now := time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC)
+			c.now = func() time.Time { return now }
+			return c, func(d time.Duration) { now = now.Add(d) }
+		},
+		"redis": func() (Backend, func(time.Duration)) {
+			srv := miniredis.RunT(t)
+			c, err := NewRedis("redis://" + srv.Addr() + "/0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { c.Close() })
+			return c, srv.FastForward
+		},
+	}
+}
+
+func TestBackendU(t *testing.T) {
+	for name, setup := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			// Arrange
+			ctx := context.Background()
+			c, advance := setup()
+
+			// Act
+			setErr := c.Set(ctx, "expense:1", []byte(`{"id":1}`), time.Minute)
+			c.Set(ctx, "expense:2", []byte(`{"id":2}`), 0)
+			c.Set(ctx, "expense:3", []byte(`{"id":3}`), time.Minute)
+			hit, found, getErr := c.Get(ctx, "expense:1")
+			_, missing, _ := c.Get(ctx, "expense:4")
+			deleteErr := c.Delete(ctx, "expense:1")
+			_, deleted, _ := c.Get(ctx, "expense:1")
+			advance(2 * time.Minute)
+			_, expired, _ := c.Get(ctx, "expense:3")
+			kept, stillFound, _ := c.Get(ctx, "expense:2")
+
+			// Assert
+			assert.NoError(t, setErr)
+			assert.NoError(t, getErr)
+			assert.NoError(t, deleteErr)
+			assert.True(t, found)
+			assert.Equal(t, `{"id":1}`, string(hit))
+			assert.False(t, missing)
+			assert.False(t, deleted)
+			assert.False(t, expired, "values expire after their ttl")
+			assert.True(t, stillFound, "values without a ttl are kept")
+			assert.Equal(t, `{"id":2}`, string(kept))
+		})
+	}
+}
+
+func TestLRUEvictsLeastRecentlyUsedU(t *testing.T) {
+	// Arrange
+	ctx := context.Background()
+	c := NewLRU(2)
+	c.Set(ctx, "a", []byte("1"), 0)
+	c.Set(ctx, "b", []byte("2"), 0)
+
+	// Act
+	c.Get(ctx, "a")
+	c.Set(ctx, "c", []byte("3"), 0)
+
+	// Assert
+	_, a, _ := c.Get(ctx, "a")
+	_, b, _ := c.Get(ctx, "b")
+	_, cFound, _ := c.Get(ctx, "c")
+	assert.True(t, a, "a was read after b")
+	assert.False(t, b, "b was the least recently used")
+	assert.True(t, cFound)
+	assert.Equal(t, 2, c.Len())
+}
+
+func TestLRUPurgeU(t *testing.T) {
+	// Arrange
+	ctx := context.Background()
+	c := NewLRU(2)
+	c.Set(ctx, "a", []byte("1"), 0)
+
+	// Act
+	c.Purge()
+
+	// Assert
+	_, found, _ := c.Get(ctx, "a")
+	assert.False(t, found)
+	assert.Equal(t, 0, c.Len())
+}
+
+func TestRedisReportsUnreachableServerU(t *testing.T) {
+	// Arrange
+	srv := miniredis.RunT(t)
+	c, _ := NewRedis("redis://" + srv.Addr() + "/0")
+	defer c.Close()
+	srv.Close()
+
+	// Act
+	_, _, err := c.Get(context.Background(), "expense:1")
+
+	// Assert
+	assert.ErrorContains(t, err, `can't get "expense:1" from cache`)
+}
@@ -0,0 +1,173 @@
+// Package client is a typed Go client for the expenses REST API. It has no
+// dependencies on the server packages so that tools can import it alone.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Expense struct {
+	ID     int      `json:"id"`
+	Title  string   `json:"title"`
+	Amount float64  `json:"amount"`
+	Note   string   `json:"note"`
+	Tags   []string `json:"tags"`
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error is a problem response from the API.
+type Error struct {
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Code      string       `json:"code"`
+	Detail    string       `json:"detail"`
+	RequestID string       `json:"request_id"`
+	Errors    []FieldError `json:"errors"`
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("%d %s: %s", e.Status, e.Code, e.Detail)
+	for _, f := range e.Errors {
+		msg += fmt.Sprintf("; %s %s", f.Field, f.Message)
+	}
+	return msg
+}
+
+// Client calls the API as the user with Username and Password, or with
+// APIKey when it is set.
+type Client struct {
+	BaseURL    string
+	Username   string
+	Password   string
+	APIKey     string
+	HTTPClient *http.Client
+}
+
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (c *Client) ListExpenses(ctx context.Context) ([]Expense, error) {
+	expenses := []Expense{}
+	err := c.do(ctx, http.MethodGet, "/expenses", nil, &expenses)
+	return expenses, err
+}
+
+func (c *Client) GetExpense(ctx context.Context, id int) (Expense, error) {
+	e, _, err := c.GetExpenseWithETag(ctx, id)
+	return e, err
+}
+
+// GetExpenseWithETag is GetExpense that also returns the expense's entity
+// tag, for UpdateExpenseIfMatch.
+func (c *Client) GetExpenseWithETag(ctx context.Context, id int) (Expense, string, error) {
+	e := Expense{}
+	h, err := c.send(ctx, http.MethodGet, "/expenses/"+strconv.Itoa(id), nil, nil, &e)
+	return e, h.Get("ETag"), err
+}
+
+func (c *Client) CreateExpense(ctx context.Context, e Expense) (Expense, error) {
+	created := Expense{}
+	err := c.do(ctx, http.MethodPost, "/expenses", e, &created)
+	return created, err
+}
+
+func (c *Client) UpdateExpense(ctx context.Context, id int, e Expense) (Expense, error) {
+	return c.UpdateExpenseIfMatch(ctx, id, e, "")
+}
+
+// UpdateExpenseIfMatch is UpdateExpense that only goes ahead while the
+// expense's entity tag is still etag, so that a change made since it was
+// read isn't overwritten. Otherwise the *Error has the status 412 Precondition
+// Failed. An empty etag always goes ahead.
+func (c *Client) UpdateExpenseIfMatch(ctx context.Context, id int, e Expense, etag string) (Expense, error) {
+	header := http.Header{}
+	if etag != "" {
+		header.Set("If-Match", etag)
+	}
+	updated := Expense{}
+	_, err := c.send(ctx, http.MethodPut, "/expenses/"+strconv.Itoa(id), header, e, &updated)
+	return updated, err
+}
+
+func (c *Client) do(ctx context.Context, method, path string, in, out any) error {
+	_, err := c.send(ctx, method, path, nil, in, out)
+	return err
+}
+
+// send is do that adds header to the request and returns the header of the
+// response.
+func (c *Client) send(ctx context.Context, method, path string, header http.Header, in, out any) (http.Header, error) {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	} else if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+
+	res, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= http.StatusBadRequest {
+		return res.Header, decodeError(res)
+	}
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+		return res.Header, fmt.Errorf("can't decode %s %s response: %w", method, path, err)
+	}
+	return res.Header, nil
+}
+
+// decodeError reads a problem response. Responses from proxies and other
+// servers that aren't problem documents still become an *Error.
+func decodeError(res *http.Response) error {
+	e := &Error{}
+	b, _ := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if json.Unmarshal(b, e) != nil || (e.Detail == "" && e.Title == "") {
+		e.Detail = strings.TrimSpace(string(b))
+		if e.Detail == "" {
+			e.Detail = http.StatusText(res.StatusCode)
+		}
+	}
+	if e.Detail == "" {
+		e.Detail = e.Title
+	}
+	e.Status = res.StatusCode
+	return e
+}
@@ -0,0 +1,135 @@
+//go:build unit
+// +build unit
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateExpenseSendsBasicAuthU(t *testing.T) {
+	// Arrange
+	var got Expense
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "apidesign" || pass != "secret" || r.Method != http.MethodPost || r.URL.Path != "/expenses" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		got.ID = 1
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(got)
+	}))
+	defer srv.Close()
+	c := New(srv.URL + "/")
+	c.Username, c.Password = "apidesign", "secret"
+
+	// Act
+	e, err := c.CreateExpense(context.Background(), Expense{Title: "coffee", Amount: 75, Tags: []string{"food"}})
+
+	// Assert
+	if assert.NoError(t, err) {
+		assert.Equal(t, Expense{ID: 1, Title: "coffee", Amount: 75, Tags: []string{"food"}}, e)
+	}
+}
+
+func TestAPIKeyTakesPrecedenceU(t *testing.T) {
+	// Arrange
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		w.Write([]byte(`[]`))
+	}))
+	defer srv.Close()
+	c := New(srv.URL)
+	c.Username, c.Password, c.APIKey = "apidesign", "secret", "key-1"
+
+	// Act
+	expenses, err := c.ListExpenses(context.Background())
+
+	// Assert
+	if assert.NoError(t, err) {
+		assert.Empty(t, expenses)
+		assert.Equal(t, "Bearer key-1", auth)
+	}
+}
+
+func TestProblemResponseBecomesErrorU(t *testing.T) {
+	// Arrange
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write([]byte(`{"status":422,"code":"validation_failed","detail":"invalid expense","errors":[{"field":"title","message":"is required"}]}`))
+	}))
+	defer srv.Close()
+
+	// Act
+	_, err := New(srv.URL).UpdateExpense(context.Background(), 1, Expense{})
+
+	// Assert
+	var apiErr *Error
+	if assert.True(t, errors.As(err, &apiErr)) {
+		assert.Equal(t, http.StatusUnprocessableEntity, apiErr.Status)
+		assert.Equal(t, []FieldError{{Field: "title", Message: "is required"}}, apiErr.Errors)
+		assert.Equal(t, "422 validation_failed: invalid expense; title is required", err.Error())
+	}
+}
+
+func TestNonProblemErrorKeepsBodyU(t *testing.T) {
+	// Arrange
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "upstream unavailable", http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	// Act
+	_, err := New(srv.URL).GetExpense(context.Background(), 1)
+
+	// Assert
+	var apiErr *Error
+	if assert.True(t, errors.As(err, &apiErr)) {
+		assert.Equal(t, http.StatusBadGateway, apiErr.Status)
+		assert.Equal(t, "upstream unavailable", apiErr.Detail)
+	}
+}
+
+func TestUpdateExpenseIfMatchU(t *testing.T) {
+	// Arrange
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		if r.Method == http.MethodPut && r.Header.Get("If-Match") != `"v1"` {
+			w.Header().Set("Content-Type", "application/problem+json")
+			w.WriteHeader(http.StatusPreconditionFailed)
+			w.Write([]byte(`{"status":412,"code":"precondition_failed","detail":"expense 1 has changed since it was read"}`))
+			return
+		}
+		json.NewEncoder(w).Encode(Expense{ID: 1, Title: "coffee", Amount: 75})
+	}))
+	defer srv.Close()
+	c := New(srv.URL)
+	ctx := context.Background()
+
+	// Act
+	e, etag, getErr := c.GetExpenseWithETag(ctx, 1)
+	_, currentErr := c.UpdateExpenseIfMatch(ctx, 1, e, etag)
+	_, staleErr := c.UpdateExpenseIfMatch(ctx, 1, e, `"v0"`)
+
+	// Assert
+	assert.NoError(t, getErr)
+	assert.Equal(t, `"v1"`, etag)
+	assert.NoError(t, currentErr)
+	var apiErr *Error
+	if assert.True(t, errors.As(staleErr, &apiErr)) {
+		assert.Equal(t, http.StatusPreconditionFailed, apiErr.Status)
+		assert.Equal(t, "precondition_failed", apiErr.Code)
+	}
+}
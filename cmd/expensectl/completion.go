@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+const bashCompletion = `# bash completion for expensectl. Load it with:
+#   source <(expensectl completion bash)
+_expensectl() {
+	local cur prev
+	cur="${COMP_WORDS[COMP_CWORD]}"
+	prev="${COMP_WORDS[COMP_CWORD-1]}"
+	case "$prev" in
+	-o|-output)
+		COMPREPLY=($(compgen -W "%[2]s" -- "$cur"))
+		return
+		;;
+	-format)
+		COMPREPLY=($(compgen -W "json csv" -- "$cur"))
+		return
+		;;
+	completion)
+		COMPREPLY=($(compgen -W "bash zsh fish" -- "$cur"))
+		return
+		;;
+	import|-file)
+		COMPREPLY=($(compgen -f -- "$cur"))
+		return
+		;;
+	esac
+	if [[ "$cur" == -* ]]; then
+		COMPREPLY=($(compgen -W "-profile -o" -- "$cur"))
+		return
+	fi
+	COMPREPLY=($(compgen -W "%[1]s" -- "$cur"))
+}
+complete -F _expensectl expensectl
+`
+
+const zshCompletion = `#compdef expensectl
+# zsh completion for expensectl. Load it with:
+#   source <(expensectl completion zsh)
+_expensectl() {
+	local -a commands
+	commands=(%[1]s)
+	_arguments \
+		'-profile[profile to use]:profile:' \
+		'-o[output format]:format:(%[2]s)' \
+		'1:command:(${commands})' \
+		'*:file:_files'
+}
+compdef _expensectl expensectl
+`
+
+const fishCompletion = `# fish completion for expensectl. Load it with:
+#   expensectl completion fish | source
+complete -c expensectl -f
+complete -c expensectl -n '__fish_use_subcommand' -a '%[1]s'
+complete -c expensectl -o profile -d 'profile to use'
+complete -c expensectl -o o -d 'output format' -xa '%[2]s'
+complete -c expensectl -n '__fish_seen_subcommand_from completion' -a 'bash zsh fish'
+complete -c expensectl -n '__fish_seen_subcommand_from import' -F
+`
+
+func writeCompletion(w io.Writer, shell string) error {
+	names := make([]string, 0, len(commands))
+	for _, c := range commands {
+		names = append(names, c.name)
+	}
+	formats := strings.Join([]string{formatTable, formatJSON, formatCSV}, " ")
+
+	var script string
+	switch shell {
+	case "bash":
+		script = bashCompletion
+	case "zsh":
+		script = zshCompletion
+	case "fish":
+		script = fishCompletion
+	default:
+		return fmt.Errorf("unsupported shell %q, use bash, zsh or fish", shell)
+	}
+	_, err := fmt.Fprintf(w, script, strings.Join(names, " "), formats)
+	return err
+}
@@ -0,0 +1,431 @@
+// Command expensectl manages expenses from the terminal through the REST
+// API. Servers and credentials are kept in profiles, set up once with
+// expensectl configure, so they never appear on the command line.
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/PatcharaKL/assessment/client"
+)
+
+type app struct {
+	stdin          io.Reader
+	stdout, stderr io.Writer
+
+	configPath string
+	profile    string
+	output     string
+}
+
+type command struct {
+	name  string
+	usage string
+	run   func(a *app, ctx context.Context, args []string) error
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"add", "add -title TITLE -amount AMOUNT [-note NOTE] [-tags a;b]", (*app).add},
+		{"get", "get ID", (*app).get},
+		{"list", "list", (*app).list},
+		{"edit", "edit ID [-title TITLE] [-amount AMOUNT] [-note NOTE] [-tags a;b]", (*app).edit},
+		{"export", "export [-format csv|json] [-file PATH]", (*app).export},
+		{"import", "import [-format csv|json] FILE|-", (*app).importFile},
+		{"configure", "configure [-url URL] [-username NAME] [-password-stdin] [-api-key-stdin] [-use]", (*app).configure},
+		{"completion", "completion bash|zsh|fish", (*app).completion},
+	}
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run executes the command line in args and returns the exit status.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	a := &app{stdin: stdin, stdout: stdout, stderr: stderr}
+
+	fs := flag.NewFlagSet("expensectl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&a.profile, "profile", os.Getenv("EXPENSECTL_PROFILE"), "profile to use instead of the current one")
+	fs.StringVar(&a.output, "o", "", "output format: table, json or csv")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: expensectl [-profile NAME] [-o table|json|csv] COMMAND [ARGS]")
+		fmt.Fprintln(stderr, "\ncommands:")
+		for _, c := range commands {
+			fmt.Fprintln(stderr, "  "+c.usage)
+		}
+		fmt.Fprintln(stderr, "\nflags:")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	if a.output != "" && !validFormat(a.output) {
+		fmt.Fprintf(stderr, "expensectl: output must be table, json or csv, got %q\n", a.output)
+		return 2
+	}
+
+	path, err := configPath()
+	if err != nil {
+		fmt.Fprintln(stderr, "expensectl:", err)
+		return 1
+	}
+	a.configPath = path
+
+	name := fs.Arg(0)
+	for _, c := range commands {
+		if c.name != name {
+			continue
+		}
+		if err := c.run(a, context.Background(), fs.Args()[1:]); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return 2
+			}
+			fmt.Fprintf(stderr, "expensectl %s: %v\n", name, err)
+			return 1
+		}
+		return 0
+	}
+	fmt.Fprintf(stderr, "expensectl: unknown command %q\n", name)
+	fs.Usage()
+	return 2
+}
+
+// client returns a client for the selected profile, and sets the output
+// format from the profile unless -o was given.
+func (a *app) client() (*client.Client, error) {
+	cfg, err := loadConfig(a.configPath)
+	if err != nil {
+		return nil, err
+	}
+	_, p, err := cfg.profile(a.profile)
+	if err != nil {
+		return nil, err
+	}
+	if a.output == "" {
+		a.output = p.Output
+	}
+	if a.output == "" {
+		a.output = formatTable
+	}
+
+	c := client.New(p.URL)
+	c.Username = p.Username
+	c.Password = p.Password
+	c.APIKey = p.APIKey
+	return c, nil
+}
+
+func (a *app) flags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("expensectl "+name, flag.ContinueOnError)
+	fs.SetOutput(a.stderr)
+	return fs
+}
+
+// expenseFlags registers the fields add and edit accept.
+func expenseFlags(fs *flag.FlagSet) (title *string, amount *float64, note, tags *string) {
+	title = fs.String("title", "", "what the money was spent on")
+	amount = fs.Float64("amount", 0, "amount spent")
+	note = fs.String("note", "", "free-form note")
+	tags = fs.String("tags", "", "tags separated by "+tagSeparator)
+	return title, amount, note, tags
+}
+
+func splitTags(s string) []string {
+	tags := []string{}
+	for _, t := range strings.Split(s, tagSeparator) {
+		if t = strings.TrimSpace(t); t != "" {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+func parseID(args []string) (int, error) {
+	if len(args) != 1 {
+		return 0, errors.New("expected exactly one expense ID")
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("expense ID must be a positive integer, got %q", args[0])
+	}
+	return id, nil
+}
+
+func (a *app) add(ctx context.Context, args []string) error {
+	fs := a.flags("add")
+	title, amount, note, tags := expenseFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	e, err := c.CreateExpense(ctx, client.Expense{Title: *title, Amount: *amount, Note: *note, Tags: splitTags(*tags)})
+	if err != nil {
+		return err
+	}
+	return writeExpense(a.stdout, a.output, e)
+}
+
+func (a *app) get(ctx context.Context, args []string) error {
+	id, err := parseID(args)
+	if err != nil {
+		return err
+	}
+
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	e, err := c.GetExpense(ctx, id)
+	if err != nil {
+		return err
+	}
+	return writeExpense(a.stdout, a.output, e)
+}
+
+func (a *app) list(ctx context.Context, args []string) error {
+	if err := a.flags("list").Parse(args); err != nil {
+		return err
+	}
+
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	expenses, err := c.ListExpenses(ctx)
+	if err != nil {
+		return err
+	}
+	return writeExpenses(a.stdout, a.output, expenses)
+}
+
+// edit changes only the fields given as flags and keeps the others. It fails,
+// rather than overwrite, when the expense changes between reading and writing.
+func (a *app) edit(ctx context.Context, args []string) error {
+	fs := a.flags("edit")
+	title, amount, note, tags := expenseFlags(fs)
+	if len(args) == 0 {
+		return errors.New("expected an expense ID")
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	id, err := parseID(args[:1])
+	if err != nil {
+		return err
+	}
+
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	e, etag, err := c.GetExpenseWithETag(ctx, id)
+	if err != nil {
+		return err
+	}
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "title":
+			e.Title = *title
+		case "amount":
+			e.Amount = *amount
+		case "note":
+			e.Note = *note
+		case "tags":
+			e.Tags = splitTags(*tags)
+		}
+	})
+
+	// The update only goes ahead if nobody changed the expense since the
+	// GET, or their change would be lost.
+	e, err = c.UpdateExpenseIfMatch(ctx, id, e, etag)
+	var apiErr *client.Error
+	if errors.As(err, &apiErr) && apiErr.Status == http.StatusPreconditionFailed {
+		return fmt.Errorf("expense %d was changed by someone else since it was read; run edit again", id)
+	}
+	if err != nil {
+		return err
+	}
+	return writeExpense(a.stdout, a.output, e)
+}
+
+func (a *app) export(ctx context.Context, args []string) error {
+	fs := a.flags("export")
+	format := fs.String("format", formatCSV, "csv or json")
+	file := fs.String("file", "", "write to this file instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *format != formatCSV && *format != formatJSON {
+		return fmt.Errorf("format must be csv or json, got %q", *format)
+	}
+
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	expenses, err := c.ListExpenses(ctx)
+	if err != nil {
+		return err
+	}
+
+	if *file == "" {
+		return writeExpenses(a.stdout, *format, expenses)
+	}
+	f, err := os.Create(*file)
+	if err != nil {
+		return err
+	}
+	if err := writeExpenses(f, *format, expenses); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(a.stderr, "exported %d expenses to %s\n", len(expenses), *file)
+	return nil
+}
+
+// importFile creates an expense for every record in a file written by
+// export. It stops at the first expense the server rejects and reports how
+// many were created before it.
+func (a *app) importFile(ctx context.Context, args []string) error {
+	fs := a.flags("import")
+	format := fs.String("format", "", "csv or json, by default from the file extension")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("expected a file to import, or - for stdin")
+	}
+	path := fs.Arg(0)
+	if *format == "" {
+		*format = formatCSV
+		if strings.EqualFold(filepath.Ext(path), ".json") {
+			*format = formatJSON
+		}
+	}
+
+	var r io.Reader = a.stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	expenses, err := readExpenses(r, *format)
+	if err != nil {
+		return err
+	}
+
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	for i, e := range expenses {
+		e.ID = 0
+		if _, err := c.CreateExpense(ctx, e); err != nil {
+			return fmt.Errorf("expense %d (%q): %w; %d imported before it", i+1, e.Title, err, i)
+		}
+	}
+	fmt.Fprintf(a.stdout, "imported %d expenses\n", len(expenses))
+	return nil
+}
+
+// configure creates or updates a profile. Secrets are read from stdin so
+// they stay out of shell history and the process list.
+func (a *app) configure(ctx context.Context, args []string) error {
+	fs := a.flags("configure")
+	url := fs.String("url", "", "API base URL, e.g. http://localhost:2565")
+	username := fs.String("username", "", "basic auth username")
+	passwordStdin := fs.Bool("password-stdin", false, "read the password from stdin")
+	apiKeyStdin := fs.Bool("api-key-stdin", false, "read an API key from stdin")
+	output := fs.String("output", "", "default output format: table, json or csv")
+	use := fs.Bool("use", false, "make this the current profile")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *passwordStdin && *apiKeyStdin {
+		return errors.New("-password-stdin and -api-key-stdin can't be used together")
+	}
+	if *output != "" && !validFormat(*output) {
+		return fmt.Errorf("output must be table, json or csv, got %q", *output)
+	}
+
+	cfg, err := loadConfig(a.configPath)
+	if err != nil {
+		return err
+	}
+	name := a.profile
+	if name == "" {
+		name = cfg.Current
+	}
+	p := cfg.Profiles[name]
+
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "url":
+			p.URL = *url
+		case "username":
+			p.Username = *username
+		case "output":
+			p.Output = *output
+		}
+	})
+	if *passwordStdin || *apiKeyStdin {
+		secret, err := bufio.NewReader(a.stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		secret = strings.TrimSpace(secret)
+		if *passwordStdin {
+			p.Password = secret
+		} else {
+			p.APIKey = secret
+		}
+	}
+	if p.URL == "" {
+		return errors.New("-url is required for a new profile")
+	}
+
+	cfg.Profiles[name] = p
+	if *use || len(cfg.Profiles) == 1 {
+		cfg.Current = name
+	}
+	if err := saveConfig(a.configPath, cfg); err != nil {
+		return err
+	}
+	fmt.Fprintf(a.stdout, "saved profile %q to %s\n", name, a.configPath)
+	return nil
+}
+
+func (a *app) completion(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("expected a shell: bash, zsh or fish")
+	}
+	return writeCompletion(a.stdout, args[0])
+}
@@ -0,0 +1,237 @@
+//go:build unit
+// +build unit
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/PatcharaKL/assessment/client"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeAPI keeps expenses in memory and serves the subset of the REST API
+// the client uses.
+type fakeAPI struct {
+	mu       sync.Mutex
+	expenses []client.Expense
+	auth     []string
+	// version is that of expense 1, whose ETag is "v" and the version.
+	version int
+	ifMatch []string
+	// changedAfterGet has someone else update expense 1 after each GET.
+	changedAfterGet bool
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.auth = append(f.auth, r.Header.Get("Authorization"))
+	w.Header().Set("Content-Type", "application/json")
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/expenses":
+		json.NewEncoder(w).Encode(f.expenses)
+	case r.Method == http.MethodPost && r.URL.Path == "/expenses":
+		e := client.Expense{}
+		json.NewDecoder(r.Body).Decode(&e)
+		e.ID = len(f.expenses) + 1
+		f.expenses = append(f.expenses, e)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(e)
+	case r.URL.Path == "/expenses/1" && len(f.expenses) > 0:
+		if r.Method == http.MethodPut {
+			f.ifMatch = append(f.ifMatch, r.Header.Get("If-Match"))
+			if m := r.Header.Get("If-Match"); m != "" && m != fmt.Sprintf(`"v%d"`, f.version) {
+				w.Header().Set("Content-Type", "application/problem+json")
+				w.WriteHeader(http.StatusPreconditionFailed)
+				w.Write([]byte(`{"status":412,"code":"precondition_failed","detail":"expense 1 has changed since it was read"}`))
+				return
+			}
+			e := client.Expense{}
+			json.NewDecoder(r.Body).Decode(&e)
+			e.ID = 1
+			f.expenses[0] = e
+			f.version++
+		}
+		w.Header().Set("ETag", fmt.Sprintf(`"v%d"`, f.version))
+		json.NewEncoder(w).Encode(f.expenses[0])
+		if r.Method == http.MethodGet && f.changedAfterGet {
+			f.version++
+		}
+	default:
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"status":404,"code":"not_found","detail":"expense not found"}`))
+	}
+}
+
+// setup points expensectl at a fake API through a fresh config file.
+func setup(t *testing.T) (*fakeAPI, string) {
+	api := &fakeAPI{}
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+	path := filepath.Join(t.TempDir(), "expensectl", "config.yaml")
+	t.Setenv("EXPENSECTL_CONFIG", path)
+	t.Setenv("EXPENSECTL_PROFILE", "")
+
+	code, _, stderr := runCmd(t, "apidesign-secret\n", "configure", "-url", srv.URL, "-username", "apidesign", "-password-stdin")
+	if code != 0 {
+		t.Fatalf("configure failed: %s", stderr)
+	}
+	return api, path
+}
+
+func runCmd(t *testing.T, stdin string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestConfigureWritesPrivateProfileU(t *testing.T) {
+	// Arrange
+	_, path := setup(t)
+
+	// Act
+	info, err := os.Stat(path)
+	cfg, loadErr := loadConfig(path)
+
+	// Assert
+	if assert.NoError(t, err) && assert.NoError(t, loadErr) {
+		assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+		assert.Equal(t, defaultProfile, cfg.Current)
+		assert.Equal(t, "apidesign", cfg.Profiles[defaultProfile].Username)
+		assert.Equal(t, "apidesign-secret", cfg.Profiles[defaultProfile].Password)
+	}
+}
+
+func TestAddAndGetU(t *testing.T) {
+	// Arrange
+	api, _ := setup(t)
+
+	// Act
+	addCode, _, _ := runCmd(t, "", "add", "-title", "coffee", "-amount", "75", "-tags", "food;drink")
+	getCode, out, _ := runCmd(t, "", "-o", "json", "get", "1")
+
+	// Assert
+	assert.Equal(t, 0, addCode)
+	assert.Equal(t, 0, getCode)
+	got := client.Expense{}
+	if assert.NoError(t, json.Unmarshal([]byte(out), &got)) {
+		assert.Equal(t, client.Expense{ID: 1, Title: "coffee", Amount: 75, Tags: []string{"food", "drink"}}, got)
+	}
+	assert.Contains(t, api.auth, "Basic YXBpZGVzaWduOmFwaWRlc2lnbi1zZWNyZXQ=")
+}
+
+func TestEditChangesOnlyGivenFieldsU(t *testing.T) {
+	// Arrange
+	api, _ := setup(t)
+	api.expenses = []client.Expense{{ID: 1, Title: "coffee", Amount: 75, Note: "morning", Tags: []string{"food"}}}
+
+	// Act
+	code, _, stderr := runCmd(t, "", "edit", "1", "-amount", "80")
+
+	// Assert
+	assert.Equal(t, 0, code, stderr)
+	assert.Equal(t, client.Expense{ID: 1, Title: "coffee", Amount: 80, Note: "morning", Tags: []string{"food"}}, api.expenses[0])
+	assert.Equal(t, []string{`"v0"`}, api.ifMatch, "the update is conditional on the version read")
+}
+
+func TestEditChangedSinceReadU(t *testing.T) {
+	// Arrange
+	api, _ := setup(t)
+	api.expenses = []client.Expense{{ID: 1, Title: "coffee", Amount: 75}}
+	api.changedAfterGet = true
+
+	// Act
+	code, _, stderr := runCmd(t, "", "edit", "1", "-amount", "80")
+
+	// Assert
+	assert.Equal(t, 1, code)
+	assert.Equal(t, "expensectl edit: expense 1 was changed by someone else since it was read; run edit again\n", stderr)
+	assert.Equal(t, 75.0, api.expenses[0].Amount, "the other change is kept")
+}
+
+func TestListTableU(t *testing.T) {
+	// Arrange
+	api, _ := setup(t)
+	api.expenses = []client.Expense{{ID: 1, Title: "coffee", Amount: 75, Tags: []string{"food", "drink"}}}
+
+	// Act
+	code, out, _ := runCmd(t, "", "list")
+
+	// Assert
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "ID  TITLE   AMOUNT  NOTE  TAGS\n1   coffee  75.00         food;drink\n", out)
+}
+
+func TestExportImportRoundTripU(t *testing.T) {
+	// Arrange
+	api, _ := setup(t)
+	api.expenses = []client.Expense{
+		{ID: 1, Title: "coffee, large", Amount: 75.5, Note: "said \"hi\"", Tags: []string{"food", "drink"}},
+		{ID: 2, Title: "bus", Amount: 15, Tags: []string{}},
+	}
+	file := filepath.Join(t.TempDir(), "expenses.csv")
+
+	// Act
+	exportCode, _, _ := runCmd(t, "", "export", "-file", file)
+	api.expenses = nil
+	importCode, out, stderr := runCmd(t, "", "import", file)
+
+	// Assert
+	assert.Equal(t, 0, exportCode)
+	assert.Equal(t, 0, importCode, stderr)
+	assert.Equal(t, "imported 2 expenses\n", out)
+	assert.Equal(t, []client.Expense{
+		{ID: 1, Title: "coffee, large", Amount: 75.5, Note: "said \"hi\"", Tags: []string{"food", "drink"}},
+		{ID: 2, Title: "bus", Amount: 15},
+	}, api.expenses)
+}
+
+func TestAPIErrorExitsNonZeroU(t *testing.T) {
+	// Arrange
+	setup(t)
+
+	// Act
+	code, _, stderr := runCmd(t, "", "get", "9")
+
+	// Assert
+	assert.Equal(t, 1, code)
+	assert.Equal(t, "expensectl get: 404 not_found: expense not found\n", stderr)
+}
+
+func TestMissingProfileU(t *testing.T) {
+	// Arrange
+	setup(t)
+
+	// Act
+	code, _, stderr := runCmd(t, "", "-profile", "staging", "list")
+
+	// Assert
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, `profile "staging" not found`)
+}
+
+func TestCompletionListsCommandsU(t *testing.T) {
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		t.Run(shell, func(t *testing.T) {
+			// Act
+			code, out, _ := runCmd(t, "", "completion", shell)
+
+			// Assert
+			assert.Equal(t, 0, code)
+			assert.Contains(t, out, "add get list edit export import configure completion")
+			assert.Contains(t, out, "table json csv")
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/PatcharaKL/assessment/client"
+)
+
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+	formatCSV   = "csv"
+)
+
+// tagSeparator joins tags in table and CSV cells.
+const tagSeparator = ";"
+
+var csvHeader = []string{"id", "title", "amount", "note", "tags"}
+
+func validFormat(f string) bool {
+	return f == formatTable || f == formatJSON || f == formatCSV
+}
+
+func writeExpenses(w io.Writer, format string, expenses []client.Expense) error {
+	switch format {
+	case formatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(expenses)
+	case formatCSV:
+		return writeCSV(w, expenses)
+	default:
+		return writeTable(w, expenses)
+	}
+}
+
+// writeExpense writes e on its own: a JSON object rather than a list.
+func writeExpense(w io.Writer, format string, e client.Expense) error {
+	if format == formatJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(e)
+	}
+	return writeExpenses(w, format, []client.Expense{e})
+}
+
+func writeTable(w io.Writer, expenses []client.Expense) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTITLE\tAMOUNT\tNOTE\tTAGS")
+	for _, e := range expenses {
+		fmt.Fprintf(tw, "%d\t%s\t%.2f\t%s\t%s\n", e.ID, e.Title, e.Amount, e.Note, strings.Join(e.Tags, tagSeparator))
+	}
+	return tw.Flush()
+}
+
+func writeCSV(w io.Writer, expenses []client.Expense) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, e := range expenses {
+		row := []string{
+			strconv.Itoa(e.ID),
+			e.Title,
+			strconv.FormatFloat(e.Amount, 'f', -1, 64),
+			e.Note,
+			strings.Join(e.Tags, tagSeparator),
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// readExpenses reads what export writes. The id column is optional and
+// ignored by import, which always creates new expenses.
+func readExpenses(r io.Reader, format string) ([]client.Expense, error) {
+	if format == formatJSON {
+		expenses := []client.Expense{}
+		if err := json.NewDecoder(r).Decode(&expenses); err != nil {
+			return nil, fmt.Errorf("can't parse json: %w", err)
+		}
+		return expenses, nil
+	}
+
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("can't parse csv: %w", err)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	cols := map[string]int{}
+	for i, name := range records[0] {
+		cols[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"title", "amount"} {
+		if _, ok := cols[name]; !ok {
+			return nil, fmt.Errorf("csv has no %s column", name)
+		}
+	}
+	cell := func(row []string, name string) string {
+		if i, ok := cols[name]; ok && i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+
+	expenses := []client.Expense{}
+	for n, row := range records[1:] {
+		amount, err := strconv.ParseFloat(cell(row, "amount"), 64)
+		if err != nil {
+			return nil, fmt.Errorf("csv line %d: amount must be a number, got %q", n+2, cell(row, "amount"))
+		}
+		e := client.Expense{Title: cell(row, "title"), Amount: amount, Note: cell(row, "note")}
+		if tags := cell(row, "tags"); tags != "" {
+			e.Tags = strings.Split(tags, tagSeparator)
+		}
+		expenses = append(expenses, e)
+	}
+	return expenses, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Profile is a server and the credentials to use with it. An API key, when
+// set, is used instead of the username and password.
+type Profile struct {
+	URL      string `yaml:"url"`
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	APIKey   string `yaml:"api_key,omitempty"`
+	Output   string `yaml:"output,omitempty"`
+}
+
+// Config is the file that holds the profiles. It may contain secrets, so it
+// is written readable by its owner only.
+type Config struct {
+	Current  string             `yaml:"current"`
+	Profiles map[string]Profile `yaml:"profiles"`
+}
+
+const defaultProfile = "default"
+
+// configPath is EXPENSECTL_CONFIG or config.yaml in the user's config
+// directory, e.g. ~/.config/expensectl/config.yaml.
+func configPath() (string, error) {
+	if p := os.Getenv("EXPENSECTL_CONFIG"); p != "" {
+		return p, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "expensectl", "config.yaml"), nil
+}
+
+func loadConfig(path string) (Config, error) {
+	cfg := Config{Current: defaultProfile, Profiles: map[string]Profile{}}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+	if err := yaml.Unmarshal(b, &cfg); err != nil {
+		return cfg, fmt.Errorf("can't parse %s: %w", path, err)
+	}
+	if cfg.Profiles == nil {
+		cfg.Profiles = map[string]Profile{}
+	}
+	return cfg, nil
+}
+
+func saveConfig(path string, cfg Config) error {
+	b, err := yaml.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o600)
+}
+
+// profile returns the named profile, or the current one when name is empty.
+func (c Config) profile(name string) (string, Profile, error) {
+	if name == "" {
+		name = c.Current
+	}
+	p, ok := c.Profiles[name]
+	if !ok {
+		return name, p, fmt.Errorf("profile %q not found, create it with: expensectl configure -profile %s -url URL", name, name)
+	}
+	return name, p, nil
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/PatcharaKL/assessment/config"
+	"github.com/PatcharaKL/assessment/logging"
+	"github.com/PatcharaKL/assessment/metrics"
+	"github.com/PatcharaKL/assessment/migrations"
+	"github.com/PatcharaKL/assessment/sqlhooks"
+	"github.com/PatcharaKL/assessment/tracing"
+	"github.com/lib/pq"
+)
+
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(ctx context.Context, args []string) error
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"serve", "[flags]", "start the API servers; the default when no command is given", serve},
+		{"migrate", "[up|status] [flags]", "apply pending migrations, or list every migration and its state", migrate},
+		{"seed", "-owner USER [-file PATH | -unowned] [flags]", "load demo expenses or fixtures from a JSON file, or claim expenses that have no owner", seed},
+		{"user", "create|reset-password|disable -username USER [flags]", "administer accounts without the API", user},
+		{"check", "[flags]", "validate the configuration and connect to the database", check},
+	}
+}
+
+// stdout and stdin are where commands other than serve write their report
+// and read secrets; tests replace them.
+var (
+	stdout io.Writer = os.Stdout
+	stdin  io.Reader = os.Stdin
+)
+
+// usageError is a mistake on the command line or in the configuration,
+// reported with exit status 2.
+type usageError struct {
+	err error
+}
+
+func (e usageError) Error() string { return e.err.Error() }
+func (e usageError) Unwrap() error { return e.err }
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	code := run(ctx, os.Args[1:], os.Stderr)
+	stop()
+	os.Exit(code)
+}
+
+// run executes the command named by the first argument. Without one, or when
+// the first argument is a flag, it serves, as the binary did before it had
+// commands.
+func run(ctx context.Context, args []string, stderr io.Writer) int {
+	name := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		usage(stderr)
+		return 0
+	}
+
+	for _, c := range commands {
+		if c.name != name {
+			continue
+		}
+		err := c.run(ctx, args)
+		if errors.Is(err, flag.ErrHelp) {
+			return 2
+		}
+		var ue usageError
+		if errors.As(err, &ue) {
+			fmt.Fprintf(stderr, "server %s: %v\n", name, err)
+			return 2
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "server %s: %v\n", name, err)
+			return 1
+		}
+		return 0
+	}
+	fmt.Fprintf(stderr, "server: unknown command %q\n", name)
+	usage(stderr)
+	return 2
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: server [COMMAND] [flags]")
+	fmt.Fprintln(w, "\ncommands:")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, c := range commands {
+		fmt.Fprintf(tw, "  %s %s\t%s\n", c.name, c.args, c.summary)
+	}
+	tw.Flush()
+	fmt.Fprintln(w, "\nRun server COMMAND -h for the flags of a command.")
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	return flag.NewFlagSet("server "+name, flag.ContinueOnError)
+}
+
+// parseConfig parses the configuration flags, and any flags of the command
+// already defined on fs, then loads the configuration.
+func parseConfig(fs *flag.FlagSet, args []string) (config.Config, error) {
+	cfg, err := config.Parse(fs, args)
+	if err != nil && !errors.Is(err, flag.ErrHelp) {
+		return cfg, usageError{err}
+	}
+	return cfg, err
+}
+
+// openDB opens the database with the query hooks, statement timeout and pool
+// limits from cfg. It doesn't connect; the first query does.
+func openDB(cfg config.Config, logger *slog.Logger) (*sql.DB, error) {
+	connector, err := pq.NewConnector(cfg.DB.URL)
+	if err != nil {
+		return nil, err
+	}
+	db := sqlhooks.Open(connector, cfg.DB.StatementTimeout,
+		logging.SlowQueryHook{Logger: logger, Threshold: cfg.SlowQuery},
+		metrics.QueryHook{},
+		tracing.QueryHook{},
+	)
+	db.SetMaxOpenConns(cfg.DB.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.DB.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.DB.ConnMaxLifetime)
+	return db, nil
+}
+
+// waitForDB pings db until it answers, for up to cfg.DB.StartupWait, so that
+// a command started alongside the database, as in docker-compose, waits for
+// it rather than failing. The wait between attempts doubles from
+// StartupBackoff up to StartupMaxBackoff.
+func waitForDB(ctx context.Context, db *sql.DB, cfg config.Config, logger *slog.Logger) error {
+	if cfg.DB.StartupWait <= 0 {
+		if err := db.PingContext(ctx); err != nil {
+			return fmt.Errorf("can't connect to database: %w", err)
+		}
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, cfg.DB.StartupWait)
+	defer cancel()
+	backoff := cfg.DB.StartupBackoff
+	for attempt := 1; ; attempt++ {
+		err := db.PingContext(ctx)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("can't connect to database after %d attempts: %w", attempt, err)
+		}
+		logger.WarnContext(ctx, "can't connect to database, retrying", "attempt", attempt, "in", backoff, "error", err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return fmt.Errorf("can't connect to database after %d attempts: %w", attempt, err)
+		}
+		backoff = min(2*backoff, cfg.DB.StartupMaxBackoff)
+	}
+}
+
+// connect is openDB and waitForDB for the one-shot commands, which log to
+// stderr so that stdout only holds their report. Tests replace it with a
+// mock database.
+var connect = func(ctx context.Context, cfg config.Config) (*sql.DB, error) {
+	logger := logging.New(os.Stderr, cfg.LogLevel)
+	db, err := openDB(cfg, logger)
+	if err != nil {
+		return nil, usageError{fmt.Errorf("invalid database url: %w", err)}
+	}
+	if err := waitForDB(ctx, db, cfg, logger); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func migrate(ctx context.Context, args []string) error {
+	action := "up"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		action, args = args[0], args[1:]
+	}
+	if action != "up" && action != "status" {
+		return usageError{fmt.Errorf("unknown action %q, use up or status", action)}
+	}
+	cfg, err := parseConfig(newFlagSet("migrate "+action), args)
+	if err != nil {
+		return err
+	}
+	db, err := connect(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if action == "status" {
+		return migrationStatus(ctx, db)
+	}
+	ran, err := migrations.Up(sqlhooks.WithoutTimeout(ctx), db)
+	for _, m := range ran {
+		fmt.Fprintf(stdout, "applied %s\n", m.Name)
+	}
+	if err != nil {
+		return err
+	}
+	if len(ran) == 0 {
+		fmt.Fprintln(stdout, "schema is up to date")
+	}
+	return nil
+}
+
+func migrationStatus(ctx context.Context, db *sql.DB) error {
+	all, err := migrations.All()
+	if err != nil {
+		return err
+	}
+	pending, err := migrations.Pending(ctx, db)
+	if err != nil {
+		return err
+	}
+	isPending := map[int]bool{}
+	for _, m := range pending {
+		isPending[m.Version] = true
+	}
+	for _, m := range all {
+		state := "applied"
+		if isPending[m.Version] {
+			state = "pending"
+		}
+		fmt.Fprintf(stdout, "%-8s %s\n", state, m.Name)
+	}
+	return nil
+}
+
+// check validates the configuration, connects to the database and reports
+// pending migrations, which serve would apply on start. It fails only when
+// the configuration is invalid or the database can't be reached.
+func check(ctx context.Context, args []string) error {
+	cfg, err := parseConfig(newFlagSet("check"), args)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, "config: ok")
+
+	db, err := connect(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeouts.Readiness)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		return fmt.Errorf("database: %w", err)
+	}
+	fmt.Fprintln(stdout, "database: ok")
+
+	pending, err := migrations.Pending(ctx, db)
+	if err != nil {
+		return fmt.Errorf("migrations: %w", err)
+	}
+	if len(pending) == 0 {
+		fmt.Fprintln(stdout, "migrations: up to date")
+		return nil
+	}
+	names := make([]string, 0, len(pending))
+	for _, m := range pending {
+		names = append(names, m.Name)
+	}
+	fmt.Fprintf(stdout, "migrations: %d pending (%s)\n", len(pending), strings.Join(names, ", "))
+	return nil
+}
@@ -0,0 +1,261 @@
+//go:build unit
+// +build unit
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/PatcharaKL/assessment/config"
+	"github.com/PatcharaKL/assessment/migrations"
+	"github.com/stretchr/testify/assert"
+)
+
+// setupCommand points the one-shot commands at a mock database and captures
+// what they print.
+func setupCommand(t *testing.T, input string) (sqlmock.Sqlmock, *bytes.Buffer, *bytes.Buffer) {
+	db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	t.Setenv("DATABASE_URL", "postgres://localhost/expenses")
+
+	out, errOut := &bytes.Buffer{}, &bytes.Buffer{}
+	oldConnect, oldStdout, oldStdin := connect, stdout, stdin
+	connect = func(context.Context, config.Config) (*sql.DB, error) { return db, nil }
+	stdout, stdin = out, strings.NewReader(input)
+	t.Cleanup(func() { connect, stdout, stdin = oldConnect, oldStdout, oldStdin })
+	return mock, out, errOut
+}
+
+// appliedVersions reports every migration but the last as applied.
+func appliedVersions(t *testing.T) (*sqlmock.Rows, migrations.Migration) {
+	all, err := migrations.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := sqlmock.NewRows([]string{"version"})
+	for _, m := range all[:len(all)-1] {
+		rows.AddRow(m.Version)
+	}
+	return rows, all[len(all)-1]
+}
+
+func TestRunUnknownCommand(t *testing.T) {
+	// Arrange
+	_, _, errOut := setupCommand(t, "")
+
+	// Act
+	code := run(context.Background(), []string{"frobnicate"}, errOut)
+
+	// Assert
+	assert.Equal(t, 2, code)
+	assert.Contains(t, errOut.String(), `unknown command "frobnicate"`)
+}
+
+func TestCheckInvalidConfig(t *testing.T) {
+	// Arrange
+	_, out, errOut := setupCommand(t, "")
+
+	// Act
+	code := run(context.Background(), []string{"check", "-auth-mode", "none"}, errOut)
+
+	// Assert
+	assert.Equal(t, 2, code)
+	assert.Empty(t, out.String())
+	assert.Contains(t, errOut.String(), "auth mode must be basic, apikey or both")
+}
+
+func TestCheckReportsPendingMigrations(t *testing.T) {
+	// Arrange
+	mock, out, errOut := setupCommand(t, "")
+	applied, last := appliedVersions(t)
+	mock.ExpectPing()
+	mock.ExpectQuery("SELECT version FROM schema_migrations").WillReturnRows(applied)
+
+	// Act
+	code := run(context.Background(), []string{"check"}, errOut)
+
+	// Assert
+	assert.Equal(t, 0, code, errOut.String())
+	assert.Equal(t, "config: ok\ndatabase: ok\nmigrations: 1 pending ("+last.Name+")\n", out.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCheckDatabaseUnreachable(t *testing.T) {
+	// Arrange
+	mock, out, errOut := setupCommand(t, "")
+	mock.ExpectPing().WillReturnError(sql.ErrConnDone)
+
+	// Act
+	code := run(context.Background(), []string{"check"}, errOut)
+
+	// Assert
+	assert.Equal(t, 1, code)
+	assert.Equal(t, "config: ok\n", out.String())
+	assert.Contains(t, errOut.String(), "server check: database:")
+}
+
+func TestMigrateStatus(t *testing.T) {
+	// Arrange
+	mock, out, errOut := setupCommand(t, "")
+	applied, last := appliedVersions(t)
+	mock.ExpectQuery("SELECT version FROM schema_migrations").WillReturnRows(applied)
+
+	// Act
+	code := run(context.Background(), []string{"migrate", "status"}, errOut)
+
+	// Assert
+	assert.Equal(t, 0, code, errOut.String())
+	assert.Contains(t, out.String(), "applied  0001_create_expenses\n")
+	assert.True(t, strings.HasSuffix(out.String(), "pending  "+last.Name+"\n"), out.String())
+}
+
+func TestSeedDemoData(t *testing.T) {
+	// Arrange
+	mock, out, errOut := setupCommand(t, "")
+	mock.ExpectQuery("SELECT id, title, amount, note, tags, version, updated_at FROM expenses").
+		WithArgs("demo").WillReturnRows(sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags", "version", "updated_at"}))
+	for i := range demoExpenses {
+		mock.ExpectBegin()
+		mock.ExpectQuery("INSERT INTO expenses").WithArgs(demoExpenses[i].Title, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "demo").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(i + 1))
+		mock.ExpectExec("SELECT pg_advisory_xact_lock").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("INSERT INTO outbox").WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(i+1, time.Now()))
+		mock.ExpectCommit()
+	}
+
+	// Act
+	code := run(context.Background(), []string{"seed", "-owner", "demo"}, errOut)
+
+	// Assert
+	assert.Equal(t, 0, code, errOut.String())
+	assert.Equal(t, "seeded 8 expenses for demo\n", out.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSeedRefusesExistingOwner(t *testing.T) {
+	// Arrange
+	mock, _, errOut := setupCommand(t, "")
+	mock.ExpectQuery("SELECT id, title, amount, note, tags, version, updated_at FROM expenses").
+		WithArgs("demo").WillReturnRows(sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags", "version", "updated_at"}).AddRow(1, "pad thai", 60, "", "{food}", 1, time.Now()))
+
+	// Act
+	code := run(context.Background(), []string{"seed", "-owner", "demo"}, errOut)
+
+	// Assert
+	assert.Equal(t, 1, code)
+	assert.Contains(t, errOut.String(), "demo already has 1 expenses, use -force to add more")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSeedAssignsUnownedExpenses(t *testing.T) {
+	// Arrange
+	mock, out, errOut := setupCommand(t, "")
+	mock.ExpectExec("UPDATE expenses SET owner = \\$1(.+)WHERE owner IS NULL").WithArgs("demo").WillReturnResult(sqlmock.NewResult(0, 3))
+
+	// Act
+	code := run(context.Background(), []string{"seed", "-owner", "demo", "-unowned"}, errOut)
+
+	// Assert
+	assert.Equal(t, 0, code, errOut.String())
+	assert.Equal(t, "gave 3 unowned expenses to demo\n", out.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUserCreateReadsPasswordFromStdin(t *testing.T) {
+	// Arrange
+	mock, out, errOut := setupCommand(t, "s3cret\n")
+	mock.ExpectQuery("INSERT INTO users").WithArgs("ops", sqlmock.AnyArg(), "admin", "").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(7, time.Now()))
+
+	// Act
+	code := run(context.Background(), []string{"user", "create", "-username", "ops", "-role", "admin", "-password-stdin"}, errOut)
+
+	// Assert
+	assert.Equal(t, 0, code, errOut.String())
+	assert.Equal(t, "created admin user ops (id 7)\n", out.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUserResetPasswordGeneratesOne(t *testing.T) {
+	// Arrange
+	mock, out, errOut := setupCommand(t, "")
+	mock.ExpectExec("UPDATE users SET password_hash").WithArgs("ops", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// Act
+	code := run(context.Background(), []string{"user", "reset-password", "-username", "ops"}, errOut)
+
+	// Assert
+	assert.Equal(t, 0, code, errOut.String())
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if assert.Len(t, lines, 2) {
+		assert.Equal(t, "reset the password of ops", lines[0])
+		assert.Regexp(t, `^password: [A-Za-z0-9_-]{24}$`, lines[1])
+	}
+}
+
+func TestUserDisableUnknownUser(t *testing.T) {
+	// Arrange
+	mock, _, errOut := setupCommand(t, "")
+	mock.ExpectExec("UPDATE users SET disabled = true").WithArgs("ghost").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// Act
+	code := run(context.Background(), []string{"user", "disable", "-username", "ghost"}, errOut)
+
+	// Assert
+	assert.Equal(t, 1, code)
+	assert.Contains(t, errOut.String(), "user ghost not found")
+}
+
+func TestWaitForDB(t *testing.T) {
+	tests := []struct {
+		name    string
+		wait    time.Duration
+		fails   int
+		wantErr string
+	}{
+		{name: "testRetriesUntilUp", wait: time.Second, fails: 2},
+		{name: "testGivesUp", wait: 30 * time.Millisecond, fails: 100, wantErr: "can't connect to database after"},
+		{name: "testTriesOnceWithoutWait", fails: 1, wantErr: "can't connect to database: dial tcp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			for i := 0; i < tt.fails; i++ {
+				mock.ExpectPing().WillReturnError(errors.New("dial tcp 127.0.0.1:5432: connect: connection refused"))
+			}
+			mock.ExpectPing()
+			cfg := config.Default()
+			cfg.DB.StartupWait = tt.wait
+			cfg.DB.StartupBackoff = time.Millisecond
+			cfg.DB.StartupMaxBackoff = 4 * time.Millisecond
+			var logs bytes.Buffer
+
+			// Act
+			err = waitForDB(context.Background(), db, cfg, slog.New(slog.NewTextHandler(&logs, nil)))
+
+			// Assert
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.fails, strings.Count(logs.String(), "retrying"))
+		})
+	}
+}
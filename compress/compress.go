@@ -0,0 +1,215 @@
+// Package compress compresses responses with brotli or gzip, whichever the
+// client prefers of those its Accept-Encoding allows.
+package compress
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	Brotli = "br"
+	Gzip   = "gzip"
+
+	headerETag        = "ETag"
+	headerIfNoneMatch = "If-None-Match"
+	headerIfMatch     = "If-Match"
+
+	// brotliLevel trades ratio for speed; responses are compressed on
+	// every request, so the slower levels don't pay off.
+	brotliLevel = 5
+)
+
+// encodings are the supported encodings, preferred first when the client
+// accepts several equally.
+var encodings = []string{Brotli, Gzip}
+
+var pools = map[string]*sync.Pool{
+	Brotli: {New: func() any { return brotli.NewWriterLevel(io.Discard, brotliLevel) }},
+	Gzip:   {New: func() any { return gzip.NewWriter(io.Discard) }},
+}
+
+// compressor is what brotli.Writer and gzip.Writer have in common.
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+// negotiate returns the encoding to use for a request with the given
+// Accept-Encoding header, or "" to send the response as it is.
+func negotiate(accept string) string {
+	q := map[string]float64{}
+	for _, part := range strings.Split(accept, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		weight := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			w, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			weight = w
+		}
+		if coding != "" {
+			q[coding] = weight
+		}
+	}
+
+	best, bestQ := "", 0.0
+	for _, enc := range encodings {
+		w, ok := q[enc]
+		if !ok {
+			w = q["*"]
+		}
+		if w > bestQ {
+			best, bestQ = enc, w
+		}
+	}
+	return best
+}
+
+// compressible reports whether responses of the content type are worth
+// compressing. Event streams are left alone so each event reaches the
+// client as soon as it is flushed.
+func compressible(contentType string) bool {
+	mt, _, _ := strings.Cut(contentType, ";")
+	mt = strings.ToLower(strings.TrimSpace(mt))
+	switch {
+	case mt == "text/event-stream":
+		return false
+	case strings.HasPrefix(mt, "text/"):
+		return true
+	case mt == echo.MIMEApplicationJSON, mt == echo.MIMEApplicationJavaScript, mt == echo.MIMEApplicationXML:
+		return true
+	}
+	return strings.HasSuffix(mt, "+json") || strings.HasSuffix(mt, "+xml")
+}
+
+// tag marks a strong entity tag with the encoding, since the compressed
+// bytes are a different representation. Weak tags are left as they are.
+func tag(h http.Header, enc string) {
+	etag := h.Get(headerETag)
+	if len(etag) < 2 || !strings.HasPrefix(etag, `"`) || !strings.HasSuffix(etag, `"`) {
+		return
+	}
+	h.Set(headerETag, etag[:len(etag)-1]+"-"+enc+`"`)
+}
+
+// untag removes the encoding from the tags in an If-None-Match or If-Match
+// header, so the handler compares them with the tags it knows.
+func untag(inm, enc string) string {
+	return strings.ReplaceAll(inm, "-"+enc+`"`, `"`)
+}
+
+type writer struct {
+	http.ResponseWriter
+	enc         string
+	head        bool
+	wroteHeader bool
+	cw          compressor
+}
+
+func (w *writer) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	h := w.Header()
+	switch {
+	case code == http.StatusNotModified:
+		// The tag is the one a full response would carry.
+		tag(h, w.enc)
+	case code >= http.StatusOK && code != http.StatusNoContent && code != http.StatusPartialContent &&
+		h.Get(echo.HeaderContentEncoding) == "" && compressible(h.Get(echo.HeaderContentType)):
+		h.Set(echo.HeaderContentEncoding, w.enc)
+		h.Del(echo.HeaderContentLength)
+		tag(h, w.enc)
+		if !w.head {
+			w.cw = pools[w.enc].Get().(compressor)
+			w.cw.Reset(w.ResponseWriter)
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *writer) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.cw != nil {
+		return w.cw.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *writer) Flush() {
+	if w.cw != nil {
+		w.cw.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the connection's writer.
+func (w *writer) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// close finishes the compressed body and returns the compressor to its pool.
+func (w *writer) close() {
+	if w.cw == nil {
+		return
+	}
+	w.cw.Close()
+	w.cw.Reset(io.Discard)
+	pools[w.enc].Put(w.cw)
+	w.cw = nil
+}
+
+// Middleware compresses the response when the client accepts an encoding
+// and the content type is worth it. Every response varies on
+// Accept-Encoding. Strong entity tags get the encoding appended, and
+// If-None-Match and If-Match have it removed before the handler sees them,
+// so conditional requests work the same whether or not the response is
+// compressed.
+//
+// Errors are written here, while the response can still be compressed.
+func Middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		res := c.Response()
+		res.Header().Add(echo.HeaderVary, echo.HeaderAcceptEncoding)
+
+		req := c.Request()
+		enc := negotiate(req.Header.Get(echo.HeaderAcceptEncoding))
+		if enc == "" {
+			return next(c)
+		}
+		for _, h := range []string{headerIfNoneMatch, headerIfMatch} {
+			if tags := req.Header.Get(h); tags != "" {
+				req.Header.Set(h, untag(tags, enc))
+			}
+		}
+
+		w := &writer{ResponseWriter: res.Writer, enc: enc, head: req.Method == http.MethodHead}
+		res.Writer = w
+		defer func() {
+			w.close()
+			res.Writer = w.ResponseWriter
+		}()
+
+		if err := next(c); err != nil {
+			c.Error(err)
+		}
+		return nil
+	}
+}
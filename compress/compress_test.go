@@ -0,0 +1,192 @@
+//go:build unit
+// +build unit
+
+package compress
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiateU(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", Gzip},
+		{"gzip, deflate, br", Brotli},
+		{"br;q=0.5, gzip", Gzip},
+		{"br;q=0, gzip;q=0", ""},
+		{"*", Brotli},
+		{"*;q=0.5, br;q=0", Gzip},
+		{"GZIP;q=0.8", Gzip},
+	}
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			assert.Equal(t, tt.want, negotiate(tt.accept))
+		})
+	}
+}
+
+const body = `[{"id":1,"title":"strawberry smoothie","amount":79,"note":"night market promotion discount 10 bath","tags":["food","beverage"]}]`
+
+func setupServer() *echo.Echo {
+	e := echo.New()
+	e.Use(Middleware)
+	e.GET("/expenses", func(c echo.Context) error {
+		if c.Request().Header.Get("If-None-Match") == `"v1"` {
+			c.Response().Header().Set("ETag", `"v1"`)
+			return c.NoContent(http.StatusNotModified)
+		}
+		c.Response().Header().Set("ETag", `"v1"`)
+		return c.Blob(http.StatusOK, echo.MIMEApplicationJSONCharsetUTF8, []byte(body))
+	})
+	e.PUT("/expenses/1", func(c echo.Context) error {
+		if c.Request().Header.Get("If-Match") != `"v1"` {
+			return c.NoContent(http.StatusPreconditionFailed)
+		}
+		return c.NoContent(http.StatusNoContent)
+	})
+	e.GET("/image", func(c echo.Context) error {
+		return c.Blob(http.StatusOK, "image/png", []byte(body))
+	})
+	e.GET("/events", func(c echo.Context) error {
+		return c.Blob(http.StatusOK, "text/event-stream", []byte("data: {}\n\n"))
+	})
+	e.GET("/missing", func(c echo.Context) error {
+		return echo.NewHTTPError(http.StatusNotFound, "expense 7 not found")
+	})
+	return e
+}
+
+func get(e *echo.Echo, path string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func decode(t *testing.T, enc string, r io.Reader) string {
+	t.Helper()
+	var dr io.Reader
+	switch enc {
+	case Gzip:
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dr = gr
+	case Brotli:
+		dr = brotli.NewReader(r)
+	default:
+		dr = r
+	}
+	b, err := io.ReadAll(dr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestMiddlewareCompressesU(t *testing.T) {
+	for _, enc := range []string{Gzip, Brotli} {
+		t.Run(enc, func(t *testing.T) {
+			// Arrange
+			e := setupServer()
+
+			// Act
+			rec := get(e, "/expenses", map[string]string{"Accept-Encoding": enc})
+
+			// Assert
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, enc, rec.Header().Get("Content-Encoding"))
+			assert.Equal(t, "Accept-Encoding", rec.Header().Get("Vary"))
+			assert.Equal(t, `"v1-`+enc+`"`, rec.Header().Get("ETag"))
+			assert.Equal(t, body, decode(t, enc, rec.Body))
+		})
+	}
+}
+
+func TestMiddlewareLeavesResponsesU(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   string
+		accept string
+	}{
+		{"testNotAccepted", "/expenses", ""},
+		{"testIncompressible", "/image", "gzip"},
+		{"testEventStream", "/events", "gzip"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			e := setupServer()
+
+			// Act
+			rec := get(e, tt.path, map[string]string{"Accept-Encoding": tt.accept})
+
+			// Assert
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Empty(t, rec.Header().Get("Content-Encoding"))
+			assert.Equal(t, "Accept-Encoding", rec.Header().Get("Vary"))
+			assert.False(t, strings.Contains(rec.Header().Get("ETag"), "-gzip"))
+		})
+	}
+}
+
+func TestMiddlewareConditionalRequestsU(t *testing.T) {
+	// Arrange
+	e := setupServer()
+
+	// Act
+	notModified := get(e, "/expenses", map[string]string{"Accept-Encoding": Gzip, "If-None-Match": `"v1-gzip"`})
+	otherEncoding := get(e, "/expenses", map[string]string{"Accept-Encoding": Brotli, "If-None-Match": `"v1-gzip"`})
+
+	// Assert
+	assert.Equal(t, http.StatusNotModified, notModified.Code)
+	assert.Equal(t, `"v1-gzip"`, notModified.Header().Get("ETag"))
+	assert.Empty(t, notModified.Body.String())
+	assert.Equal(t, http.StatusOK, otherEncoding.Code, "a tag of another encoding doesn't match")
+	assert.Equal(t, `"v1-br"`, otherEncoding.Header().Get("ETag"))
+}
+
+func TestMiddlewareIfMatchU(t *testing.T) {
+	// Arrange
+	e := setupServer()
+	req := httptest.NewRequest(http.MethodPut, "/expenses/1", nil)
+	req.Header.Set("Accept-Encoding", Gzip)
+	req.Header.Set("If-Match", `"v1-gzip"`)
+	rec := httptest.NewRecorder()
+
+	// Act
+	e.ServeHTTP(rec, req)
+
+	// Assert
+	assert.Equal(t, http.StatusNoContent, rec.Code, "the tag of a compressed response matches")
+}
+
+func TestMiddlewareCompressesErrorsU(t *testing.T) {
+	// Arrange
+	e := setupServer()
+
+	// Act
+	rec := get(e, "/missing", map[string]string{"Accept-Encoding": Gzip})
+
+	// Assert
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, Gzip, rec.Header().Get("Content-Encoding"))
+	assert.Contains(t, decode(t, Gzip, rec.Body), "expense 7 not found")
+}
@@ -0,0 +1,89 @@
+// Package conditional answers conditional GET requests, as described in RFC
+// 9110 section 13. A response carries validators, an entity tag and a last
+// modification time; a client that sends them back with If-None-Match or
+// If-Modified-Since is told 304 Not Modified, without the body, while its
+// copy is current.
+package conditional
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Validators identify the state of a representation. ETag is a quoted
+// entity tag; LastModified is the zero time when unknown.
+type Validators struct {
+	ETag         string    `json:"etag"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+// NotModified sets v on the response and reports whether the request's
+// preconditions say the client's copy is current, in which case the handler
+// responds 304 without a body. If-Modified-Since is only consulted without
+// If-None-Match, and only to the second, the precision of HTTP dates.
+func NotModified(c echo.Context, v Validators) bool {
+	h := c.Response().Header()
+	if v.ETag != "" {
+		h.Set("ETag", v.ETag)
+	}
+	if !v.LastModified.IsZero() {
+		h.Set(echo.HeaderLastModified, v.LastModified.UTC().Format(http.TimeFormat))
+	}
+
+	req := c.Request()
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		return v.ETag != "" && matches(inm, v.ETag)
+	}
+	if ims := req.Header.Get(echo.HeaderIfModifiedSince); ims != "" && !v.LastModified.IsZero() {
+		t, err := http.ParseTime(ims)
+		return err == nil && !v.LastModified.Truncate(time.Second).After(t)
+	}
+	return false
+}
+
+// Matches reports whether the If-Match list holds etag, or is "*", in which
+// case a write may go ahead. If-Match compares tags strongly: a weak tag never
+// matches.
+func Matches(list, etag string) bool {
+	return inList(list, etag, false)
+}
+
+// matches reports whether the If-None-Match list holds etag, or is "*".
+// If-None-Match compares tags weakly: W/ prefixes are ignored.
+func matches(list, etag string) bool {
+	return inList(list, etag, true)
+}
+
+func inList(list, etag string, weak bool) bool {
+	if weak {
+		etag = strings.TrimPrefix(etag, "W/")
+	} else if strings.HasPrefix(etag, "W/") {
+		return strings.TrimSpace(list) == "*"
+	}
+	for list != "" {
+		list = strings.TrimLeft(list, " \t,")
+		if strings.HasPrefix(list, "*") {
+			return true
+		}
+		isWeak := strings.HasPrefix(list, "W/")
+		list = strings.TrimPrefix(list, "W/")
+		if !strings.HasPrefix(list, `"`) {
+			return false
+		}
+		end := strings.IndexByte(list[1:], '"')
+		if end < 0 {
+			return false
+		}
+		if list[:end+2] == etag && (weak || !isWeak) {
+			return true
+		}
+		list = list[end+2:]
+	}
+	return false
+}
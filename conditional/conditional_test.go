@@ -0,0 +1,88 @@
+//go:build unit
+// +build unit
+
+package conditional
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotModifiedU(t *testing.T) {
+	modified := time.Date(2023, 3, 1, 12, 0, 0, 500, time.UTC)
+	v := Validators{ETag: `"a1"`, LastModified: modified}
+	tests := []struct {
+		name    string
+		method  string
+		header  map[string]string
+		v       Validators
+		wantNot bool
+	}{
+		{name: "testNoPreconditions", v: v},
+		{name: "testETagMatches", header: map[string]string{"If-None-Match": `"a1"`}, v: v, wantNot: true},
+		{name: "testETagInList", header: map[string]string{"If-None-Match": `"zz", W/"a1"`}, v: v, wantNot: true},
+		{name: "testETagDiffers", header: map[string]string{"If-None-Match": `"a0"`}, v: v},
+		{name: "testAnyETag", header: map[string]string{"If-None-Match": "*"}, v: v, wantNot: true},
+		{name: "testETagTakesPrecedence", header: map[string]string{"If-None-Match": `"a0"`, "If-Modified-Since": "Wed, 01 Mar 2023 13:00:00 GMT"}, v: v},
+		{name: "testNotModifiedSince", header: map[string]string{"If-Modified-Since": "Wed, 01 Mar 2023 12:00:00 GMT"}, v: v, wantNot: true},
+		{name: "testModifiedSince", header: map[string]string{"If-Modified-Since": "Wed, 01 Mar 2023 11:59:59 GMT"}, v: v},
+		{name: "testInvalidDate", header: map[string]string{"If-Modified-Since": "yesterday"}, v: v},
+		{name: "testUnknownModification", header: map[string]string{"If-Modified-Since": "Wed, 01 Mar 2023 12:00:00 GMT"}, v: Validators{ETag: `"a1"`}},
+		{name: "testNotForWrites", method: http.MethodPut, header: map[string]string{"If-None-Match": `"a1"`}, v: v},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, "/expenses", nil)
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(req, rec)
+
+			// Act
+			notModified := NotModified(c, tt.v)
+
+			// Assert
+			assert.Equal(t, tt.wantNot, notModified)
+			assert.Equal(t, tt.v.ETag, rec.Header().Get("ETag"))
+			if !tt.v.LastModified.IsZero() {
+				assert.Equal(t, "Wed, 01 Mar 2023 12:00:00 GMT", rec.Header().Get(echo.HeaderLastModified))
+			}
+		})
+	}
+}
+
+func TestMatchesU(t *testing.T) {
+	tests := []struct {
+		name string
+		list string
+		etag string
+		want bool
+	}{
+		{"testSame", `"a1"`, `"a1"`, true},
+		{"testInList", `"zz", "a1"`, `"a1"`, true},
+		{"testAny", "*", `"a1"`, true},
+		{"testDiffers", `"a0"`, `"a1"`, false},
+		{"testWeakNeverMatches", `W/"a1"`, `"a1"`, false},
+		{"testMalformed", `a1`, `"a1"`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Act
+			got := Matches(tt.list, tt.etag)
+
+			// Assert
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,526 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	AuthBasic  = "basic"
+	AuthAPIKey = "apikey"
+	AuthBoth   = "both"
+)
+
+const (
+	CacheNone   = "none"
+	CacheMemory = "memory"
+	CacheRedis  = "redis"
+)
+
+type DB struct {
+	URL          string `yaml:"url" toml:"url"`
+	MaxOpenConns int    `yaml:"max_open_conns" toml:"max_open_conns"`
+	MaxIdleConns int    `yaml:"max_idle_conns" toml:"max_idle_conns"`
+	// ConnMaxLifetime closes connections older than this, so the pool
+	// follows failovers and load balancer changes; zero keeps them.
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" toml:"conn_max_lifetime"`
+	// StartupWait is how long commands wait for the database to accept
+	// connections before giving up, retrying after StartupBackoff and
+	// doubling the wait up to StartupMaxBackoff; zero tries once.
+	StartupWait       time.Duration `yaml:"startup_wait" toml:"startup_wait"`
+	StartupBackoff    time.Duration `yaml:"startup_backoff" toml:"startup_backoff"`
+	StartupMaxBackoff time.Duration `yaml:"startup_max_backoff" toml:"startup_max_backoff"`
+	// StatementTimeout cancels a statement, and the reading of its rows,
+	// that runs longer; zero leaves statements to the request's deadline.
+	// Migrations are exempt.
+	StatementTimeout time.Duration `yaml:"statement_timeout" toml:"statement_timeout"`
+}
+
+type Timeouts struct {
+	Read      time.Duration `yaml:"read" toml:"read"`
+	Write     time.Duration `yaml:"write" toml:"write"`
+	Idle      time.Duration `yaml:"idle" toml:"idle"`
+	Shutdown  time.Duration `yaml:"shutdown" toml:"shutdown"`
+	Readiness time.Duration `yaml:"readiness" toml:"readiness"`
+	// Drain is how long /readyz reports not ready before the server stops
+	// accepting connections, giving load balancers time to notice.
+	Drain time.Duration `yaml:"drain" toml:"drain"`
+}
+
+type Tracing struct {
+	Exporter    string `yaml:"exporter" toml:"exporter"`
+	Endpoint    string `yaml:"endpoint" toml:"endpoint"`
+	ServiceName string `yaml:"service_name" toml:"service_name"`
+}
+
+type Webhooks struct {
+	// MaxAttempts is how many times a delivery is tried before it is left
+	// dead for someone to redeliver.
+	MaxAttempts int           `yaml:"max_attempts" toml:"max_attempts"`
+	Timeout     time.Duration `yaml:"timeout" toml:"timeout"`
+}
+
+// Notify is how the listener for changes made on other replicas reconnects
+// after it loses its connection: it waits MinReconnect, doubling up to
+// MaxReconnect while the database is unreachable.
+type Notify struct {
+	MinReconnect time.Duration `yaml:"min_reconnect" toml:"min_reconnect"`
+	MaxReconnect time.Duration `yaml:"max_reconnect" toml:"max_reconnect"`
+}
+
+// Cache is where expense reads are cached: nowhere, in an LRU of up to Size
+// results in each replica, or in the Redis server at RedisURL shared by
+// them all. Results are kept for up to TTL.
+type Cache struct {
+	Backend  string        `yaml:"backend" toml:"backend"`
+	Size     int           `yaml:"size" toml:"size"`
+	TTL      time.Duration `yaml:"ttl" toml:"ttl"`
+	RedisURL string        `yaml:"redis_url" toml:"redis_url"`
+}
+
+// Bootstrap is the admin account created when the users table is empty, so
+// a fresh install can sign in. The server refuses to start on an empty table
+// without it; once any user exists it is ignored.
+type Bootstrap struct {
+	User     string `yaml:"user" toml:"user"`
+	Password string `yaml:"password" toml:"password"`
+}
+
+type Config struct {
+	Addr           string        `yaml:"addr" toml:"addr"`
+	GRPCAddr       string        `yaml:"grpc_addr" toml:"grpc_addr"`
+	DB             DB            `yaml:"db" toml:"db"`
+	Timeouts       Timeouts      `yaml:"timeouts" toml:"timeouts"`
+	AuthMode       string        `yaml:"auth_mode" toml:"auth_mode"`
+	LogLevel       string        `yaml:"log_level" toml:"log_level"`
+	IdempotencyTTL time.Duration `yaml:"idempotency_ttl" toml:"idempotency_ttl"`
+	SlowQuery      time.Duration `yaml:"slow_query" toml:"slow_query"`
+	// GraphQLComplexity is the highest complexity a GraphQL query may have.
+	// A list field counts as its page size times the fields of each element.
+	GraphQLComplexity int       `yaml:"graphql_complexity" toml:"graphql_complexity"`
+	Tracing           Tracing   `yaml:"tracing" toml:"tracing"`
+	Webhooks          Webhooks  `yaml:"webhooks" toml:"webhooks"`
+	Notify            Notify    `yaml:"notify" toml:"notify"`
+	Cache             Cache     `yaml:"cache" toml:"cache"`
+	Bootstrap         Bootstrap `yaml:"bootstrap" toml:"bootstrap"`
+}
+
+func Default() Config {
+	return Config{
+		Addr:     ":2565",
+		GRPCAddr: ":2566",
+		DB: DB{
+			MaxOpenConns:      25,
+			MaxIdleConns:      25,
+			ConnMaxLifetime:   30 * time.Minute,
+			StartupWait:       time.Minute,
+			StartupBackoff:    500 * time.Millisecond,
+			StartupMaxBackoff: 10 * time.Second,
+			StatementTimeout:  5 * time.Second,
+		},
+		Timeouts: Timeouts{
+			Read:      10 * time.Second,
+			Write:     10 * time.Second,
+			Idle:      60 * time.Second,
+			Shutdown:  10 * time.Second,
+			Readiness: 2 * time.Second,
+		},
+		AuthMode:          AuthBoth,
+		LogLevel:          "info",
+		IdempotencyTTL:    24 * time.Hour,
+		SlowQuery:         200 * time.Millisecond,
+		GraphQLComplexity: 1000,
+		Tracing: Tracing{
+			Exporter:    "none",
+			ServiceName: "expenses",
+		},
+		Webhooks: Webhooks{
+			MaxAttempts: 8,
+			Timeout:     10 * time.Second,
+		},
+		Notify: Notify{
+			MinReconnect: time.Second,
+			MaxReconnect: time.Minute,
+		},
+		Cache: Cache{
+			Backend: CacheMemory,
+			Size:    10000,
+			TTL:     time.Minute,
+		},
+	}
+}
+
+// Load builds the configuration from, in increasing precedence, the
+// defaults, an optional YAML or TOML file, environment variables and the
+// command-line flags in args. The file is named by -config or CONFIG_FILE.
+func Load(args []string) (Config, error) {
+	return Parse(flag.NewFlagSet("server", flag.ContinueOnError), args)
+}
+
+// Parse is Load with the flags registered on fs, which may already define
+// flags of its own; fs.Args holds the arguments left after parsing.
+func Parse(fs *flag.FlagSet, args []string) (Config, error) {
+	cfg := Default()
+
+	file := fs.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML or TOML config file")
+	addr := fs.String("addr", "", "listen address, e.g. :2565")
+	grpcAddr := fs.String("grpc-addr", "", "gRPC listen address, e.g. :2566")
+	dbURL := fs.String("database-url", "", "PostgreSQL connection string")
+	maxOpen := fs.Int("db-max-open-conns", 0, "maximum open database connections")
+	maxIdle := fs.Int("db-max-idle-conns", 0, "maximum idle database connections")
+	connMaxLifetime := fs.Duration("db-conn-max-lifetime", 0, "close database connections older than this; 0 keeps them")
+	startupWait := fs.Duration("db-startup-wait", 0, "how long to wait for the database at startup; 0 tries once")
+	startupBackoff := fs.Duration("db-startup-backoff", 0, "first wait between database connection attempts at startup")
+	startupMaxBackoff := fs.Duration("db-startup-max-backoff", 0, "longest wait between database connection attempts at startup")
+	statementTimeout := fs.Duration("db-statement-timeout", 0, "cancel database statements that run longer; 0 disables")
+	readTimeout := fs.Duration("read-timeout", 0, "HTTP server read timeout")
+	writeTimeout := fs.Duration("write-timeout", 0, "HTTP server write timeout")
+	idleTimeout := fs.Duration("idle-timeout", 0, "HTTP server idle timeout")
+	shutdownTimeout := fs.Duration("shutdown-timeout", 0, "graceful shutdown timeout")
+	readinessTimeout := fs.Duration("readiness-timeout", 0, "timeout for the /readyz dependency checks")
+	drainTimeout := fs.Duration("drain-timeout", 0, "how long to report not ready before shutting down")
+	authMode := fs.String("auth-mode", "", "authentication mode: basic, apikey or both")
+	logLevel := fs.String("log-level", "", "log level: debug, info, warn or error")
+	idempotencyTTL := fs.Duration("idempotency-ttl", 0, "how long Idempotency-Key responses are kept")
+	slowQuery := fs.Duration("slow-query", 0, "log queries slower than this")
+	graphQLComplexity := fs.Int("graphql-complexity", 0, "maximum GraphQL query complexity")
+	tracingExporter := fs.String("tracing-exporter", "", "trace exporter: none, stdout or otlp")
+	tracingEndpoint := fs.String("tracing-endpoint", "", "OTLP/HTTP endpoint URL, e.g. http://localhost:4318")
+	webhookMaxAttempts := fs.Int("webhook-max-attempts", 0, "attempts before a webhook delivery is dead")
+	webhookTimeout := fs.Duration("webhook-timeout", 0, "timeout of each webhook delivery attempt")
+	notifyMinReconnect := fs.Duration("notify-min-reconnect", 0, "first wait before the change listener reconnects")
+	notifyMaxReconnect := fs.Duration("notify-max-reconnect", 0, "longest wait between change listener reconnects")
+	cacheBackend := fs.String("cache-backend", "", "where expense reads are cached: none, memory or redis")
+	cacheSize := fs.Int("cache-size", 0, "results kept by the memory cache")
+	cacheTTL := fs.Duration("cache-ttl", 0, "how long cached results are kept")
+	redisURL := fs.String("redis-url", "", "Redis server of the redis cache, e.g. redis://localhost:6379/0")
+	bootstrapUser := fs.String("bootstrap-user", "", "admin created on an empty users table; the password is read from BOOTSTRAP_ADMIN_PASSWORD")
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	if *file != "" {
+		if err := loadFile(*file, &cfg); err != nil {
+			return cfg, err
+		}
+	}
+
+	if err := loadEnv(&cfg); err != nil {
+		return cfg, err
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "addr":
+			cfg.Addr = *addr
+		case "grpc-addr":
+			cfg.GRPCAddr = *grpcAddr
+		case "database-url":
+			cfg.DB.URL = *dbURL
+		case "db-max-open-conns":
+			cfg.DB.MaxOpenConns = *maxOpen
+		case "db-max-idle-conns":
+			cfg.DB.MaxIdleConns = *maxIdle
+		case "cache-backend":
+			cfg.Cache.Backend = *cacheBackend
+		case "cache-size":
+			cfg.Cache.Size = *cacheSize
+		case "cache-ttl":
+			cfg.Cache.TTL = *cacheTTL
+		case "redis-url":
+			cfg.Cache.RedisURL = *redisURL
+		case "bootstrap-user":
+			cfg.Bootstrap.User = *bootstrapUser
+		case "db-conn-max-lifetime":
+			cfg.DB.ConnMaxLifetime = *connMaxLifetime
+		case "db-startup-wait":
+			cfg.DB.StartupWait = *startupWait
+		case "db-startup-backoff":
+			cfg.DB.StartupBackoff = *startupBackoff
+		case "db-startup-max-backoff":
+			cfg.DB.StartupMaxBackoff = *startupMaxBackoff
+		case "db-statement-timeout":
+			cfg.DB.StatementTimeout = *statementTimeout
+		case "read-timeout":
+			cfg.Timeouts.Read = *readTimeout
+		case "write-timeout":
+			cfg.Timeouts.Write = *writeTimeout
+		case "idle-timeout":
+			cfg.Timeouts.Idle = *idleTimeout
+		case "shutdown-timeout":
+			cfg.Timeouts.Shutdown = *shutdownTimeout
+		case "readiness-timeout":
+			cfg.Timeouts.Readiness = *readinessTimeout
+		case "drain-timeout":
+			cfg.Timeouts.Drain = *drainTimeout
+		case "auth-mode":
+			cfg.AuthMode = *authMode
+		case "log-level":
+			cfg.LogLevel = *logLevel
+		case "idempotency-ttl":
+			cfg.IdempotencyTTL = *idempotencyTTL
+		case "slow-query":
+			cfg.SlowQuery = *slowQuery
+		case "graphql-complexity":
+			cfg.GraphQLComplexity = *graphQLComplexity
+		case "tracing-exporter":
+			cfg.Tracing.Exporter = *tracingExporter
+		case "tracing-endpoint":
+			cfg.Tracing.Endpoint = *tracingEndpoint
+		case "webhook-max-attempts":
+			cfg.Webhooks.MaxAttempts = *webhookMaxAttempts
+		case "webhook-timeout":
+			cfg.Webhooks.Timeout = *webhookTimeout
+		case "notify-min-reconnect":
+			cfg.Notify.MinReconnect = *notifyMinReconnect
+		case "notify-max-reconnect":
+			cfg.Notify.MaxReconnect = *notifyMaxReconnect
+		}
+	})
+
+	return cfg, cfg.Validate()
+}
+
+func loadFile(path string, cfg *Config) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config: can't read %s: %w", path, err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, cfg)
+	case ".toml":
+		err = toml.Unmarshal(b, cfg)
+	default:
+		return fmt.Errorf("config: unsupported file type %q, use .yaml, .yml or .toml", filepath.Ext(path))
+	}
+	if err != nil {
+		return fmt.Errorf("config: can't parse %s: %w", path, err)
+	}
+	return nil
+}
+
+// loadEnv applies environment variables. PORT and GRPC_PORT accept both
+// "2565" and ":2565"; DATABASE_STR is still read when DATABASE_URL is unset.
+func loadEnv(cfg *Config) error {
+	ports := map[string]*string{
+		"PORT":      &cfg.Addr,
+		"GRPC_PORT": &cfg.GRPCAddr,
+	}
+	for name, dst := range ports {
+		if v := os.Getenv(name); v != "" {
+			if !strings.Contains(v, ":") {
+				v = ":" + v
+			}
+			*dst = v
+		}
+	}
+	if v := os.Getenv("DATABASE_URL"); v != "" {
+		cfg.DB.URL = v
+	} else if v := os.Getenv("DATABASE_STR"); v != "" {
+		cfg.DB.URL = v
+	}
+	if v := os.Getenv("AUTH_MODE"); v != "" {
+		cfg.AuthMode = v
+	}
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		cfg.LogLevel = v
+	}
+	if v := os.Getenv("TRACING_EXPORTER"); v != "" {
+		cfg.Tracing.Exporter = v
+	}
+	if v := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); v != "" {
+		cfg.Tracing.Endpoint = v
+	}
+	if v := os.Getenv("OTEL_SERVICE_NAME"); v != "" {
+		cfg.Tracing.ServiceName = v
+	}
+	if v := os.Getenv("CACHE_BACKEND"); v != "" {
+		cfg.Cache.Backend = v
+	}
+	if v := os.Getenv("REDIS_URL"); v != "" {
+		cfg.Cache.RedisURL = v
+	}
+	if v := os.Getenv("BOOTSTRAP_ADMIN_USER"); v != "" {
+		cfg.Bootstrap.User = v
+	}
+	if v := os.Getenv("BOOTSTRAP_ADMIN_PASSWORD"); v != "" {
+		cfg.Bootstrap.Password = v
+	}
+
+	ints := map[string]*int{
+		"DB_MAX_OPEN_CONNS":    &cfg.DB.MaxOpenConns,
+		"DB_MAX_IDLE_CONNS":    &cfg.DB.MaxIdleConns,
+		"GRAPHQL_COMPLEXITY":   &cfg.GraphQLComplexity,
+		"WEBHOOK_MAX_ATTEMPTS": &cfg.Webhooks.MaxAttempts,
+		"CACHE_SIZE":           &cfg.Cache.Size,
+	}
+	for name, dst := range ints {
+		if v := os.Getenv(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("config: %s must be an integer, got %q", name, v)
+			}
+			*dst = n
+		}
+	}
+
+	durations := map[string]*time.Duration{
+		"DB_CONN_MAX_LIFETIME":   &cfg.DB.ConnMaxLifetime,
+		"DB_STARTUP_WAIT":        &cfg.DB.StartupWait,
+		"DB_STARTUP_BACKOFF":     &cfg.DB.StartupBackoff,
+		"DB_STARTUP_MAX_BACKOFF": &cfg.DB.StartupMaxBackoff,
+		"DB_STATEMENT_TIMEOUT":   &cfg.DB.StatementTimeout,
+		"READ_TIMEOUT":           &cfg.Timeouts.Read,
+		"WRITE_TIMEOUT":          &cfg.Timeouts.Write,
+		"IDLE_TIMEOUT":           &cfg.Timeouts.Idle,
+		"SHUTDOWN_TIMEOUT":       &cfg.Timeouts.Shutdown,
+		"READINESS_TIMEOUT":      &cfg.Timeouts.Readiness,
+		"DRAIN_TIMEOUT":          &cfg.Timeouts.Drain,
+		"IDEMPOTENCY_TTL":        &cfg.IdempotencyTTL,
+		"SLOW_QUERY":             &cfg.SlowQuery,
+		"WEBHOOK_TIMEOUT":        &cfg.Webhooks.Timeout,
+		"NOTIFY_MIN_RECONNECT":   &cfg.Notify.MinReconnect,
+		"NOTIFY_MAX_RECONNECT":   &cfg.Notify.MaxReconnect,
+		"CACHE_TTL":              &cfg.Cache.TTL,
+	}
+	for name, dst := range durations {
+		if v := os.Getenv(name); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return fmt.Errorf("config: %s must be a duration such as 10s, got %q", name, v)
+			}
+			*dst = d
+		}
+	}
+	return nil
+}
+
+// Validate reports every invalid setting at once so a bad deployment fails
+// at startup with the full list.
+func (c Config) Validate() error {
+	var errs []string
+	if c.Addr == "" || !strings.Contains(c.Addr, ":") {
+		errs = append(errs, fmt.Sprintf("addr must be host:port or :port, got %q", c.Addr))
+	}
+	if c.GRPCAddr == "" || !strings.Contains(c.GRPCAddr, ":") {
+		errs = append(errs, fmt.Sprintf("grpc addr must be host:port or :port, got %q", c.GRPCAddr))
+	} else if c.GRPCAddr == c.Addr {
+		errs = append(errs, "grpc addr must differ from addr")
+	}
+	if c.DB.URL == "" {
+		errs = append(errs, "database url is required (DATABASE_URL or -database-url)")
+	}
+	if c.DB.MaxOpenConns < 0 {
+		errs = append(errs, "db max_open_conns must not be negative")
+	}
+	if c.DB.MaxIdleConns < 0 {
+		errs = append(errs, "db max_idle_conns must not be negative")
+	}
+	if c.DB.MaxOpenConns > 0 && c.DB.MaxIdleConns > c.DB.MaxOpenConns {
+		errs = append(errs, "db max_idle_conns must not exceed max_open_conns")
+	}
+	if c.DB.ConnMaxLifetime < 0 {
+		errs = append(errs, "db conn_max_lifetime must not be negative")
+	}
+	if c.DB.StartupWait < 0 {
+		errs = append(errs, "db startup_wait must not be negative")
+	}
+	if c.DB.StartupBackoff <= 0 {
+		errs = append(errs, "db startup_backoff must be positive")
+	} else if c.DB.StartupMaxBackoff < c.DB.StartupBackoff {
+		errs = append(errs, "db startup_max_backoff must not be less than startup_backoff")
+	}
+	if c.DB.StatementTimeout < 0 {
+		errs = append(errs, "db statement_timeout must not be negative")
+	}
+	if (c.Bootstrap.User == "") != (c.Bootstrap.Password == "") {
+		errs = append(errs, "bootstrap user and password must be set together")
+	}
+	timeouts := []struct {
+		name string
+		d    time.Duration
+	}{
+		{"read", c.Timeouts.Read},
+		{"write", c.Timeouts.Write},
+		{"idle", c.Timeouts.Idle},
+		{"shutdown", c.Timeouts.Shutdown},
+		{"readiness", c.Timeouts.Readiness},
+	}
+	for _, t := range timeouts {
+		if t.d <= 0 {
+			errs = append(errs, fmt.Sprintf("%s timeout must be positive", t.name))
+		}
+	}
+	switch c.AuthMode {
+	case AuthBasic, AuthAPIKey, AuthBoth:
+	default:
+		errs = append(errs, fmt.Sprintf("auth mode must be basic, apikey or both, got %q", c.AuthMode))
+	}
+	switch c.LogLevel {
+	case "debug", "info", "warn", "error":
+	default:
+		errs = append(errs, fmt.Sprintf("log level must be debug, info, warn or error, got %q", c.LogLevel))
+	}
+	if c.Timeouts.Drain < 0 {
+		errs = append(errs, "drain timeout must not be negative")
+	}
+	if c.IdempotencyTTL <= 0 {
+		errs = append(errs, "idempotency ttl must be positive")
+	}
+	if c.SlowQuery <= 0 {
+		errs = append(errs, "slow query threshold must be positive")
+	}
+	if c.GraphQLComplexity <= 0 {
+		errs = append(errs, "graphql complexity must be positive")
+	}
+	if c.Webhooks.MaxAttempts <= 0 {
+		errs = append(errs, "webhook max attempts must be positive")
+	}
+	if c.Webhooks.Timeout <= 0 {
+		errs = append(errs, "webhook timeout must be positive")
+	}
+	if c.Notify.MinReconnect <= 0 || c.Notify.MaxReconnect < c.Notify.MinReconnect {
+		errs = append(errs, "notify reconnect waits must be positive, the max no less than the min")
+	}
+	switch c.Cache.Backend {
+	case CacheNone:
+	case CacheMemory:
+		if c.Cache.Size <= 0 {
+			errs = append(errs, "cache size must be positive for the memory cache")
+		}
+	case CacheRedis:
+		if c.Cache.RedisURL == "" {
+			errs = append(errs, "redis url is required for the redis cache (REDIS_URL or -redis-url)")
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("cache backend must be none, memory or redis, got %q", c.Cache.Backend))
+	}
+	if c.Cache.Backend != CacheNone && c.Cache.TTL <= 0 {
+		errs = append(errs, "cache ttl must be positive")
+	}
+	switch c.Tracing.Exporter {
+	case "none", "stdout":
+	case "otlp":
+		if c.Tracing.Endpoint == "" {
+			errs = append(errs, "tracing endpoint is required for the otlp exporter")
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("tracing exporter must be none, stdout or otlp, got %q", c.Tracing.Exporter))
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errors.New("invalid config: " + strings.Join(errs, "; "))
+}
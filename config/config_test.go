@@ -0,0 +1,174 @@
+//go:build unit
+// +build unit
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadDefaultsU(t *testing.T) {
+	// Arrange
+	t.Setenv("DATABASE_URL", "postgres://localhost/expenses")
+
+	// Act
+	cfg, err := Load(nil)
+
+	// Assert
+	if assert.NoError(t, err) {
+		assert.Equal(t, ":2565", cfg.Addr)
+		assert.Equal(t, ":2566", cfg.GRPCAddr)
+		assert.Equal(t, "postgres://localhost/expenses", cfg.DB.URL)
+		assert.Equal(t, AuthBoth, cfg.AuthMode)
+		assert.Equal(t, 10*time.Second, cfg.Timeouts.Shutdown)
+		assert.Equal(t, 8, cfg.Webhooks.MaxAttempts)
+		assert.Equal(t, time.Minute, cfg.Notify.MaxReconnect)
+		assert.Equal(t, 5*time.Second, cfg.DB.StatementTimeout)
+		assert.Equal(t, time.Minute, cfg.DB.StartupWait)
+		assert.Equal(t, CacheMemory, cfg.Cache.Backend)
+	}
+}
+
+func TestLoadPrecedenceU(t *testing.T) {
+	// Arrange
+	yamlFile := writeFile(t, "config.yaml", `
+addr: ":8080"
+db:
+  url: postgres://file/expenses
+  max_open_conns: 10
+  max_idle_conns: 5
+timeouts:
+  read: 3s
+log_level: debug
+`)
+	t.Setenv("PORT", "9090")
+	t.Setenv("GRPC_PORT", "9091")
+	t.Setenv("DB_MAX_IDLE_CONNS", "2")
+
+	// Act
+	cfg, err := Load([]string{"-config", yamlFile, "-log-level", "warn"})
+
+	// Assert
+	if assert.NoError(t, err) {
+		assert.Equal(t, ":9090", cfg.Addr, "env overrides file")
+		assert.Equal(t, ":9091", cfg.GRPCAddr)
+		assert.Equal(t, "postgres://file/expenses", cfg.DB.URL, "file overrides defaults")
+		assert.Equal(t, 10, cfg.DB.MaxOpenConns)
+		assert.Equal(t, 2, cfg.DB.MaxIdleConns)
+		assert.Equal(t, 3*time.Second, cfg.Timeouts.Read)
+		assert.Equal(t, 10*time.Second, cfg.Timeouts.Write)
+		assert.Equal(t, "warn", cfg.LogLevel, "flag overrides file")
+	}
+}
+
+func TestLoadTOMLU(t *testing.T) {
+	// Arrange
+	tomlFile := writeFile(t, "config.toml", `
+addr = ":7070"
+auth_mode = "apikey"
+
+[db]
+url = "postgres://toml/expenses"
+
+[timeouts]
+idle = "2m"
+`)
+
+	// Act
+	cfg, err := Load([]string{"-config", tomlFile})
+
+	// Assert
+	if assert.NoError(t, err) {
+		assert.Equal(t, ":7070", cfg.Addr)
+		assert.Equal(t, AuthAPIKey, cfg.AuthMode)
+		assert.Equal(t, 2*time.Minute, cfg.Timeouts.Idle)
+	}
+}
+
+func TestLoadInvalidU(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		args    []string
+		wantErr string
+	}{
+		{
+			name:    "testMissingDatabase",
+			wantErr: "invalid config: database url is required (DATABASE_URL or -database-url)",
+		},
+		{
+			name:    "testBadValues",
+			env:     map[string]string{"DATABASE_URL": "postgres://x"},
+			args:    []string{"-auth-mode", "oauth", "-log-level", "loud", "-db-max-open-conns", "1", "-db-max-idle-conns", "5"},
+			wantErr: `invalid config: db max_idle_conns must not exceed max_open_conns; auth mode must be basic, apikey or both, got "oauth"; log level must be debug, info, warn or error, got "loud"`,
+		},
+		{
+			name:    "testSameGRPCAddr",
+			env:     map[string]string{"DATABASE_URL": "postgres://x"},
+			args:    []string{"-addr", ":2565", "-grpc-addr", ":2565"},
+			wantErr: "invalid config: grpc addr must differ from addr",
+		},
+		{
+			name:    "testBadStartupBackoff",
+			env:     map[string]string{"DATABASE_URL": "postgres://x", "DB_STARTUP_BACKOFF": "5s"},
+			args:    []string{"-db-startup-max-backoff", "1s", "-db-conn-max-lifetime", "-1m"},
+			wantErr: "invalid config: db conn_max_lifetime must not be negative; db startup_max_backoff must not be less than startup_backoff",
+		},
+		{
+			name:    "testRedisCacheWithoutURL",
+			env:     map[string]string{"DATABASE_URL": "postgres://x", "CACHE_BACKEND": "redis"},
+			wantErr: "invalid config: redis url is required for the redis cache (REDIS_URL or -redis-url)",
+		},
+		{
+			name:    "testNegativeStatementTimeout",
+			env:     map[string]string{"DATABASE_URL": "postgres://x"},
+			args:    []string{"-db-statement-timeout", "-1s"},
+			wantErr: "invalid config: db statement_timeout must not be negative",
+		},
+		{
+			name:    "testBootstrapUserWithoutPassword",
+			env:     map[string]string{"DATABASE_URL": "postgres://x"},
+			args:    []string{"-bootstrap-user", "admin"},
+			wantErr: "invalid config: bootstrap user and password must be set together",
+		},
+		{
+			name:    "testBadEnvDuration",
+			env:     map[string]string{"DATABASE_URL": "postgres://x", "READ_TIMEOUT": "soon"},
+			wantErr: `config: READ_TIMEOUT must be a duration such as 10s, got "soon"`,
+		},
+		{
+			name:    "testMissingFile",
+			args:    []string{"-config", "config.json"},
+			wantErr: `config: can't read config.json: open config.json: no such file or directory`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			t.Setenv("DATABASE_URL", "")
+			t.Setenv("DATABASE_STR", "")
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			// Act
+			_, err := Load(tt.args)
+
+			// Assert
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
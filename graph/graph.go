@@ -0,0 +1,174 @@
+// Package graph serves the expenses API over GraphQL at /graphql. The schema
+// lives in schema.graphqls; generated.go and models_gen.go are generated
+// from it with go generate.
+package graph
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/extension"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/PatcharaKL/assessment/logging"
+	"github.com/PatcharaKL/assessment/problem"
+	"github.com/PatcharaKL/assessment/rest/auth"
+	"github.com/PatcharaKL/assessment/rest/expenses"
+	"github.com/labstack/echo/v4"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+const (
+	Path = "/graphql"
+
+	defaultPageSize = 20
+	maxPageSize     = 100
+	// tagsWeight is the number of tags the complexity limit assumes the
+	// unpaginated tags field returns.
+	tagsWeight = 50
+
+	cursorPrefix = "expense:"
+)
+
+// Handler serves GraphQL over POST. Callers are authenticated by the usual
+// middleware; queries need expenses:read and mutations expenses:write.
+// Queries whose complexity exceeds maxComplexity are rejected before they
+// run.
+func Handler(svc *expenses.Service, maxComplexity int) echo.HandlerFunc {
+	srv := handler.New(NewExecutableSchema(Config{
+		Resolvers:  &Resolver{Service: svc},
+		Complexity: complexity(),
+	}))
+	srv.AddTransport(transport.POST{})
+	srv.Use(extension.Introspection{})
+	srv.Use(extension.FixedComplexityLimit(maxComplexity))
+	srv.SetErrorPresenter(presentError)
+
+	return func(c echo.Context) error {
+		req := c.Request()
+		ctx := withLoaders(req.Context(), newLoaders(svc))
+		srv.ServeHTTP(c.Response(), req.WithContext(ctx))
+		return nil
+	}
+}
+
+// complexity counts a list field as its page size times its elements, so
+// a deeply nested query costs what it would cost Postgres.
+func complexity() ComplexityRoot {
+	c := ComplexityRoot{}
+	c.Query.Expenses = func(childComplexity int, _ *ExpenseFilter, first *int, _ *string) int {
+		return 1 + childComplexity*complexityPageSize(first)
+	}
+	c.Query.Tags = func(childComplexity int) int {
+		return 1 + childComplexity*tagsWeight
+	}
+	c.Tag.Expenses = func(childComplexity int, first *int) int {
+		return 1 + childComplexity*complexityPageSize(first)
+	}
+	return c
+}
+
+func complexityPageSize(first *int) int {
+	n, err := pageSize(first)
+	if err != nil {
+		return maxPageSize
+	}
+	return n
+}
+
+// presentError reports errors from the resolvers in the same terms as the
+// REST problem responses: the detail as the message and the problem code,
+// plus any invalid fields, as extensions. Server errors keep their cause in
+// the request log.
+func presentError(ctx context.Context, err error) *gqlerror.Error {
+	e := graphql.DefaultErrorPresenter(ctx, err)
+	if e.Err == nil {
+		return e
+	}
+
+	p := problem.From(e.Err)
+	if p.Status >= http.StatusInternalServerError {
+		logging.SetError(ctx, e.Err)
+	}
+	e.Message = p.Detail
+	e.Extensions = map[string]any{"code": p.Code}
+	if len(p.Errors) > 0 {
+		e.Extensions["errors"] = p.Errors
+	}
+	return e
+}
+
+// principal returns the caller the auth middleware authenticated.
+func principal(ctx context.Context) auth.Principal {
+	p, _ := auth.PrincipalFromContext(ctx)
+	return p
+}
+
+func requireWrite(ctx context.Context) (auth.Principal, error) {
+	p := principal(ctx)
+	if !p.Can(auth.ScopeExpensesWrite) {
+		return p, problem.Forbidden("permission denied: " + auth.ScopeExpensesWrite)
+	}
+	return p, nil
+}
+
+func pageSize(first *int) (int, error) {
+	if first == nil {
+		return defaultPageSize, nil
+	}
+	if *first < 0 || *first > maxPageSize {
+		return 0, problem.BadRequest(fmt.Sprintf("first must be between 0 and %d", maxPageSize))
+	}
+	return *first, nil
+}
+
+func encodeCursor(id int) string {
+	return base64.StdEncoding.EncodeToString([]byte(cursorPrefix + strconv.Itoa(id)))
+}
+
+// decodeCursor returns the expense ID in an opaque cursor, or 0 for the
+// first page.
+func decodeCursor(cursor *string) (int, error) {
+	if cursor == nil {
+		return 0, nil
+	}
+	b, err := base64.StdEncoding.DecodeString(*cursor)
+	s, ok := strings.CutPrefix(string(b), cursorPrefix)
+	id, atoiErr := strconv.Atoi(s)
+	if err != nil || !ok || atoiErr != nil || id < 0 {
+		return 0, problem.BadRequest("invalid cursor: " + *cursor)
+	}
+	return id, nil
+}
+
+func toFilter(f *ExpenseFilter) expenses.Filter {
+	if f == nil {
+		return expenses.Filter{}
+	}
+	filter := expenses.Filter{Tags: f.Tags, MinAmount: f.MinAmount, MaxAmount: f.MaxAmount}
+	if f.Search != nil {
+		filter.Search = *f.Search
+	}
+	return filter
+}
+
+func fromInput(in ExpenseInput) expenses.Expenses {
+	e := expenses.Expenses{Title: in.Title, Amount: in.Amount, Tags: in.Tags}
+	if in.Note != nil {
+		e.Note = *in.Note
+	}
+	return e
+}
+
+func tags(names []string) []Tag {
+	tags := make([]Tag, 0, len(names))
+	for _, name := range names {
+		tags = append(tags, Tag{Name: name})
+	}
+	return tags
+}
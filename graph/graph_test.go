@@ -0,0 +1,208 @@
+//go:build unit
+// +build unit
+
+package graph
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/PatcharaKL/assessment/rest/auth"
+	"github.com/PatcharaKL/assessment/rest/expenses"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	member = auth.Principal{User: "Patchara", Role: auth.RoleMember, Scopes: auth.AllScopes}
+	viewer = auth.Principal{User: "Somchai", Role: auth.RoleViewer, Scopes: auth.AllScopes}
+)
+
+type response struct {
+	Data   map[string]any `json:"data"`
+	Errors []struct {
+		Message    string         `json:"message"`
+		Extensions map[string]any `json:"extensions"`
+	} `json:"errors"`
+}
+
+func setupHandler(t *testing.T, maxComplexity int) (echo.HandlerFunc, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return Handler(expenses.NewService(db), maxComplexity), mock
+}
+
+func execute(t *testing.T, h echo.HandlerFunc, p auth.Principal, query string, variables map[string]any) (int, response) {
+	body, _ := json.Marshal(map[string]any{"query": query, "variables": variables})
+	req := httptest.NewRequest(http.MethodPost, Path, bytes.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	auth.SetPrincipal(c, p)
+
+	if err := h(c); err != nil {
+		t.Fatal(err)
+	}
+	res := response{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatalf("can't decode %q: %v", rec.Body.String(), err)
+	}
+	return rec.Code, res
+}
+
+func expenseRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags"}).
+		AddRow(1, "strawberry smoothie", 79.00, "night market promotion discount 10 bath", pq.Array([]string{"food", "beverage"})).
+		AddRow(2, "apple smoothie", 89.00, "no discount", pq.Array([]string{"beverage"}))
+}
+
+func TestExpensesPaginationU(t *testing.T) {
+	// Arrange
+	h, mock := setupHandler(t, 1000)
+	mock.ExpectQuery("SELECT id, title, amount, note, tags FROM expenses").
+		WithArgs("Patchara", pq.Array([]string{"beverage"}), 50.0, nil, "smoothie", 0, 2).
+		WillReturnRows(expenseRows())
+	mock.ExpectQuery("SELECT id, title, amount, note, tags FROM expenses").
+		WithArgs("Patchara", nil, nil, nil, "", 1, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags"}).
+			AddRow(2, "apple smoothie", 89.00, "no discount", pq.Array([]string{"beverage"})))
+	query := `query($after: String) {
+		expenses(filter: {tags: ["beverage"], minAmount: 50, search: "smoothie"}, first: 1) {
+			edges { node { id title } }
+			pageInfo { hasNextPage endCursor }
+		}
+		next: expenses(first: 1, after: $after) { edges { node { id } } pageInfo { hasNextPage } }
+	}`
+
+	// Act
+	code, res := execute(t, h, member, query, map[string]any{"after": encodeCursor(1)})
+
+	// Assert
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, res.Errors)
+	page := res.Data["expenses"].(map[string]any)
+	assert.Equal(t, []any{map[string]any{"node": map[string]any{"id": "1", "title": "strawberry smoothie"}}}, page["edges"])
+	assert.Equal(t, map[string]any{"hasNextPage": true, "endCursor": encodeCursor(1)}, page["pageInfo"])
+	next := res.Data["next"].(map[string]any)
+	assert.Equal(t, map[string]any{"hasNextPage": false}, next["pageInfo"])
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTagsAreBatchedU(t *testing.T) {
+	// Arrange
+	h, mock := setupHandler(t, 1000)
+	mock.ExpectQuery("SELECT id, title, amount, note, tags FROM expenses").WillReturnRows(expenseRows())
+	mock.ExpectQuery("SELECT tag, count\\(\\*\\), sum\\(amount\\)").WithArgs("Patchara", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"tag", "count", "sum"}).AddRow("beverage", 2, 168.0).AddRow("food", 1, 79.0))
+
+	// Act
+	code, res := execute(t, h, member, `{ expenses { edges { node { tags { name count total } } } } }`, nil)
+
+	// Assert
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, res.Errors)
+	edges := res.Data["expenses"].(map[string]any)["edges"].([]any)
+	first := edges[0].(map[string]any)["node"].(map[string]any)["tags"]
+	assert.Equal(t, []any{
+		map[string]any{"name": "food", "count": 1.0, "total": 79.0},
+		map[string]any{"name": "beverage", "count": 2.0, "total": 168.0},
+	}, first)
+	assert.NoError(t, mock.ExpectationsWereMet(), "one query for every tag")
+}
+
+func TestComplexityLimitU(t *testing.T) {
+	// Arrange
+	h, mock := setupHandler(t, 100)
+
+	// Act
+	code, res := execute(t, h, member, `{ expenses(first: 100) { edges { node { id tags { expenses { id } } } } } }`, nil)
+
+	// Assert
+	assert.Equal(t, http.StatusOK, code)
+	assert.Nil(t, res.Data)
+	if assert.Len(t, res.Errors, 1) {
+		assert.Contains(t, res.Errors[0].Message, "exceeds the limit of 100")
+		assert.Equal(t, "COMPLEXITY_LIMIT_EXCEEDED", res.Errors[0].Extensions["code"])
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMutationErrorsU(t *testing.T) {
+	tests := []struct {
+		name      string
+		principal auth.Principal
+		input     map[string]any
+		code      string
+		message   string
+		fields    any
+	}{
+		{"testViewerCannotCreate", viewer, map[string]any{"title": "apple smoothie", "amount": 89}, "forbidden", "permission denied: expenses:write", nil},
+		{"testInvalidInput", member, map[string]any{"title": "", "amount": 0}, "validation_failed", "request has invalid fields", []any{
+			map[string]any{"field": "title", "message": "is required"},
+			map[string]any{"field": "amount", "message": "must be greater than 0"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			h, mock := setupHandler(t, 1000)
+
+			// Act
+			code, res := execute(t, h, tt.principal, `mutation($input: ExpenseInput!) { createExpense(input: $input) { id } }`, map[string]any{"input": tt.input})
+
+			// Assert
+			assert.Equal(t, http.StatusOK, code)
+			if assert.Len(t, res.Errors, 1) {
+				assert.Equal(t, tt.message, res.Errors[0].Message)
+				assert.Equal(t, tt.code, res.Errors[0].Extensions["code"])
+				assert.Equal(t, tt.fields, res.Errors[0].Extensions["errors"])
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestCreateExpenseU(t *testing.T) {
+	// Arrange
+	h, mock := setupHandler(t, 1000)
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO expenses").WithArgs("apple smoothie", 89.00, "", pq.Array([]string{"beverage"}), "Patchara").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("INSERT INTO outbox").WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+	mock.ExpectCommit()
+
+	// Act
+	code, res := execute(t, h, member, `mutation { createExpense(input: {title: "apple smoothie", amount: 89, tags: ["beverage"]}) { id title tags { name } } }`, nil)
+
+	// Assert
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, res.Errors)
+	assert.Equal(t, map[string]any{"id": "2", "title": "apple smoothie", "tags": []any{map[string]any{"name": "beverage"}}}, res.Data["createExpense"])
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestExpenseNotFoundU(t *testing.T) {
+	// Arrange
+	h, mock := setupHandler(t, 1000)
+	mock.ExpectQuery("SELECT id, title, amount, note, tags, version, updated_at FROM expenses WHERE id = \\$1").WithArgs(9, "Patchara").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags", "version", "updated_at"}))
+
+	// Act
+	code, res := execute(t, h, member, `{ expense(id: 9) { id } }`, nil)
+
+	// Assert
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, res.Errors)
+	assert.Nil(t, res.Data["expense"])
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,63 @@
+package graph
+
+import (
+	"context"
+
+	"github.com/PatcharaKL/assessment/rest/auth"
+	"github.com/PatcharaKL/assessment/rest/expenses"
+	"github.com/graph-gophers/dataloader/v7"
+)
+
+// loaders batch the per-tag lookups of one request. Without them a list of
+// expenses with their tags' totals would cost a query per tag.
+type loaders struct {
+	tagTotals   *dataloader.Loader[string, expenses.Totals]
+	tagExpenses *dataloader.Loader[string, []expenses.Expenses]
+}
+
+type loadersKey struct{}
+
+func newLoaders(svc *expenses.Service) *loaders {
+	return &loaders{
+		tagTotals:   dataloader.NewBatchedLoader(tagTotalsBatch(svc)),
+		tagExpenses: dataloader.NewBatchedLoader(tagExpensesBatch(svc)),
+	}
+}
+
+func withLoaders(ctx context.Context, l *loaders) context.Context {
+	return context.WithValue(ctx, loadersKey{}, l)
+}
+
+func loadersFrom(ctx context.Context) *loaders {
+	return ctx.Value(loadersKey{}).(*loaders)
+}
+
+func tagTotalsBatch(svc *expenses.Service) dataloader.BatchFunc[string, expenses.Totals] {
+	return func(ctx context.Context, tags []string) []*dataloader.Result[expenses.Totals] {
+		p, _ := auth.PrincipalFromContext(ctx)
+		totals, err := svc.TagTotals(ctx, p, tags)
+		byTag := map[string]expenses.Totals{}
+		for _, t := range totals {
+			byTag[t.Tag] = t
+		}
+
+		results := make([]*dataloader.Result[expenses.Totals], len(tags))
+		for i, tag := range tags {
+			results[i] = &dataloader.Result[expenses.Totals]{Data: byTag[tag], Error: err}
+		}
+		return results
+	}
+}
+
+func tagExpensesBatch(svc *expenses.Service) dataloader.BatchFunc[string, []expenses.Expenses] {
+	return func(ctx context.Context, tags []string) []*dataloader.Result[[]expenses.Expenses] {
+		p, _ := auth.PrincipalFromContext(ctx)
+		byTag, err := svc.ByTags(ctx, p, tags, maxPageSize)
+
+		results := make([]*dataloader.Result[[]expenses.Expenses], len(tags))
+		for i, tag := range tags {
+			results[i] = &dataloader.Result[[]expenses.Expenses]{Data: byTag[tag], Error: err}
+		}
+		return results
+	}
+}
@@ -0,0 +1,18 @@
+package graph
+
+import "github.com/PatcharaKL/assessment/rest/expenses"
+
+// Tag is resolved field by field through the request's loaders, so listing
+// many tags costs one query per field rather than one per tag.
+type Tag struct {
+	Name string
+}
+
+// ExpenseConnection keeps the filter it was listed with so that totalCount
+// is only counted when it is asked for.
+type ExpenseConnection struct {
+	Edges    []ExpenseEdge
+	PageInfo PageInfo
+
+	filter expenses.Filter
+}
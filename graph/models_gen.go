@@ -0,0 +1,45 @@
+// Code generated by github.com/99designs/gqlgen, DO NOT EDIT.
+
+package graph
+
+import (
+	"github.com/PatcharaKL/assessment/rest/expenses"
+)
+
+type ExpenseEdge struct {
+	Cursor string             `json:"cursor"`
+	Node   *expenses.Expenses `json:"node"`
+}
+
+type ExpenseFilter struct {
+	// Expenses must have all of these tags.
+	Tags      []string `json:"tags,omitempty"`
+	MinAmount *float64 `json:"minAmount,omitempty"`
+	MaxAmount *float64 `json:"maxAmount,omitempty"`
+	// Case-insensitive text the title must contain.
+	Search *string `json:"search,omitempty"`
+}
+
+type ExpenseInput struct {
+	Title  string   `json:"title"`
+	Amount float64  `json:"amount"`
+	Note   *string  `json:"note,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+}
+
+type Mutation struct {
+}
+
+type PageInfo struct {
+	HasNextPage bool    `json:"hasNextPage"`
+	EndCursor   *string `json:"endCursor,omitempty"`
+}
+
+type Query struct {
+}
+
+type Totals struct {
+	Count   int     `json:"count"`
+	Sum     float64 `json:"sum"`
+	Average float64 `json:"average"`
+}
@@ -0,0 +1,9 @@
+package graph
+
+import "github.com/PatcharaKL/assessment/rest/expenses"
+
+// Resolver answers every field from expenses.Service, the same business
+// logic behind the REST and gRPC APIs.
+type Resolver struct {
+	Service *expenses.Service
+}
@@ -0,0 +1,185 @@
+package graph
+
+// This file will be automatically regenerated based on the schema, any resolver implementations
+// will be copied through when generating and any unknown code will be moved to the end.
+// Code generated by github.com/99designs/gqlgen version v0.17.49
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/PatcharaKL/assessment/problem"
+
+	"github.com/PatcharaKL/assessment/rest/expenses"
+)
+
+// Tags is the resolver for the tags field.
+func (r *expenseResolver) Tags(ctx context.Context, obj *expenses.Expenses) ([]Tag, error) {
+	return tags(obj.Tags), nil
+}
+
+// TotalCount is the resolver for the totalCount field.
+func (r *expenseConnectionResolver) TotalCount(ctx context.Context, obj *ExpenseConnection) (int, error) {
+	t, err := r.Service.Totals(ctx, principal(ctx), obj.filter)
+	return t.Count, err
+}
+
+// CreateExpense is the resolver for the createExpense field.
+func (r *mutationResolver) CreateExpense(ctx context.Context, input ExpenseInput) (*expenses.Expenses, error) {
+	p, err := requireWrite(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := r.Service.Create(ctx, p, fromInput(input))
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// UpdateExpense is the resolver for the updateExpense field.
+func (r *mutationResolver) UpdateExpense(ctx context.Context, id int, input ExpenseInput) (*expenses.Expenses, error) {
+	p, err := requireWrite(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if id <= 0 {
+		return nil, problem.InvalidID("id", strconv.Itoa(id))
+	}
+
+	e, err := r.Service.Update(ctx, p, id, fromInput(input))
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// Expense is the resolver for the expense field.
+func (r *queryResolver) Expense(ctx context.Context, id int) (*expenses.Expenses, error) {
+	e, err := r.Service.Get(ctx, principal(ctx), id)
+	if errors.Is(err, problem.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// Expenses is the resolver for the expenses field.
+func (r *queryResolver) Expenses(ctx context.Context, filter *ExpenseFilter, first *int, after *string) (*ExpenseConnection, error) {
+	limit, err := pageSize(first)
+	if err != nil {
+		return nil, err
+	}
+	afterID, err := decodeCursor(after)
+	if err != nil {
+		return nil, err
+	}
+
+	f := toFilter(filter)
+	list, err := r.Service.Find(ctx, principal(ctx), f, expenses.Page{After: afterID, Limit: limit + 1})
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &ExpenseConnection{Edges: []ExpenseEdge{}, filter: f}
+	if len(list) > limit {
+		list = list[:limit]
+		conn.PageInfo.HasNextPage = true
+	}
+	for i := range list {
+		conn.Edges = append(conn.Edges, ExpenseEdge{Cursor: encodeCursor(list[i].ID), Node: &list[i]})
+	}
+	if n := len(conn.Edges); n > 0 {
+		conn.PageInfo.EndCursor = &conn.Edges[n-1].Cursor
+	}
+	return conn, nil
+}
+
+// Tags is the resolver for the tags field.
+func (r *queryResolver) Tags(ctx context.Context) ([]Tag, error) {
+	totals, err := r.Service.TagTotals(ctx, principal(ctx), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	l := loadersFrom(ctx)
+	names := make([]string, 0, len(totals))
+	for _, t := range totals {
+		l.tagTotals.Prime(ctx, t.Tag, t)
+		names = append(names, t.Tag)
+	}
+	return tags(names), nil
+}
+
+// Totals is the resolver for the totals field.
+func (r *queryResolver) Totals(ctx context.Context, filter *ExpenseFilter) (*Totals, error) {
+	t, err := r.Service.Totals(ctx, principal(ctx), toFilter(filter))
+	if err != nil {
+		return nil, err
+	}
+
+	totals := &Totals{Count: t.Count, Sum: t.Sum}
+	if t.Count > 0 {
+		totals.Average = t.Sum / float64(t.Count)
+	}
+	return totals, nil
+}
+
+// Count is the resolver for the count field.
+func (r *tagResolver) Count(ctx context.Context, obj *Tag) (int, error) {
+	t, err := loadersFrom(ctx).tagTotals.Load(ctx, obj.Name)()
+	return t.Count, err
+}
+
+// Total is the resolver for the total field.
+func (r *tagResolver) Total(ctx context.Context, obj *Tag) (float64, error) {
+	t, err := loadersFrom(ctx).tagTotals.Load(ctx, obj.Name)()
+	return t.Sum, err
+}
+
+// Expenses is the resolver for the expenses field.
+func (r *tagResolver) Expenses(ctx context.Context, obj *Tag, first *int) ([]expenses.Expenses, error) {
+	limit, err := pageSize(first)
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := loadersFrom(ctx).tagExpenses.Load(ctx, obj.Name)()
+	if err != nil {
+		return nil, err
+	}
+	if len(list) > limit {
+		list = list[:limit]
+	}
+	if list == nil {
+		list = []expenses.Expenses{}
+	}
+	return list, nil
+}
+
+// Expense returns ExpenseResolver implementation.
+func (r *Resolver) Expense() ExpenseResolver { return &expenseResolver{r} }
+
+// ExpenseConnection returns ExpenseConnectionResolver implementation.
+func (r *Resolver) ExpenseConnection() ExpenseConnectionResolver {
+	return &expenseConnectionResolver{r}
+}
+
+// Mutation returns MutationResolver implementation.
+func (r *Resolver) Mutation() MutationResolver { return &mutationResolver{r} }
+
+// Query returns QueryResolver implementation.
+func (r *Resolver) Query() QueryResolver { return &queryResolver{r} }
+
+// Tag returns TagResolver implementation.
+func (r *Resolver) Tag() TagResolver { return &tagResolver{r} }
+
+type expenseResolver struct{ *Resolver }
+type expenseConnectionResolver struct{ *Resolver }
+type mutationResolver struct{ *Resolver }
+type queryResolver struct{ *Resolver }
+type tagResolver struct{ *Resolver }
@@ -0,0 +1,130 @@
+// Package grpcapi serves the expenses API over gRPC. It is backed by the same
+// expenses.Service as the REST handlers and accepts the same credentials, so
+// both APIs apply identical validation, ownership and permission rules.
+package grpcapi
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+
+	"github.com/PatcharaKL/assessment/problem"
+	expensesv1 "github.com/PatcharaKL/assessment/proto/expenses/v1"
+	"github.com/PatcharaKL/assessment/rest/auth"
+	"github.com/PatcharaKL/assessment/rest/expenses"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// New returns a gRPC server with the expenses and health services
+// registered. Call Shutdown on the health server when draining so that
+// clients stop sending new calls before the server stops.
+func New(svc *expenses.Service, a *auth.Handler, authMode string, logger *slog.Logger) (*grpc.Server, *health.Server) {
+	i := &interceptor{auth: a, authMode: authMode, logger: logger}
+	s := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(i.logUnary, i.authUnary),
+		grpc.ChainStreamInterceptor(i.logStream, i.authStream),
+	)
+
+	expensesv1.RegisterExpensesServiceServer(s, &expensesServer{svc: svc})
+
+	hs := health.NewServer()
+	hs.SetServingStatus(expensesv1.ExpensesService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(s, hs)
+
+	return s, hs
+}
+
+type expensesServer struct {
+	expensesv1.UnimplementedExpensesServiceServer
+	svc *expenses.Service
+}
+
+func (s *expensesServer) CreateExpense(ctx context.Context, req *expensesv1.CreateExpenseRequest) (*expensesv1.Expense, error) {
+	e, err := s.svc.Create(ctx, principal(ctx), fromProto(req.GetExpense()))
+	if err != nil {
+		return nil, err
+	}
+	return toProto(e), nil
+}
+
+func (s *expensesServer) GetExpense(ctx context.Context, req *expensesv1.GetExpenseRequest) (*expensesv1.Expense, error) {
+	id, err := expenseID(req.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := s.svc.Get(ctx, principal(ctx), id)
+	if err != nil {
+		return nil, err
+	}
+	return toProto(e), nil
+}
+
+func (s *expensesServer) ListExpenses(ctx context.Context, req *expensesv1.ListExpensesRequest) (*expensesv1.ListExpensesResponse, error) {
+	list, err := s.svc.List(ctx, principal(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	res := &expensesv1.ListExpensesResponse{Expenses: make([]*expensesv1.Expense, 0, len(list))}
+	for _, e := range list {
+		res.Expenses = append(res.Expenses, toProto(e))
+	}
+	return res, nil
+}
+
+func (s *expensesServer) StreamExpenses(req *expensesv1.ListExpensesRequest, stream expensesv1.ExpensesService_StreamExpensesServer) error {
+	ctx := stream.Context()
+	return s.svc.Each(ctx, principal(ctx), func(e expenses.Expenses) error {
+		return stream.Send(toProto(e))
+	})
+}
+
+func (s *expensesServer) UpdateExpense(ctx context.Context, req *expensesv1.UpdateExpenseRequest) (*expensesv1.Expense, error) {
+	id, err := expenseID(req.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := s.svc.Update(ctx, principal(ctx), id, fromProto(req.GetExpense()))
+	if err != nil {
+		return nil, err
+	}
+	return toProto(e), nil
+}
+
+// principal returns the caller authenticated by the interceptor.
+func principal(ctx context.Context) auth.Principal {
+	p, _ := auth.PrincipalFromContext(ctx)
+	return p
+}
+
+func expenseID(id int64) (int, error) {
+	if id <= 0 {
+		return 0, problem.InvalidID("id", strconv.FormatInt(id, 10))
+	}
+	return int(id), nil
+}
+
+func toProto(e expenses.Expenses) *expensesv1.Expense {
+	return &expensesv1.Expense{
+		Id:     int64(e.ID),
+		Title:  e.Title,
+		Amount: e.Amount,
+		Note:   e.Note,
+		Tags:   e.Tags,
+	}
+}
+
+// fromProto ignores the id: the server assigns it on create and takes it
+// from the request on update.
+func fromProto(e *expensesv1.Expense) expenses.Expenses {
+	return expenses.Expenses{
+		Title:  e.GetTitle(),
+		Amount: e.GetAmount(),
+		Note:   e.GetNote(),
+		Tags:   e.GetTags(),
+	}
+}
@@ -0,0 +1,221 @@
+package grpcapi
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/PatcharaKL/assessment/config"
+	"github.com/PatcharaKL/assessment/logging"
+	"github.com/PatcharaKL/assessment/problem"
+	expensesv1 "github.com/PatcharaKL/assessment/proto/expenses/v1"
+	"github.com/PatcharaKL/assessment/rest/auth"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	headerAuthorization = "authorization"
+	headerRequestID     = "x-request-id"
+)
+
+// permissions is the action each method requires, as in the REST routes.
+var permissions = map[string]string{
+	expensesv1.ExpensesService_CreateExpense_FullMethodName:  auth.ScopeExpensesWrite,
+	expensesv1.ExpensesService_GetExpense_FullMethodName:     auth.ScopeExpensesRead,
+	expensesv1.ExpensesService_ListExpenses_FullMethodName:   auth.ScopeExpensesRead,
+	expensesv1.ExpensesService_StreamExpenses_FullMethodName: auth.ScopeExpensesRead,
+	expensesv1.ExpensesService_UpdateExpense_FullMethodName:  auth.ScopeExpensesWrite,
+}
+
+type interceptor struct {
+	auth     *auth.Handler
+	authMode string
+	logger   *slog.Logger
+}
+
+// public reports whether method is served without authentication, as the
+// HTTP probes are.
+func public(method string) bool {
+	return strings.HasPrefix(method, "/"+healthpb.Health_ServiceDesc.ServiceName+"/")
+}
+
+// authenticate checks the authorization metadata, which carries the same
+// "Basic ..." or "Bearer ..." value as the HTTP header, and the permission
+// the method requires.
+func (i *interceptor) authenticate(ctx context.Context, method string) (context.Context, error) {
+	if public(method) {
+		return ctx, nil
+	}
+
+	values := metadata.ValueFromIncomingContext(ctx, headerAuthorization)
+	if len(values) == 0 {
+		return ctx, status.Error(codes.Unauthenticated, "missing credentials")
+	}
+	scheme, credentials, _ := strings.Cut(values[0], " ")
+
+	var p auth.Principal
+	var ok bool
+	var err error
+	switch {
+	case strings.EqualFold(scheme, "Basic") && i.authMode != config.AuthAPIKey:
+		b, decodeErr := base64.StdEncoding.DecodeString(credentials)
+		username, password, found := strings.Cut(string(b), ":")
+		if decodeErr != nil || !found {
+			return ctx, status.Error(codes.Unauthenticated, "malformed basic credentials")
+		}
+		p, ok, err = i.auth.AuthenticateBasic(ctx, username, password)
+	case strings.EqualFold(scheme, "Bearer") && i.authMode != config.AuthBasic:
+		p, ok, err = i.auth.AuthenticateAPIKey(ctx, credentials)
+	default:
+		return ctx, status.Error(codes.Unauthenticated, "unsupported authorization scheme")
+	}
+	if err != nil {
+		return ctx, err
+	}
+	if !ok {
+		return ctx, status.Error(codes.Unauthenticated, "invalid credentials")
+	}
+
+	logging.SetUser(ctx, p.User)
+	if action, ok := permissions[method]; ok && !p.Can(action) {
+		return ctx, problem.Forbidden("permission denied: " + action)
+	}
+	return auth.WithPrincipal(ctx, p), nil
+}
+
+func (i *interceptor) authUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := i.authenticate(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (i *interceptor) authStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := i.authenticate(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// begin takes the request ID from the x-request-id metadata or generates
+// one, and echoes it in the response headers.
+func (i *interceptor) begin(ctx context.Context, method string) context.Context {
+	id := ""
+	if v := metadata.ValueFromIncomingContext(ctx, headerRequestID); len(v) > 0 {
+		id = v[0]
+	}
+	if id == "" {
+		id = logging.NewRequestID()
+	}
+	_ = grpc.SetHeader(ctx, metadata.Pairs(headerRequestID, id))
+	return logging.WithRequest(ctx, &logging.Request{ID: id, Route: method})
+}
+
+// end turns err into the status the client receives and logs the call the
+// way the HTTP middleware logs requests.
+func (i *interceptor) end(ctx context.Context, start time.Time, err error) error {
+	st := Status(err)
+	level := slog.LevelInfo
+	switch st.Code() {
+	case codes.OK:
+	case codes.Internal, codes.Unknown, codes.Unavailable, codes.DataLoss:
+		level = slog.LevelError
+	default:
+		level = slog.LevelWarn
+	}
+
+	attrs := append(logging.Attrs(ctx),
+		slog.String("code", st.Code().String()),
+		slog.Duration("latency", time.Since(start)),
+	)
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+	i.logger.Log(ctx, level, "grpc request", attrs...)
+	return st.Err()
+}
+
+func (i *interceptor) logUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	ctx = i.begin(ctx, info.FullMethod)
+	res, err := handler(ctx, req)
+	return res, i.end(ctx, start, err)
+}
+
+func (i *interceptor) logStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	ctx := i.begin(ss.Context(), info.FullMethod)
+	err := handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	return i.end(ctx, start, err)
+}
+
+// statusCodes maps problem codes to their gRPC equivalents.
+var statusCodes = map[problem.Code]codes.Code{
+	problem.CodeInvalidRequest:     codes.InvalidArgument,
+	problem.CodeInvalidID:          codes.InvalidArgument,
+	problem.CodeValidationFailed:   codes.InvalidArgument,
+	problem.CodeUnauthorized:       codes.Unauthenticated,
+	problem.CodeForbidden:          codes.PermissionDenied,
+	problem.CodeNotFound:           codes.NotFound,
+	problem.CodeConflict:           codes.AlreadyExists,
+	problem.CodePreconditionFailed: codes.FailedPrecondition,
+	problem.CodeUnavailable:        codes.Unavailable,
+	problem.CodeTimeout:            codes.DeadlineExceeded,
+}
+
+// Status maps err to the status the client receives, using the same rules as
+// problem.From: domain errors keep their detail, invalid fields are listed in
+// a BadRequest detail and any other error is an internal error whose cause
+// stays in the logs. Errors that already are statuses pass through.
+func Status(err error) *status.Status {
+	if err == nil {
+		return status.New(codes.OK, "")
+	}
+	if st, ok := status.FromError(err); ok {
+		return st
+	}
+	if errors.Is(err, context.Canceled) {
+		return status.New(codes.Canceled, err.Error())
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return status.New(codes.DeadlineExceeded, err.Error())
+	}
+
+	p := problem.From(err)
+	code, ok := statusCodes[p.Code]
+	if !ok {
+		code = codes.Internal
+	}
+	st := status.New(code, p.Detail)
+	if len(p.Errors) == 0 {
+		return st
+	}
+
+	br := &errdetails.BadRequest{}
+	for _, f := range p.Errors {
+		br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{Field: f.Field, Description: f.Message})
+	}
+	if withDetails, err := st.WithDetails(br); err == nil {
+		return withDetails
+	}
+	return st
+}
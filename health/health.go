@@ -0,0 +1,114 @@
+// Package health serves the liveness and readiness probes. Both are
+// unauthenticated and answer with a per-check JSON breakdown.
+package health
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/PatcharaKL/assessment/migrations"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	LivezPath  = "/livez"
+	ReadyzPath = "/readyz"
+
+	StatusOK          = "ok"
+	StatusUnavailable = "unavailable"
+)
+
+type Check struct {
+	Status  string `json:"status"`
+	Latency string `json:"latency,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+type Report struct {
+	Status string           `json:"status"`
+	Checks map[string]Check `json:"checks"`
+}
+
+type Checker struct {
+	DB      *sql.DB
+	Timeout time.Duration
+
+	draining atomic.Bool
+}
+
+func New(db *sql.DB, timeout time.Duration) *Checker {
+	return &Checker{DB: db, Timeout: timeout}
+}
+
+// Drain marks the service as shutting down so that /readyz fails and the
+// load balancer stops sending new requests.
+func (h *Checker) Drain() {
+	h.draining.Store(true)
+}
+
+// IsProbe reports whether the request is for a probe endpoint. It is used as
+// the skipper of the authentication middleware.
+func IsProbe(c echo.Context) bool {
+	p := c.Request().URL.Path
+	return p == LivezPath || p == ReadyzPath
+}
+
+func (h *Checker) LivezHandler(c echo.Context) error {
+	return c.JSON(http.StatusOK, Report{
+		Status: StatusOK,
+		Checks: map[string]Check{"process": {Status: StatusOK}},
+	})
+}
+
+func (h *Checker) ReadyzHandler(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(c.Request().Context(), h.Timeout)
+	defer cancel()
+
+	r := Report{Status: StatusOK, Checks: map[string]Check{
+		"database":   timed(func() error { return h.DB.PingContext(ctx) }),
+		"migrations": timed(func() error { return h.migrationsCurrent(ctx) }),
+		"shutdown":   h.shutdownCheck(),
+	}}
+
+	code := http.StatusOK
+	for _, check := range r.Checks {
+		if check.Status != StatusOK {
+			r.Status = StatusUnavailable
+			code = http.StatusServiceUnavailable
+		}
+	}
+	return c.JSON(code, r)
+}
+
+func (h *Checker) migrationsCurrent(ctx context.Context) error {
+	pending, err := migrations.Pending(ctx, h.DB)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%d pending migrations, next is %s", len(pending), pending[0].Name)
+	}
+	return nil
+}
+
+func (h *Checker) shutdownCheck() Check {
+	if h.draining.Load() {
+		return Check{Status: StatusUnavailable, Error: "server is shutting down"}
+	}
+	return Check{Status: StatusOK}
+}
+
+func timed(fn func() error) Check {
+	start := time.Now()
+	err := fn()
+	check := Check{Status: StatusOK, Latency: time.Since(start).String()}
+	if err != nil {
+		check.Status = StatusUnavailable
+		check.Error = err.Error()
+	}
+	return check
+}
@@ -0,0 +1,121 @@
+//go:build unit
+// +build unit
+
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/PatcharaKL/assessment/migrations"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func setup(t *testing.T) (*Checker, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return New(db, time.Second), mock
+}
+
+func appliedRows(t *testing.T, skip int) *sqlmock.Rows {
+	all, err := migrations.All()
+	assert.NoError(t, err)
+	rows := sqlmock.NewRows([]string{"version"})
+	for _, m := range all[:len(all)-skip] {
+		rows.AddRow(m.Version)
+	}
+	return rows
+}
+
+func readyz(h *Checker) (*httptest.ResponseRecorder, Report) {
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	c := e.NewContext(httptest.NewRequest(http.MethodGet, ReadyzPath, nil), rec)
+	h.ReadyzHandler(c)
+	var r Report
+	json.Unmarshal(rec.Body.Bytes(), &r)
+	return rec, r
+}
+
+func TestLivez(t *testing.T) {
+	h, _ := setup(t)
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	c := e.NewContext(httptest.NewRequest(http.MethodGet, LivezPath, nil), rec)
+
+	err := h.LivezHandler(c)
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"status":"ok","checks":{"process":{"status":"ok"}}}`, rec.Body.String())
+}
+
+func TestReadyz(t *testing.T) {
+	t.Run("ready", func(t *testing.T) {
+		h, mock := setup(t)
+		mock.ExpectPing()
+		mock.ExpectQuery("SELECT version FROM schema_migrations").WillReturnRows(appliedRows(t, 0))
+
+		rec, r := readyz(h)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, StatusOK, r.Status)
+		for name, check := range r.Checks {
+			assert.Equal(t, StatusOK, check.Status, name)
+		}
+	})
+
+	t.Run("database down", func(t *testing.T) {
+		h, mock := setup(t)
+		mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+		mock.ExpectQuery("SELECT version FROM schema_migrations").WillReturnError(errors.New("connection refused"))
+
+		rec, r := readyz(h)
+
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.Equal(t, StatusUnavailable, r.Status)
+		assert.Equal(t, "connection refused", r.Checks["database"].Error)
+		assert.Equal(t, StatusOK, r.Checks["shutdown"].Status)
+	})
+
+	t.Run("pending migrations", func(t *testing.T) {
+		h, mock := setup(t)
+		mock.ExpectPing()
+		mock.ExpectQuery("SELECT version FROM schema_migrations").WillReturnRows(appliedRows(t, 1))
+
+		rec, r := readyz(h)
+
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.Equal(t, StatusOK, r.Checks["database"].Status)
+		assert.Contains(t, r.Checks["migrations"].Error, "1 pending migrations")
+	})
+
+	t.Run("draining", func(t *testing.T) {
+		h, mock := setup(t)
+		mock.ExpectPing()
+		mock.ExpectQuery("SELECT version FROM schema_migrations").WillReturnRows(appliedRows(t, 0))
+		h.Drain()
+
+		rec, r := readyz(h)
+
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.Equal(t, StatusUnavailable, r.Checks["shutdown"].Status)
+	})
+}
+
+func TestIsProbe(t *testing.T) {
+	e := echo.New()
+	for path, want := range map[string]bool{LivezPath: true, ReadyzPath: true, "/expenses": false, "/health": false} {
+		c := e.NewContext(httptest.NewRequest(http.MethodGet, path, nil), httptest.NewRecorder())
+		assert.Equal(t, want, IsProbe(c), path)
+	}
+}
@@ -0,0 +1,105 @@
+// Package logging provides the service's structured JSON logger and the
+// per-request fields (request ID, user, route) attached to every log line.
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync"
+)
+
+var levels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+// New returns a JSON logger writing to w at the named level.
+func New(w io.Writer, level string) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: levels[level]}))
+}
+
+// Request holds the fields that identify a request in the logs. The user and
+// trace ID are filled in by later middleware, so they are guarded by a mutex.
+type Request struct {
+	ID    string
+	Route string
+
+	mu      sync.Mutex
+	user    string
+	traceID string
+	err     error
+}
+
+type requestKey struct{}
+
+func WithRequest(ctx context.Context, r *Request) context.Context {
+	return context.WithValue(ctx, requestKey{}, r)
+}
+
+func RequestFrom(ctx context.Context) (*Request, bool) {
+	r, ok := ctx.Value(requestKey{}).(*Request)
+	return r, ok
+}
+
+// SetUser records the authenticated user for the request in ctx, if any.
+func SetUser(ctx context.Context, user string) {
+	if r, ok := RequestFrom(ctx); ok {
+		r.mu.Lock()
+		r.user = user
+		r.mu.Unlock()
+	}
+}
+
+func (r *Request) User() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.user
+}
+
+// SetError records why the request failed. The client only sees a generic
+// message for server errors, so this is what ends up in the request log.
+func SetError(ctx context.Context, err error) {
+	if r, ok := RequestFrom(ctx); ok {
+		r.mu.Lock()
+		r.err = err
+		r.mu.Unlock()
+	}
+}
+
+func (r *Request) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+func (r *Request) SetTraceID(id string) {
+	r.mu.Lock()
+	r.traceID = id
+	r.mu.Unlock()
+}
+
+func (r *Request) TraceID() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.traceID
+}
+
+// Attrs returns the request fields for ctx as slog attributes.
+func Attrs(ctx context.Context) []any {
+	r, ok := RequestFrom(ctx)
+	if !ok {
+		return nil
+	}
+	attrs := []any{
+		slog.String("request_id", r.ID),
+		slog.String("user", r.User()),
+		slog.String("route", r.Route),
+	}
+	if id := r.TraceID(); id != "" {
+		attrs = append(attrs, slog.String("trace_id", id))
+	}
+	return attrs
+}
@@ -0,0 +1,113 @@
+//go:build unit
+// +build unit
+
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func decode(t *testing.T, buf *bytes.Buffer) map[string]any {
+	line := map[string]any{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("log line is not JSON: %s", buf.String())
+	}
+	return line
+}
+
+func TestMiddlewareU(t *testing.T) {
+	tests := []struct {
+		name          string
+		requestID     string
+		handler       echo.HandlerFunc
+		expectedLevel string
+		expectedError string
+	}{
+		{
+			name:          "testSucceed",
+			requestID:     "req-1",
+			handler:       func(c echo.Context) error { return c.JSON(http.StatusOK, "OK") },
+			expectedLevel: "INFO",
+		},
+		{
+			name: "testHandlerError",
+			handler: func(c echo.Context) error {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"message": "can't query expenses: boom"})
+			},
+			expectedLevel: "ERROR",
+			expectedError: "can't query expenses: boom",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			buf := &bytes.Buffer{}
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodGet, "/expenses", nil)
+			if tt.requestID != "" {
+				req.Header.Set(echo.HeaderXRequestID, tt.requestID)
+			}
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetPath("/expenses")
+			handler := func(c echo.Context) error {
+				SetUser(c.Request().Context(), "Patchara")
+				return tt.handler(c)
+			}
+
+			// Act
+			err := Middleware(New(buf, "info"))(handler)(c)
+
+			// Assert
+			assert.NoError(t, err)
+			line := decode(t, buf)
+			id := rec.Header().Get(echo.HeaderXRequestID)
+			assert.NotEmpty(t, id)
+			if tt.requestID != "" {
+				assert.Equal(t, tt.requestID, id)
+			}
+			assert.Equal(t, id, line["request_id"])
+			assert.Equal(t, "Patchara", line["user"])
+			assert.Equal(t, "/expenses", line["route"])
+			assert.Equal(t, tt.expectedLevel, line["level"])
+			if tt.expectedError != "" {
+				assert.Equal(t, tt.expectedError, line["error"])
+			}
+		})
+	}
+}
+
+func TestSlowQueryHookU(t *testing.T) {
+	// Arrange
+	buf := &bytes.Buffer{}
+	h := SlowQueryHook{Logger: New(buf, "info"), Threshold: 100 * time.Millisecond}
+	ctx := WithRequest(context.Background(), &Request{ID: "req-1", Route: "/expenses"})
+	SetUser(ctx, "Patchara")
+
+	// Act
+	h.After(ctx, "SELECT 1", time.Millisecond, nil)
+	fast := buf.Len()
+	h.After(ctx, "SELECT pg_sleep(1)", time.Second, nil)
+	slow := decode(t, buf)
+	buf.Reset()
+	h.After(ctx, "SELECT broken", time.Millisecond, errors.New("syntax error"))
+	failed := decode(t, buf)
+
+	// Assert
+	assert.Equal(t, 0, fast)
+	assert.Equal(t, "slow query", slow["msg"])
+	assert.Equal(t, "req-1", slow["request_id"])
+	assert.Equal(t, "Patchara", slow["user"])
+	assert.Equal(t, "query failed", failed["msg"])
+	assert.Equal(t, "syntax error", failed["error"])
+}
@@ -0,0 +1,159 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// maxErrorBody bounds how much of an error response is kept for the log.
+const maxErrorBody = 4096
+
+type errorCapture struct {
+	http.ResponseWriter
+	status *int
+	body   bytes.Buffer
+}
+
+func (w *errorCapture) Write(b []byte) (int, error) {
+	if *w.status >= http.StatusBadRequest && w.body.Len() < maxErrorBody {
+		w.body.Write(b[:min(len(b), maxErrorBody-w.body.Len())])
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *errorCapture) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the connection's writer.
+func (w *errorCapture) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// NewRequestID returns a random ID for a request that arrived without one.
+func NewRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// Middleware takes the request ID from X-Request-ID or generates one, echoes
+// it in the response and stores it in the request context for the handlers
+// and database hooks. Each request is logged once it completes; responses
+// with a 4xx or 5xx status include the error message the client received.
+func Middleware(l *slog.Logger) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			req := c.Request()
+
+			id := req.Header.Get(echo.HeaderXRequestID)
+			if id == "" {
+				id = NewRequestID()
+			}
+			c.Response().Header().Set(echo.HeaderXRequestID, id)
+
+			r := &Request{ID: id, Route: c.Path()}
+			ctx := WithRequest(req.Context(), r)
+			c.SetRequest(req.WithContext(ctx))
+
+			capture := &errorCapture{ResponseWriter: c.Response().Writer, status: &c.Response().Status}
+			c.Response().Writer = capture
+
+			err := next(c)
+			if err != nil {
+				c.Error(err)
+			}
+
+			status := c.Response().Status
+			attrs := append(Attrs(ctx),
+				slog.String("method", req.Method),
+				slog.String("uri", req.RequestURI),
+				slog.Int("status", status),
+				slog.Duration("latency", time.Since(start)),
+				slog.Int64("bytes_out", c.Response().Size),
+			)
+
+			level := slog.LevelInfo
+			switch {
+			case status >= http.StatusInternalServerError:
+				level = slog.LevelError
+			case status >= http.StatusBadRequest:
+				level = slog.LevelWarn
+			}
+			if level > slog.LevelInfo {
+				if cause := r.Err(); cause != nil {
+					err = cause
+				}
+				attrs = append(attrs, slog.String("error", errorMessage(err, capture.body.Bytes())))
+			}
+
+			l.Log(ctx, level, "request", attrs...)
+			return nil
+		}
+	}
+}
+
+func errorMessage(err error, body []byte) string {
+	if err != nil {
+		return err.Error()
+	}
+	var e struct {
+		Detail  string `json:"detail"`
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(body, &e) == nil && e.Detail != "" {
+		return e.Detail
+	}
+	if e.Message != "" {
+		return e.Message
+	}
+	return string(body)
+}
+
+// RecoverLogger is a LogErrorFunc for echo's Recover middleware that logs
+// the panic and its stack with the request fields.
+func RecoverLogger(l *slog.Logger) func(c echo.Context, err error, stack []byte) error {
+	return func(c echo.Context, err error, stack []byte) error {
+		ctx := c.Request().Context()
+		l.ErrorContext(ctx, "panic recovered", append(Attrs(ctx),
+			slog.String("error", err.Error()),
+			slog.String("stack", string(stack)),
+		)...)
+		return err
+	}
+}
+
+// SlowQueryHook logs statements that take longer than Threshold and every
+// statement that fails.
+type SlowQueryHook struct {
+	Logger    *slog.Logger
+	Threshold time.Duration
+}
+
+func (h SlowQueryHook) Before(ctx context.Context, query string) context.Context {
+	return ctx
+}
+
+func (h SlowQueryHook) After(ctx context.Context, query string, elapsed time.Duration, err error) {
+	attrs := append(Attrs(ctx), slog.String("query", query), slog.Duration("latency", elapsed))
+	switch {
+	case err != nil:
+		h.Logger.ErrorContext(ctx, "query failed", append(attrs, slog.String("error", err.Error()))...)
+	case elapsed >= h.Threshold:
+		h.Logger.WarnContext(ctx, "slow query", attrs...)
+	}
+}
@@ -0,0 +1,181 @@
+// Package metrics exposes the service's Prometheus metrics: HTTP request
+// latency, database pool and query statistics, cache hits and business
+// counters.
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// DefaultCurrency labels amounts until expenses carry their own currency.
+const DefaultCurrency = "THB"
+
+var (
+	Registry = prometheus.NewRegistry()
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency by route, method and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	queryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "db_query_duration_seconds",
+		Help:    "Database statement latency by statement verb and table.",
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"statement", "outcome"})
+
+	cacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_lookups_total",
+		Help: "Cache lookups by cache and outcome, hit or miss.",
+	}, []string{"cache", "outcome"})
+
+	expensesCreated = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "expenses_created_total",
+		Help: "Number of expenses recorded.",
+	})
+
+	amountRecorded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "expenses_amount_total",
+		Help: "Sum of recorded expense amounts by currency.",
+	}, []string{"currency"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpDuration,
+		queryDuration,
+		cacheLookups,
+		expensesCreated,
+		amountRecorded,
+	)
+}
+
+// RegisterDB publishes the connection pool statistics of db: connections in
+// use and idle, and how often and how long callers waited for one.
+func RegisterDB(db *sql.DB, name string) {
+	Registry.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+func Handler() echo.HandlerFunc {
+	return echo.WrapHandler(promhttp.HandlerFor(Registry, promhttp.HandlerOpts{}))
+}
+
+// Middleware records the latency of every request under its route pattern,
+// so /expenses/1 and /expenses/2 share one series.
+func Middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		start := time.Now()
+		err := next(c)
+		if err != nil {
+			c.Error(err)
+		}
+
+		route := c.Path()
+		if route == "" {
+			route = "unmatched"
+		}
+		httpDuration.WithLabelValues(c.Request().Method, route, strconv.Itoa(c.Response().Status)).
+			Observe(time.Since(start).Seconds())
+		return nil
+	}
+}
+
+// ExpenseCreated counts a newly recorded expense and its amount. Counters
+// can only grow, so negative amounts are counted but not summed.
+func ExpenseCreated(amount float64, currency string) {
+	expensesCreated.Inc()
+	if amount > 0 {
+		amountRecorded.WithLabelValues(currency).Add(amount)
+	}
+}
+
+// CacheLookup counts a lookup in the named cache.
+func CacheLookup(cache string, hit bool) {
+	outcome := "miss"
+	if hit {
+		outcome = "hit"
+	}
+	cacheLookups.WithLabelValues(cache, outcome).Inc()
+}
+
+// QueryHook records the latency of every statement, labelled by its verb and
+// the table it works on, such as "select expenses". Schema changes, which
+// only migrations make, are not recorded.
+type QueryHook struct{}
+
+func (QueryHook) Before(ctx context.Context, query string) context.Context {
+	return ctx
+}
+
+func (QueryHook) After(ctx context.Context, query string, elapsed time.Duration, err error) {
+	statement := statementLabel(query)
+	if statement == "" {
+		return
+	}
+	outcome := "ok"
+	if err != nil {
+		outcome = "error"
+	}
+	queryDuration.WithLabelValues(statement, outcome).Observe(elapsed.Seconds())
+}
+
+// statementLabel names a statement by its verb and first table, so the label
+// set stays as small as the schema whatever the SQL looks like. It returns ""
+// for schema changes.
+func statementLabel(query string) string {
+	words := strings.Fields(strings.ToLower(query))
+	if len(words) == 0 {
+		return "other"
+	}
+	verb := words[0]
+	var after string
+	switch verb {
+	case "create", "alter", "drop", "truncate", "comment", "grant", "revoke":
+		return ""
+	case "select", "delete":
+		after = "from"
+	case "insert":
+		after = "into"
+	case "update":
+		return verb + table(words[1:])
+	case "with", "listen", "unlisten", "notify":
+		return verb
+	default:
+		return "other"
+	}
+	for i, w := range words[:len(words)-1] {
+		if w == after {
+			if t := table(words[i+1:]); t != "" {
+				return verb + t
+			}
+		}
+	}
+	return verb
+}
+
+// table returns the table name that starts words, with a leading space, or ""
+// when they start with a subquery.
+func table(words []string) string {
+	if len(words) == 0 {
+		return ""
+	}
+	name := strings.TrimFunc(words[0], func(r rune) bool {
+		return !(r == '_' || r == '.' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9')
+	})
+	if name == "" || strings.HasPrefix(words[0], "(") {
+		return ""
+	}
+	return " " + name
+}
@@ -0,0 +1,142 @@
+//go:build unit
+// +build unit
+
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddlewareU(t *testing.T) {
+	// Arrange
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/expenses/1", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/expenses/:id")
+	next := func(c echo.Context) error { return c.JSON(http.StatusOK, "OK") }
+
+	// Act
+	err := Middleware(next)(c)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, 1, testutil.CollectAndCount(httpDuration, "http_request_duration_seconds"))
+	assert.Equal(t, uint64(1), histogramCount(t, "http_request_duration_seconds", `route="/expenses/:id"`))
+}
+
+func TestExpenseCreatedU(t *testing.T) {
+	// Arrange
+	before := testutil.ToFloat64(expensesCreated)
+
+	// Act
+	ExpenseCreated(79, DefaultCurrency)
+	ExpenseCreated(-5, DefaultCurrency)
+
+	// Assert
+	assert.Equal(t, before+2, testutil.ToFloat64(expensesCreated))
+	assert.Equal(t, 79.0, testutil.ToFloat64(amountRecorded.WithLabelValues(DefaultCurrency)))
+}
+
+func TestCacheLookupU(t *testing.T) {
+	// Arrange
+	hits := testutil.ToFloat64(cacheLookups.WithLabelValues("expenses", "hit"))
+	misses := testutil.ToFloat64(cacheLookups.WithLabelValues("expenses", "miss"))
+
+	// Act
+	CacheLookup("expenses", true)
+	CacheLookup("expenses", false)
+	CacheLookup("expenses", true)
+
+	// Assert
+	assert.Equal(t, hits+2, testutil.ToFloat64(cacheLookups.WithLabelValues("expenses", "hit")))
+	assert.Equal(t, misses+1, testutil.ToFloat64(cacheLookups.WithLabelValues("expenses", "miss")))
+}
+
+func TestQueryHookU(t *testing.T) {
+	// Arrange
+	h := QueryHook{}
+
+	// Act
+	h.After(context.Background(), "SELECT id\n\t\tFROM expenses WHERE id = $1", 2*time.Millisecond, nil)
+	h.After(context.Background(), "SELECT id FROM expenses", 2*time.Millisecond, errors.New("boom"))
+	h.After(context.Background(), "CREATE TABLE IF NOT EXISTS audit (id SERIAL PRIMARY KEY)", 2*time.Millisecond, nil)
+
+	// Assert
+	assert.Equal(t, uint64(1), histogramCount(t, "db_query_duration_seconds", `outcome="ok",statement="select expenses"`))
+	assert.Equal(t, uint64(1), histogramCount(t, "db_query_duration_seconds", `outcome="error",statement="select expenses"`))
+	assert.Zero(t, histogramCount(t, "db_query_duration_seconds", `statement="create`), "schema changes aren't recorded")
+}
+
+func TestStatementLabelU(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"SELECT id, title FROM expenses WHERE id = $1", "select expenses"},
+		{"SELECT tag, id FROM (SELECT tag, id FROM expenses, unnest(tags) AS tag) t WHERE n <= $3", "select expenses"},
+		{`INSERT INTO "outbox" (type) values ($1)`, "insert outbox"},
+		{"UPDATE users SET role = $2 WHERE id = $1", "update users"},
+		{"DELETE FROM idempotency_keys WHERE expires_at < now()", "delete idempotency_keys"},
+		{"SELECT pg_advisory_xact_lock(4827301)", "select"},
+		{"SELECT 1", "select"},
+		{"ALTER TABLE expenses ADD COLUMN version BIGINT", ""},
+		{"VACUUM", "other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			assert.Equal(t, tt.want, statementLabel(tt.query))
+		})
+	}
+}
+
+func TestHandlerU(t *testing.T) {
+	// Arrange
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	// Act
+	err := Handler()(c)
+
+	// Assert
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), "# TYPE expenses_created_total counter")
+	}
+}
+
+// histogramCount returns the sample count of the series of name whose labels
+// match the rendered label string.
+func histogramCount(t *testing.T, name, labels string) uint64 {
+	families, err := Registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			pairs := []string{}
+			for _, l := range m.GetLabel() {
+				pairs = append(pairs, l.GetName()+`="`+l.GetValue()+`"`)
+			}
+			if strings.Contains(strings.Join(pairs, ","), labels) {
+				return m.GetHistogram().GetSampleCount()
+			}
+		}
+	}
+	return 0
+}
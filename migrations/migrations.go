@@ -0,0 +1,168 @@
+// Package migrations versions the database schema. Each file in sql/ is one
+// migration, applied in order of the number that prefixes its name and
+// recorded in the schema_migrations table.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+const (
+	createMigrationsTableSQL = `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INT PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);
+	`
+	// lockSQL serializes migrations between replicas starting together.
+	lockSQL            = "SELECT pg_advisory_xact_lock(4827301)"
+	appliedVersionsSQL = "SELECT version FROM schema_migrations"
+	recordVersionSQL   = "INSERT INTO schema_migrations (version, name) values ($1, $2)"
+
+	undefinedTable = "42P01"
+)
+
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// All returns every migration, ordered by version.
+func All() ([]Migration, error) {
+	entries, err := files.ReadDir("sql")
+	if err != nil {
+		return nil, err
+	}
+
+	list := []Migration{}
+	for _, e := range entries {
+		name := strings.TrimSuffix(e.Name(), ".sql")
+		prefix, _, ok := strings.Cut(name, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %s: name must look like 0001_description.sql", e.Name())
+		}
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: invalid version: %w", e.Name(), err)
+		}
+		b, err := files.ReadFile(path.Join("sql", e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, Migration{Version: version, Name: name, SQL: string(b)})
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list, nil
+}
+
+func applied(ctx context.Context, q interface {
+	QueryContext(context.Context, string, ...any) (*sql.Rows, error)
+}) (map[int]bool, error) {
+	rows, err := q.QueryContext(ctx, appliedVersionsSQL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := map[int]bool{}
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		versions[v] = true
+	}
+	return versions, rows.Err()
+}
+
+// Pending returns the migrations that have not been applied yet. It only
+// reads, so it is safe to call from health checks.
+func Pending(ctx context.Context, db *sql.DB) ([]Migration, error) {
+	all, err := All()
+	if err != nil {
+		return nil, err
+	}
+	done, err := applied(ctx, db)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == undefinedTable {
+		done, err = map[int]bool{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't read applied migrations: %w", err)
+	}
+
+	pending := []Migration{}
+	for _, m := range all {
+		if !done[m.Version] {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies every pending migration, each in its own transaction, and
+// returns the ones it applied.
+func Up(ctx context.Context, db *sql.DB) ([]Migration, error) {
+	all, err := All()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.ExecContext(ctx, createMigrationsTableSQL); err != nil {
+		return nil, fmt.Errorf("can't create schema_migrations table: %w", err)
+	}
+
+	ran := []Migration{}
+	for _, m := range all {
+		ok, err := apply(ctx, db, m)
+		if err != nil {
+			return ran, fmt.Errorf("migration %s: %w", m.Name, err)
+		}
+		if ok {
+			ran = append(ran, m)
+		}
+	}
+	return ran, nil
+}
+
+func apply(ctx context.Context, db *sql.DB, m Migration) (bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, lockSQL); err != nil {
+		return false, err
+	}
+	done, err := applied(ctx, tx)
+	if err != nil {
+		return false, err
+	}
+	if done[m.Version] {
+		return false, nil
+	}
+
+	if _, err := tx.ExecContext(ctx, m.SQL); err != nil {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, recordVersionSQL, m.Version, m.Name); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS expenses (
+	id SERIAL PRIMARY KEY,
+	title TEXT,
+	amount FLOAT,
+	note TEXT,
+	tags TEXT[]
+);
+-- Expenses created before there were users keep a NULL owner, which only
+-- admins can see. server seed -owner USER -unowned gives them to USER.
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS owner TEXT;
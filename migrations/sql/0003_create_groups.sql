@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS expense_groups (
+	id SERIAL PRIMARY KEY,
+	name TEXT NOT NULL,
+	created_by TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE TABLE IF NOT EXISTS group_members (
+	group_id INT NOT NULL REFERENCES expense_groups(id) ON DELETE CASCADE,
+	username TEXT NOT NULL,
+	PRIMARY KEY (group_id, username)
+);
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS group_id INT REFERENCES expense_groups(id);
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS paid_by TEXT;
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS split_method TEXT;
+CREATE TABLE IF NOT EXISTS expense_splits (
+	id SERIAL PRIMARY KEY,
+	expense_id INT NOT NULL REFERENCES expenses(id) ON DELETE CASCADE,
+	username TEXT NOT NULL,
+	amount FLOAT NOT NULL,
+	percent FLOAT NOT NULL DEFAULT 0,
+	shares INT NOT NULL DEFAULT 0
+);
+CREATE TABLE IF NOT EXISTS group_settlements (
+	id SERIAL PRIMARY KEY,
+	group_id INT NOT NULL REFERENCES expense_groups(id) ON DELETE CASCADE,
+	from_user TEXT NOT NULL,
+	to_user TEXT NOT NULL,
+	amount FLOAT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+	owner TEXT NOT NULL,
+	key TEXT NOT NULL,
+	request_hash TEXT NOT NULL,
+	status_code INT,
+	content_type TEXT,
+	response_body BYTEA,
+	expires_at TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (owner, key)
+);
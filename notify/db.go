@@ -0,0 +1,3 @@
+package notify
+
+const notifySQL = "SELECT pg_notify($1, $2)"
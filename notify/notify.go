@@ -0,0 +1,176 @@
+// Package notify tells every replica about expense changes, wherever they
+// were made. The Notifier sends each change with PostgreSQL's NOTIFY, in the
+// transaction that makes it, and listens for them on a connection of its
+// own, passing what it hears to the local subscribers: the event streams and
+// caches of this replica.
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/PatcharaKL/assessment/rest/expenses"
+	"github.com/PatcharaKL/assessment/sqlstmt"
+	"github.com/lib/pq"
+)
+
+const (
+	// Channel is the channel changes are published on.
+	Channel = "expense_changes"
+
+	// pingInterval is how often the listener checks its connection, which
+	// can die silently behind a NAT or proxy.
+	pingInterval = 90 * time.Second
+)
+
+// Resyncer is a subscriber that is also told when changes may have been
+// missed: after the listener lost its connection, notifications sent until
+// it reconnected are gone. Caches drop what they hold; streams reread.
+type Resyncer interface {
+	Resync(ctx context.Context)
+}
+
+// message is what is sent with NOTIFY. The payload of a notification is
+// limited to 8000 bytes, so it names the expense rather than holding it.
+type message struct {
+	ID      int64     `json:"id"`
+	Type    string    `json:"type"`
+	Owner   string    `json:"owner,omitempty"`
+	Expense int       `json:"expense"`
+	Time    time.Time `json:"time"`
+}
+
+// listener is the part of pq.Listener the Notifier uses.
+type listener interface {
+	Listen(channel string) error
+	NotificationChannel() <-chan *pq.Notification
+	Ping() error
+	Close() error
+}
+
+// Notifier is the expenses.Recorder that shares changes between replicas.
+// Subscribers only hear about a change once it comes back from the
+// database, so every replica, including the one that made it, hears about
+// it the same way. Of the expense, the events they get carry only its ID.
+type Notifier struct {
+	Logger *slog.Logger
+
+	listener    listener
+	mu          sync.Mutex
+	subscribers []expenses.Publisher
+}
+
+// New returns a Notifier that listens on its own connection to url. When the
+// connection is lost it reconnects after minReconnect, doubling the wait up
+// to maxReconnect while the database stays unreachable.
+func New(url string, minReconnect, maxReconnect time.Duration, logger *slog.Logger) *Notifier {
+	n := &Notifier{Logger: logger}
+	n.listener = pq.NewListener(url, minReconnect, maxReconnect, n.logEvent)
+	return n
+}
+
+func (n *Notifier) logEvent(ev pq.ListenerEventType, err error) {
+	switch ev {
+	case pq.ListenerEventConnected:
+		n.Logger.Info("listening for expense changes", "channel", Channel)
+	case pq.ListenerEventDisconnected:
+		n.Logger.Warn("lost connection for expense changes, reconnecting", "error", err)
+	case pq.ListenerEventReconnected:
+		n.Logger.Info("reconnected for expense changes", "channel", Channel)
+	case pq.ListenerEventConnectionAttemptFailed:
+		n.Logger.Warn("can't connect for expense changes, retrying", "error", err)
+	}
+}
+
+// Subscribe adds a local subscriber. It is told about the changes made on
+// every replica and, if it is a Resyncer, when some may have been missed.
+func (n *Notifier) Subscribe(s expenses.Publisher) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.subscribers = append(n.subscribers, s)
+}
+
+// Record sends the change to every replica in tx, the transaction that
+// makes it. PostgreSQL delivers the notification when tx commits, and never
+// if it rolls back, so no committed change goes unannounced.
+func (n *Notifier) Record(ctx context.Context, tx sqlstmt.Querier, e expenses.Event) error {
+	payload, err := json.Marshal(message{ID: e.ID, Type: e.Type, Owner: e.Owner, Expense: e.Expense.ID, Time: e.Time})
+	if err != nil {
+		return fmt.Errorf("can't notify expense change: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, notifySQL, Channel, string(payload)); err != nil {
+		return fmt.Errorf("can't notify expense change: %w", err)
+	}
+	return nil
+}
+
+// Run listens for changes and passes them to the subscribers until ctx is
+// done. It blocks until the first connection is made.
+func (n *Notifier) Run(ctx context.Context) error {
+	defer n.listener.Close()
+
+	listening := make(chan error, 1)
+	go func() { listening <- n.listener.Listen(Channel) }()
+	select {
+	case err := <-listening:
+		if err != nil {
+			return fmt.Errorf("can't listen for expense changes: %w", err)
+		}
+	case <-ctx.Done():
+		return nil
+	}
+
+	notifications := n.listener.NotificationChannel()
+	ping := time.NewTicker(pingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case m, ok := <-notifications:
+			if !ok {
+				return errors.New("expense change listener closed")
+			}
+			if m == nil {
+				n.resync(ctx)
+			} else {
+				n.dispatch(ctx, m.Extra)
+			}
+		case <-ping.C:
+			// A dead connection fails the ping, and the listener reconnects.
+			go n.listener.Ping()
+		}
+	}
+}
+
+func (n *Notifier) dispatch(ctx context.Context, payload string) {
+	m := message{}
+	if err := json.Unmarshal([]byte(payload), &m); err != nil {
+		n.Logger.ErrorContext(ctx, "can't read expense change", "payload", payload, "error", err)
+		return
+	}
+	e := expenses.Event{ID: m.ID, Type: m.Type, Owner: m.Owner, Expense: expenses.Expenses{ID: m.Expense}, Time: m.Time}
+	for _, s := range n.subscribed() {
+		s.Publish(ctx, e)
+	}
+}
+
+func (n *Notifier) resync(ctx context.Context) {
+	n.Logger.WarnContext(ctx, "expense changes may have been missed, resyncing")
+	for _, s := range n.subscribed() {
+		if r, ok := s.(Resyncer); ok {
+			r.Resync(ctx)
+		}
+	}
+}
+
+func (n *Notifier) subscribed() []expenses.Publisher {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]expenses.Publisher(nil), n.subscribers...)
+}
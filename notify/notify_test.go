@@ -0,0 +1,201 @@
+//go:build unit
+// +build unit
+
+package notify
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/PatcharaKL/assessment/rest/expenses"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeListener hands out the notifications the test sends, the way
+// pq.Listener does: nil after it reconnects.
+type fakeListener struct {
+	listenErr     error
+	notifications chan *pq.Notification
+}
+
+func (l *fakeListener) Listen(channel string) error { return l.listenErr }
+func (l *fakeListener) NotificationChannel() <-chan *pq.Notification {
+	return l.notifications
+}
+func (l *fakeListener) Ping() error  { return nil }
+func (l *fakeListener) Close() error { return nil }
+
+// subscriber records what it is told.
+type subscriber struct {
+	mu      sync.Mutex
+	events  []expenses.Event
+	resyncs int
+}
+
+func (s *subscriber) Publish(ctx context.Context, e expenses.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, e)
+}
+
+func (s *subscriber) Resync(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resyncs++
+}
+
+func (s *subscriber) told() ([]expenses.Event, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]expenses.Event(nil), s.events...), s.resyncs
+}
+
+func setupNotifier(t *testing.T) (*Notifier, *fakeListener, *bytes.Buffer) {
+	var logs bytes.Buffer
+	l := &fakeListener{notifications: make(chan *pq.Notification)}
+	n := &Notifier{Logger: slog.New(slog.NewTextHandler(&logs, nil)), listener: l}
+	return n, l, &logs
+}
+
+// setupTx begins a transaction on a stub database.
+func setupTx(t *testing.T) (*sql.Tx, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	mock.ExpectBegin()
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tx, mock
+}
+
+// run runs the notifier until the test ends.
+func run(t *testing.T, n *Notifier) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		n.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func TestRecordNotifiesU(t *testing.T) {
+	// Arrange
+	n, _, _ := setupNotifier(t)
+	tx, mock := setupTx(t)
+	at := time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC)
+	mock.ExpectExec("SELECT pg_notify").
+		WithArgs(Channel, `{"id":7,"type":"expense.updated","owner":"Somchai","expense":3,"time":"2023-03-01T12:00:00Z"}`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	// Act
+	err := n.Record(context.Background(), tx, expenses.Event{ID: 7, Type: expenses.EventUpdated, Owner: "Somchai", Expense: expenses.Expenses{ID: 3, Title: "apple smoothie"}, Time: at})
+
+	// Assert
+	assert.NoError(t, err)
+	assert.NoError(t, tx.Commit())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRecordFailsU(t *testing.T) {
+	// Arrange
+	n, _, _ := setupNotifier(t)
+	tx, mock := setupTx(t)
+	mock.ExpectExec("SELECT pg_notify").WillReturnError(errors.New("connection reset"))
+	defer tx.Rollback()
+
+	// Act
+	err := n.Record(context.Background(), tx, expenses.Event{ID: 7, Type: expenses.EventCreated})
+
+	// Assert
+	assert.ErrorContains(t, err, "can't notify expense change", "the change fails with it")
+}
+
+func TestRunFansOutChangesU(t *testing.T) {
+	// Arrange
+	n, l, _ := setupNotifier(t)
+	first, second := &subscriber{}, &subscriber{}
+	n.Subscribe(first)
+	n.Subscribe(second)
+	run(t, n)
+
+	// Act
+	l.notifications <- &pq.Notification{Channel: Channel, Extra: `{"id":7,"type":"expense.created","owner":"Patchara","expense":3,"time":"2023-03-01T12:00:00Z"}`}
+	l.notifications <- &pq.Notification{Channel: Channel, Extra: `not json`}
+	l.notifications <- &pq.Notification{Channel: Channel, Extra: `{"id":8,"type":"expense.updated","owner":"Patchara","expense":3,"time":"2023-03-01T12:01:00Z"}`}
+
+	// Assert
+	for _, s := range []*subscriber{first, second} {
+		assert.Eventually(t, func() bool {
+			events, _ := s.told()
+			return len(events) == 2
+		}, time.Second, 10*time.Millisecond)
+		events, _ := s.told()
+		assert.Equal(t, expenses.Event{ID: 7, Type: expenses.EventCreated, Owner: "Patchara", Expense: expenses.Expenses{ID: 3}, Time: time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC)}, events[0])
+		assert.Equal(t, int64(8), events[1].ID, "a payload that can't be read is skipped")
+	}
+}
+
+func TestRunResyncsAfterReconnectU(t *testing.T) {
+	// Arrange
+	n, l, logs := setupNotifier(t)
+	s := &subscriber{}
+	n.Subscribe(s)
+	n.Subscribe(&recordingOnly{})
+	run(t, n)
+
+	// Act
+	l.notifications <- nil
+
+	// Assert
+	assert.Eventually(t, func() bool {
+		_, resyncs := s.told()
+		return resyncs == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Contains(t, logs.String(), "resyncing")
+}
+
+// recordingOnly is a subscriber that can't resync.
+type recordingOnly struct{}
+
+func (recordingOnly) Publish(ctx context.Context, e expenses.Event) {}
+
+func TestRunFailsWhenListenFailsU(t *testing.T) {
+	// Arrange
+	n, l, _ := setupNotifier(t)
+	l.listenErr = errors.New("permission denied")
+
+	// Act
+	err := n.Run(context.Background())
+
+	// Assert
+	assert.ErrorContains(t, err, "can't listen for expense changes")
+}
+
+func TestRunStopsWhenListenerClosesU(t *testing.T) {
+	// Arrange
+	n, l, _ := setupNotifier(t)
+	close(l.notifications)
+
+	// Act
+	err := n.Run(context.Background())
+
+	// Assert
+	assert.Error(t, err)
+}
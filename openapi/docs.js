@@ -0,0 +1,105 @@
+"use strict";
+
+// Renders /openapi.json. Everything from the spec goes through textContent,
+// never innerHTML.
+
+function el(tag, attrs, ...children) {
+  const e = document.createElement(tag);
+  for (const [k, v] of Object.entries(attrs || {})) {
+    e.setAttribute(k, v);
+  }
+  for (const c of children) {
+    if (c === undefined || c === null || c === "") continue;
+    e.append(typeof c === "string" ? document.createTextNode(c) : c);
+  }
+  return e;
+}
+
+function resolve(spec, obj) {
+  if (!obj || !obj.$ref) return obj;
+  return obj.$ref.replace(/^#\//, "").split("/").reduce((o, k) => (o ? o[k] : undefined), spec);
+}
+
+function typeOf(schema) {
+  if (!schema) return "";
+  if (schema.$ref) return schema.$ref.split("/").pop();
+  if (schema.type === "array") return typeOf(schema.items) + "[]";
+  if (schema.enum) return (schema.type || "") + " (" + schema.enum.join(", ") + ")";
+  return schema.type || "object";
+}
+
+function table(head, rows) {
+  if (rows.length === 0) return null;
+  return el("table", {},
+    el("thead", {}, el("tr", {}, ...head.map((h) => el("th", {}, h)))),
+    el("tbody", {}, ...rows.map((r) => el("tr", {}, ...r.map((c) => el("td", {}, c))))));
+}
+
+function bodySchema(content) {
+  if (!content) return "";
+  const [type, media] = Object.entries(content)[0];
+  return typeOf(media.schema) + " (" + type + ")";
+}
+
+function operation(spec, path, method, op, shared) {
+  const params = [...(shared || []), ...(op.parameters || [])].map((p) => resolve(spec, p));
+  const body = resolve(spec, op.requestBody);
+  const responses = Object.entries(op.responses || {}).map(([status, r]) => {
+    r = resolve(spec, r);
+    return [status, r.description || "", bodySchema(r.content)];
+  });
+  return el("details", {},
+    el("summary", {},
+      el("span", { class: "method " + method }, method), " ",
+      el("span", { class: "path" }, path), " ",
+      el("span", { class: "muted" }, op.summary || "")),
+    el("div", {},
+      op.description ? el("p", {}, op.description) : null,
+      table(["Parameter", "In", "Required", "Type", "Description"],
+        params.map((p) => [p.name, p.in, p.required ? "yes" : "no", typeOf(p.schema), p.description || ""])),
+      body ? el("p", {}, "Request body: ", el("code", {}, bodySchema(body.content))) : null,
+      table(["Status", "Description", "Body"], responses)));
+}
+
+function schemas(spec) {
+  const section = el("section", {}, el("h2", {}, "Schemas"));
+  for (const [name, schema] of Object.entries((spec.components || {}).schemas || {})) {
+    const required = new Set(schema.required || []);
+    const rows = Object.entries(schema.properties || {}).map(([prop, s]) =>
+      [prop, typeOf(s), required.has(prop) ? "yes" : "no", s.description || ""]);
+    section.append(el("details", { id: "schema-" + name },
+      el("summary", {}, el("code", {}, name), " ", el("span", { class: "muted" }, schema.description || "")),
+      el("div", {}, table(["Property", "Type", "Required", "Description"], rows) || el("p", {}, typeOf(schema)))));
+  }
+  return section;
+}
+
+function render(spec) {
+  const main = el("main", { id: "docs" },
+    el("h1", {}, spec.info.title + " ", el("span", { class: "muted" }, spec.info.version)),
+    el("p", {}, spec.info.description || ""),
+    el("p", {}, el("a", { href: "/openapi.json" }, "openapi.json")));
+
+  const byTag = new Map();
+  for (const [path, item] of Object.entries(spec.paths)) {
+    for (const method of ["get", "post", "put", "patch", "delete"]) {
+      const op = item[method];
+      if (!op) continue;
+      const tag = (op.tags || ["other"])[0];
+      if (!byTag.has(tag)) byTag.set(tag, []);
+      byTag.get(tag).push(operation(spec, path, method, op, item.parameters));
+    }
+  }
+  for (const [tag, ops] of byTag) {
+    main.append(el("section", {}, el("h2", {}, tag), ...ops));
+  }
+  main.append(schemas(spec));
+  document.getElementById("docs").replaceWith(main);
+}
+
+fetch("/openapi.json")
+  .then((res) => res.json())
+  .then(render)
+  .catch((err) => {
+    document.getElementById("docs").replaceChildren(el("p", {}, "Can't load the API description: " + err));
+  });
@@ -0,0 +1,282 @@
+// Package openapi serves the API's OpenAPI 3 document and validates requests
+// and responses against it.
+package openapi
+
+import (
+	"bytes"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/PatcharaKL/assessment/logging"
+	"github.com/PatcharaKL/assessment/problem"
+	"github.com/PatcharaKL/assessment/validation"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	SpecPath = "/openapi.json"
+	DocsPath = "/docs"
+)
+
+//go:embed openapi.yaml
+var specYAML []byte
+
+// The docs page renders the spec with a script of its own and loads nothing
+// from other origins: it runs on the API's origin, with its credentials.
+var (
+	//go:embed docs.html
+	docsPage string
+	//go:embed docs.js
+	docsJS string
+	//go:embed docs.css
+	docsCSS string
+
+	docsHTML = strings.NewReplacer("{{script}}", docsJS, "{{style}}", docsCSS).Replace(docsPage)
+	// docsCSP only lets the page run its own script and style, by hash, and
+	// fetch the spec.
+	docsCSP = "default-src 'none'; script-src " + cspHash(docsJS) + "; style-src " + cspHash(docsCSS) +
+		"; connect-src 'self'; base-uri 'none'; form-action 'none'; frame-ancestors 'none'"
+)
+
+func cspHash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return "'sha256-" + base64.StdEncoding.EncodeToString(sum[:]) + "'"
+}
+
+type Spec struct {
+	Doc *openapi3.T
+
+	json   []byte
+	router routers.Router
+}
+
+// Load parses and validates the embedded document.
+func Load() (*Spec, error) {
+	doc, err := openapi3.NewLoader().LoadFromData(specYAML)
+	if err != nil {
+		return nil, fmt.Errorf("can't load openapi spec: %w", err)
+	}
+	if err := doc.Validate(openapi3.NewLoader().Context); err != nil {
+		return nil, fmt.Errorf("invalid openapi spec: %w", err)
+	}
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		return nil, fmt.Errorf("can't route openapi spec: %w", err)
+	}
+	return &Spec{Doc: doc, json: b, router: router}, nil
+}
+
+// IsDocs reports whether the request is for the document or its UI, which are
+// served without authentication.
+func IsDocs(c echo.Context) bool {
+	p := c.Request().URL.Path
+	return p == SpecPath || p == DocsPath
+}
+
+func (s *Spec) SpecHandler(c echo.Context) error {
+	return c.JSONBlob(http.StatusOK, s.json)
+}
+
+func (s *Spec) DocsHandler(c echo.Context) error {
+	c.Response().Header().Set("Content-Security-Policy", docsCSP)
+	return c.HTML(http.StatusOK, docsHTML)
+}
+
+type recorder struct {
+	http.ResponseWriter
+	body    bytes.Buffer
+	written int
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.written += len(b)
+	if isJSON(r.Header()) {
+		r.body.Write(b)
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *recorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the connection's writer.
+func (r *recorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+func isJSON(h http.Header) bool {
+	ct := h.Get(echo.HeaderContentType)
+	return strings.HasPrefix(ct, echo.MIMEApplicationJSON) || strings.HasPrefix(ct, problem.MIMEApplicationProblemJSON)
+}
+
+// Middleware rejects requests that don't match the spec: a validation
+// problem listing the fields when only body fields are invalid, a bad request
+// otherwise. Responses
+// are checked once they have been sent, so a mismatch can only be logged;
+// the drift test is what keeps them in line. Routes the spec doesn't know
+// are passed through untouched.
+func (s *Spec) Middleware(l *slog.Logger) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			ctx := req.Context()
+			route, params, err := s.router.FindRoute(req)
+			if err != nil {
+				return next(c)
+			}
+
+			in := &openapi3filter.RequestValidationInput{
+				Request:    req,
+				PathParams: params,
+				Route:      route,
+				Options: &openapi3filter.Options{
+					AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+					MultiError:         true,
+				},
+			}
+			if err := openapi3filter.ValidateRequest(ctx, in); err != nil {
+				if fields, ok := FieldErrors(err); ok {
+					return problem.Invalid(fields)
+				}
+				return problem.BadRequest(Message(err))
+			}
+
+			rec := &recorder{ResponseWriter: c.Response().Writer}
+			c.Response().Writer = rec
+			err = next(c)
+			if !c.Response().Committed {
+				return err
+			}
+
+			if verr := ValidateResponse(in, c.Response().Status, c.Response().Header(), rec.body.Bytes(), rec.written); verr != nil {
+				l.ErrorContext(ctx, "response does not match openapi spec", append(logging.Attrs(ctx),
+					slog.String("method", req.Method),
+					slog.String("route", route.Path),
+					slog.Int("status", c.Response().Status),
+					slog.String("error", Message(verr)),
+				)...)
+			}
+			return err
+		}
+	}
+}
+
+// ValidateResponse checks a response to the request in in. Bodies that are
+// not JSON are only checked for their status and content type.
+func ValidateResponse(in *openapi3filter.RequestValidationInput, status int, header http.Header, body []byte, written int) error {
+	out := &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: in,
+		Status:                 status,
+		Header:                 header,
+		Options: &openapi3filter.Options{
+			IncludeResponseStatus: true,
+			ExcludeResponseBody:   written > 0 && !isJSON(header),
+		},
+	}
+	out.SetBodyBytes(body)
+	return openapi3filter.ValidateResponse(in.Request.Context(), out)
+}
+
+// FieldErrors reports whether every error in err is a request body field
+// that breaks its schema, and if so lists them in the same shape as the
+// validation package.
+func FieldErrors(err error) ([]validation.FieldError, bool) {
+	fields := []validation.FieldError{}
+	var walk func(err error, inBody bool) bool
+	walk = func(err error, inBody bool) bool {
+		switch e := err.(type) {
+		case openapi3.MultiError:
+			for _, err := range e {
+				if !walk(err, inBody) {
+					return false
+				}
+			}
+			return true
+		case *openapi3filter.RequestError:
+			return e.RequestBody != nil && e.Err != nil && walk(e.Err, true)
+		case *openapi3.SchemaError:
+			fields = append(fields, validation.FieldError{Field: fieldPath(e.JSONPointer()), Message: e.Reason})
+			return inBody
+		}
+		return false
+	}
+	if !walk(err, false) || len(fields) == 0 {
+		return nil, false
+	}
+	return fields, true
+}
+
+// fieldPath turns a JSON pointer such as [tags 3] into tags[3].
+func fieldPath(pointer []string) string {
+	var b strings.Builder
+	for _, p := range pointer {
+		if _, err := strconv.Atoi(p); err == nil {
+			b.WriteString("[" + p + "]")
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString(".")
+		}
+		b.WriteString(p)
+	}
+	return b.String()
+}
+
+// Message turns a validation error into a short message for the client,
+// without the schema dump the validator includes by default.
+func Message(err error) string {
+	var where string
+	var reqErr *openapi3filter.RequestError
+	if errors.As(err, &reqErr) {
+		switch {
+		case reqErr.Parameter != nil:
+			where = fmt.Sprintf("%s parameter %q", reqErr.Parameter.In, reqErr.Parameter.Name)
+		case reqErr.RequestBody != nil:
+			where = "request body"
+		}
+	}
+	var resErr *openapi3filter.ResponseError
+	if errors.As(err, &resErr) {
+		where = "response body"
+	}
+
+	detail := err.Error()
+	var schemaErr *openapi3.SchemaError
+	switch {
+	case errors.As(err, &schemaErr):
+		detail = schemaErr.Reason
+		if p := schemaErr.JSONPointer(); len(p) > 0 {
+			detail = "/" + strings.Join(p, "/") + ": " + detail
+		}
+	case reqErr != nil && reqErr.Err != nil:
+		detail = reqErr.Err.Error()
+	case reqErr != nil:
+		detail = reqErr.Reason
+	case resErr != nil:
+		detail = resErr.Reason
+	}
+
+	if where == "" {
+		return detail
+	}
+	return where + ": " + detail
+}
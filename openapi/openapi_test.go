@@ -0,0 +1,113 @@
+//go:build unit
+// +build unit
+
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/PatcharaKL/assessment/logging"
+	"github.com/PatcharaKL/assessment/problem"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupServer(t *testing.T, handler echo.HandlerFunc) (*echo.Echo, *bytes.Buffer) {
+	spec, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logs bytes.Buffer
+	e := echo.New()
+	e.HTTPErrorHandler = problem.HTTPErrorHandler
+	e.Use(spec.Middleware(logging.New(&logs, "info")))
+	e.GET(SpecPath, spec.SpecHandler)
+	e.POST("/expenses", handler)
+	e.GET("/expenses/:id", handler)
+	e.GET("/unknown", handler)
+	return e, &logs
+}
+
+func serve(e *echo.Echo, method, uri, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, uri, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestSpecHandler(t *testing.T) {
+	e, _ := setupServer(t, nil)
+
+	rec := serve(e, http.MethodGet, SpecPath, "")
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	doc := map[string]any{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &doc))
+	assert.Equal(t, "3.0.3", doc["openapi"])
+}
+
+func TestDocsHandler(t *testing.T) {
+	spec, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, rec := echo.New(), httptest.NewRecorder()
+
+	err = spec.DocsHandler(c.NewContext(httptest.NewRequest(http.MethodGet, DocsPath, nil), rec))
+
+	assert.NoError(t, err)
+	assert.Contains(t, rec.Header().Get("Content-Security-Policy"), "script-src "+cspHash(docsJS)+";")
+	assert.Contains(t, rec.Body.String(), docsJS)
+	assert.NotContains(t, rec.Body.String(), "https://")
+}
+
+func TestRequestValidation(t *testing.T) {
+	ok := func(c echo.Context) error {
+		return c.JSON(http.StatusCreated, map[string]any{"id": 1, "title": "strawberry smoothie", "amount": 79})
+	}
+	tests := []struct {
+		name   string
+		method string
+		uri    string
+		body   string
+		code   int
+		msg    string
+	}{
+		{"valid body", http.MethodPost, "/expenses", `{"title": "strawberry smoothie", "amount": 79}`, http.StatusCreated, ""},
+		{"invalid fields", http.MethodPost, "/expenses", `{"title": "", "amount": "79"}`, http.StatusUnprocessableEntity, `"errors":[{"field":"amount","message":"value must be a number"},{"field":"title","message":"minimum string length is 1"}]`},
+		{"malformed body", http.MethodPost, "/expenses", `{"title": "strawberry smoothie"`, http.StatusBadRequest, "request body: unexpected EOF"},
+		{"invalid path parameter", http.MethodGet, "/expenses/abc", ``, http.StatusBadRequest, `"detail":"path parameter \"id\": value abc: an invalid integer: invalid syntax"`},
+		{"route not in spec", http.MethodGet, "/unknown", ``, http.StatusCreated, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, _ := setupServer(t, ok)
+
+			rec := serve(e, tt.method, tt.uri, tt.body)
+
+			assert.Equal(t, tt.code, rec.Code)
+			if tt.msg != "" {
+				assert.Contains(t, rec.Body.String(), tt.msg)
+			}
+		})
+	}
+}
+
+func TestResponseValidation(t *testing.T) {
+	drifted := func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]any{"id": "1", "title": "strawberry smoothie"})
+	}
+	e, logs := setupServer(t, drifted)
+
+	rec := serve(e, http.MethodGet, "/expenses/1", "")
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, logs.String(), "response does not match openapi spec")
+	assert.Contains(t, logs.String(), "/id: value must be an integer")
+}
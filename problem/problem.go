@@ -0,0 +1,208 @@
+// Package problem defines the service's domain errors and writes every error
+// response as an RFC 7807 application/problem+json document. Handlers return
+// errors instead of writing error bodies; HTTPErrorHandler maps them in one
+// place, so each failure has a stable code and server errors never leak
+// their cause to the client.
+package problem
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/PatcharaKL/assessment/logging"
+	"github.com/PatcharaKL/assessment/validation"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+const MIMEApplicationProblemJSON = "application/problem+json"
+
+// Code identifies the kind of failure. Codes are part of the API contract:
+// clients branch on them, so they never change once published.
+type Code string
+
+const (
+	CodeInvalidRequest   Code = "invalid_request"
+	CodeInvalidID        Code = "invalid_id"
+	CodeValidationFailed Code = "validation_failed"
+	CodeUnauthorized     Code = "unauthorized"
+	CodeForbidden        Code = "forbidden"
+	CodeNotFound         Code = "not_found"
+	CodeConflict         Code = "conflict"
+	// CodePreconditionFailed means the resource changed since the client
+	// read the version its If-Match names.
+	CodePreconditionFailed Code = "precondition_failed"
+	CodeInternal           Code = "internal_error"
+	CodeUnavailable        Code = "unavailable"
+	CodeTimeout            Code = "timeout"
+)
+
+const (
+	// uniqueViolation is the Postgres error code for a duplicate key.
+	uniqueViolation = "23505"
+	// queryCanceled is the Postgres error code for a statement cancelled by
+	// its statement_timeout or by a cancel request.
+	queryCanceled = "57014"
+)
+
+// Error is a failure the client can act on.
+type Error struct {
+	Status int
+	Code   Code
+	Detail string
+	Fields []validation.FieldError
+}
+
+func (e *Error) Error() string {
+	return e.Detail
+}
+
+// Is matches errors by code, so errors.Is(err, problem.ErrNotFound) holds for
+// every not found error whatever its detail.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+var (
+	ErrNotFound = &Error{Status: http.StatusNotFound, Code: CodeNotFound}
+	ErrConflict = &Error{Status: http.StatusConflict, Code: CodeConflict}
+)
+
+func New(status int, code Code, detail string) *Error {
+	return &Error{Status: status, Code: code, Detail: detail}
+}
+
+func BadRequest(detail string) *Error {
+	return New(http.StatusBadRequest, CodeInvalidRequest, detail)
+}
+
+func InvalidID(param, value string) *Error {
+	return New(http.StatusBadRequest, CodeInvalidID, fmt.Sprintf("%s must be a positive integer, got %q", param, value))
+}
+
+func Forbidden(detail string) *Error {
+	return New(http.StatusForbidden, CodeForbidden, detail)
+}
+
+func NotFound(detail string) *Error {
+	return New(http.StatusNotFound, CodeNotFound, detail)
+}
+
+func Conflict(detail string) *Error {
+	return New(http.StatusConflict, CodeConflict, detail)
+}
+
+func PreconditionFailed(detail string) *Error {
+	return New(http.StatusPreconditionFailed, CodePreconditionFailed, detail)
+}
+
+func Invalid(fields []validation.FieldError) *Error {
+	return &Error{Status: http.StatusUnprocessableEntity, Code: CodeValidationFailed, Detail: validation.Message, Fields: fields}
+}
+
+// Problem is the response body, as described in RFC 7807, extended with the
+// stable code, the request ID to quote in support requests, and the invalid
+// fields of a 422.
+type Problem struct {
+	Type      string                  `json:"type"`
+	Title     string                  `json:"title"`
+	Status    int                     `json:"status"`
+	Detail    string                  `json:"detail,omitempty"`
+	Instance  string                  `json:"instance,omitempty"`
+	Code      Code                    `json:"code"`
+	RequestID string                  `json:"request_id,omitempty"`
+	Errors    []validation.FieldError `json:"errors,omitempty"`
+}
+
+// From maps err to the problem it is reported as. Errors that aren't
+// recognized are server errors, and their message stays in the logs.
+func From(err error) Problem {
+	var e *Error
+	var verrs *validation.Errors
+	var he *echo.HTTPError
+	var pqErr *pq.Error
+	switch {
+	case errors.As(err, &e):
+	case errors.As(err, &verrs):
+		e = Invalid(verrs.Errors)
+	case errors.As(err, &he):
+		e = New(he.Code, codeFor(he.Code), fmt.Sprint(he.Message))
+		if he.Code >= http.StatusInternalServerError {
+			e.Detail = http.StatusText(he.Code)
+		}
+	case errors.Is(err, sql.ErrNoRows):
+		e = NotFound("resource not found")
+	case errors.As(err, &pqErr) && pqErr.Code == uniqueViolation:
+		e = Conflict("resource already exists")
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &pqErr) && pqErr.Code == queryCanceled:
+		e = New(http.StatusGatewayTimeout, CodeTimeout, "the request took too long")
+	case errors.Is(err, context.Canceled):
+		e = New(http.StatusServiceUnavailable, CodeUnavailable, "the request was cancelled")
+	default:
+		e = New(http.StatusInternalServerError, CodeInternal, "internal server error")
+	}
+
+	return Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(e.Status),
+		Status: e.Status,
+		Detail: e.Detail,
+		Code:   e.Code,
+		Errors: e.Fields,
+	}
+}
+
+func codeFor(status int) Code {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeInvalidRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusPreconditionFailed:
+		return CodePreconditionFailed
+	case http.StatusUnprocessableEntity:
+		return CodeValidationFailed
+	}
+	if status >= http.StatusInternalServerError {
+		return CodeInternal
+	}
+	return CodeInvalidRequest
+}
+
+// HTTPErrorHandler writes err as a problem response. It replaces echo's
+// default error handler.
+func HTTPErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	ctx := c.Request().Context()
+	p := From(err)
+	p.Instance = c.Request().URL.Path
+	if r, ok := logging.RequestFrom(ctx); ok {
+		p.RequestID = r.ID
+	}
+	if p.Status >= http.StatusInternalServerError {
+		logging.SetError(ctx, err)
+	}
+
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(p.Status)
+	} else {
+		c.Response().Header().Set(echo.HeaderContentType, MIMEApplicationProblemJSON)
+		err = c.JSON(p.Status, p)
+	}
+	if err != nil {
+		c.Logger().Error(err)
+	}
+}
@@ -0,0 +1,86 @@
+//go:build unit
+// +build unit
+
+package problem
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/PatcharaKL/assessment/logging"
+	"github.com/PatcharaKL/assessment/validation"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFrom(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   Code
+		detail string
+	}{
+		{"typed", NotFound("expense 1 not found"), http.StatusNotFound, CodeNotFound, "expense 1 not found"},
+		{"wrapped typed", fmt.Errorf("lookup: %w", InvalidID("id", "abc")), http.StatusBadRequest, CodeInvalidID, `id must be a positive integer, got "abc"`},
+		{"validation", &validation.Errors{Message: validation.Message, Errors: []validation.FieldError{{Field: "title", Message: "is required"}}}, http.StatusUnprocessableEntity, CodeValidationFailed, validation.Message},
+		{"echo", echo.ErrUnauthorized, http.StatusUnauthorized, CodeUnauthorized, "Unauthorized"},
+		{"echo server error", echo.NewHTTPError(http.StatusServiceUnavailable, "pool exhausted"), http.StatusServiceUnavailable, CodeInternal, "Service Unavailable"},
+		{"no rows", fmt.Errorf("can't get expense: %w", sql.ErrNoRows), http.StatusNotFound, CodeNotFound, "resource not found"},
+		{"unique violation", fmt.Errorf("can't create user: %w", &pq.Error{Code: uniqueViolation, Detail: "Key (username)=(somchai) already exists."}), http.StatusConflict, CodeConflict, "resource already exists"},
+		{"timeout", fmt.Errorf("can't get expense: %w", fmt.Errorf("%w: %w", context.DeadlineExceeded, errors.New("pq: canceling statement due to user request"))), http.StatusGatewayTimeout, CodeTimeout, "the request took too long"},
+		{"statement timeout", &pq.Error{Code: queryCanceled, Message: "canceling statement due to statement timeout"}, http.StatusGatewayTimeout, CodeTimeout, "the request took too long"},
+		{"cancelled", fmt.Errorf("can't query expenses: %w", context.Canceled), http.StatusServiceUnavailable, CodeUnavailable, "the request was cancelled"},
+		{"unknown", errors.New(`pq: syntax error at or near "abc"`), http.StatusInternalServerError, CodeInternal, "internal server error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := From(tt.err)
+
+			assert.Equal(t, tt.status, p.Status)
+			assert.Equal(t, tt.code, p.Code)
+			assert.Equal(t, tt.detail, p.Detail)
+			assert.Equal(t, http.StatusText(tt.status), p.Title)
+		})
+	}
+}
+
+func TestIs(t *testing.T) {
+	assert.ErrorIs(t, fmt.Errorf("wrapped: %w", NotFound("group 3 not found")), ErrNotFound)
+	assert.NotErrorIs(t, Conflict("exists"), ErrNotFound)
+}
+
+func TestHTTPErrorHandler(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/expenses/1", nil)
+	r := &logging.Request{ID: "req-1"}
+	req = req.WithContext(logging.WithRequest(req.Context(), r))
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	cause := errors.New("dial tcp: connection refused")
+
+	HTTPErrorHandler(fmt.Errorf("can't get expense: %w", cause), c)
+
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Equal(t, MIMEApplicationProblemJSON, rec.Header().Get(echo.HeaderContentType))
+	var p Problem
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &p))
+	assert.Equal(t, Problem{
+		Type:      "about:blank",
+		Title:     "Internal Server Error",
+		Status:    http.StatusInternalServerError,
+		Detail:    "internal server error",
+		Instance:  "/expenses/1",
+		Code:      CodeInternal,
+		RequestID: "req-1",
+	}, p)
+	assert.NotContains(t, rec.Body.String(), "connection refused")
+	assert.ErrorIs(t, r.Err(), cause)
+}
@@ -0,0 +1,289 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v4.25.3
+// source: proto/expenses/v1/expenses.proto
+
+package expensesv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	ExpensesService_CreateExpense_FullMethodName  = "/expenses.v1.ExpensesService/CreateExpense"
+	ExpensesService_GetExpense_FullMethodName     = "/expenses.v1.ExpensesService/GetExpense"
+	ExpensesService_ListExpenses_FullMethodName   = "/expenses.v1.ExpensesService/ListExpenses"
+	ExpensesService_StreamExpenses_FullMethodName = "/expenses.v1.ExpensesService/StreamExpenses"
+	ExpensesService_UpdateExpense_FullMethodName  = "/expenses.v1.ExpensesService/UpdateExpense"
+)
+
+// ExpensesServiceClient is the client API for ExpensesService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ExpensesServiceClient interface {
+	CreateExpense(ctx context.Context, in *CreateExpenseRequest, opts ...grpc.CallOption) (*Expense, error)
+	GetExpense(ctx context.Context, in *GetExpenseRequest, opts ...grpc.CallOption) (*Expense, error)
+	ListExpenses(ctx context.Context, in *ListExpensesRequest, opts ...grpc.CallOption) (*ListExpensesResponse, error)
+	// StreamExpenses sends the same expenses as ListExpenses one at a time, so
+	// large ledgers needn't fit in a single message.
+	StreamExpenses(ctx context.Context, in *ListExpensesRequest, opts ...grpc.CallOption) (ExpensesService_StreamExpensesClient, error)
+	UpdateExpense(ctx context.Context, in *UpdateExpenseRequest, opts ...grpc.CallOption) (*Expense, error)
+}
+
+type expensesServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewExpensesServiceClient(cc grpc.ClientConnInterface) ExpensesServiceClient {
+	return &expensesServiceClient{cc}
+}
+
+func (c *expensesServiceClient) CreateExpense(ctx context.Context, in *CreateExpenseRequest, opts ...grpc.CallOption) (*Expense, error) {
+	out := new(Expense)
+	err := c.cc.Invoke(ctx, ExpensesService_CreateExpense_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *expensesServiceClient) GetExpense(ctx context.Context, in *GetExpenseRequest, opts ...grpc.CallOption) (*Expense, error) {
+	out := new(Expense)
+	err := c.cc.Invoke(ctx, ExpensesService_GetExpense_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *expensesServiceClient) ListExpenses(ctx context.Context, in *ListExpensesRequest, opts ...grpc.CallOption) (*ListExpensesResponse, error) {
+	out := new(ListExpensesResponse)
+	err := c.cc.Invoke(ctx, ExpensesService_ListExpenses_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *expensesServiceClient) StreamExpenses(ctx context.Context, in *ListExpensesRequest, opts ...grpc.CallOption) (ExpensesService_StreamExpensesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &ExpensesService_ServiceDesc.Streams[0], ExpensesService_StreamExpenses_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &expensesServiceStreamExpensesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type ExpensesService_StreamExpensesClient interface {
+	Recv() (*Expense, error)
+	grpc.ClientStream
+}
+
+type expensesServiceStreamExpensesClient struct {
+	grpc.ClientStream
+}
+
+func (x *expensesServiceStreamExpensesClient) Recv() (*Expense, error) {
+	m := new(Expense)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *expensesServiceClient) UpdateExpense(ctx context.Context, in *UpdateExpenseRequest, opts ...grpc.CallOption) (*Expense, error) {
+	out := new(Expense)
+	err := c.cc.Invoke(ctx, ExpensesService_UpdateExpense_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ExpensesServiceServer is the server API for ExpensesService service.
+// All implementations must embed UnimplementedExpensesServiceServer
+// for forward compatibility
+type ExpensesServiceServer interface {
+	CreateExpense(context.Context, *CreateExpenseRequest) (*Expense, error)
+	GetExpense(context.Context, *GetExpenseRequest) (*Expense, error)
+	ListExpenses(context.Context, *ListExpensesRequest) (*ListExpensesResponse, error)
+	// StreamExpenses sends the same expenses as ListExpenses one at a time, so
+	// large ledgers needn't fit in a single message.
+	StreamExpenses(*ListExpensesRequest, ExpensesService_StreamExpensesServer) error
+	UpdateExpense(context.Context, *UpdateExpenseRequest) (*Expense, error)
+	mustEmbedUnimplementedExpensesServiceServer()
+}
+
+// UnimplementedExpensesServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedExpensesServiceServer struct {
+}
+
+func (UnimplementedExpensesServiceServer) CreateExpense(context.Context, *CreateExpenseRequest) (*Expense, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateExpense not implemented")
+}
+func (UnimplementedExpensesServiceServer) GetExpense(context.Context, *GetExpenseRequest) (*Expense, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetExpense not implemented")
+}
+func (UnimplementedExpensesServiceServer) ListExpenses(context.Context, *ListExpensesRequest) (*ListExpensesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListExpenses not implemented")
+}
+func (UnimplementedExpensesServiceServer) StreamExpenses(*ListExpensesRequest, ExpensesService_StreamExpensesServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamExpenses not implemented")
+}
+func (UnimplementedExpensesServiceServer) UpdateExpense(context.Context, *UpdateExpenseRequest) (*Expense, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateExpense not implemented")
+}
+func (UnimplementedExpensesServiceServer) mustEmbedUnimplementedExpensesServiceServer() {}
+
+// UnsafeExpensesServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ExpensesServiceServer will
+// result in compilation errors.
+type UnsafeExpensesServiceServer interface {
+	mustEmbedUnimplementedExpensesServiceServer()
+}
+
+func RegisterExpensesServiceServer(s grpc.ServiceRegistrar, srv ExpensesServiceServer) {
+	s.RegisterService(&ExpensesService_ServiceDesc, srv)
+}
+
+func _ExpensesService_CreateExpense_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateExpenseRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ExpensesServiceServer).CreateExpense(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ExpensesService_CreateExpense_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ExpensesServiceServer).CreateExpense(ctx, req.(*CreateExpenseRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ExpensesService_GetExpense_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetExpenseRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ExpensesServiceServer).GetExpense(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ExpensesService_GetExpense_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ExpensesServiceServer).GetExpense(ctx, req.(*GetExpenseRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ExpensesService_ListExpenses_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListExpensesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ExpensesServiceServer).ListExpenses(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ExpensesService_ListExpenses_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ExpensesServiceServer).ListExpenses(ctx, req.(*ListExpensesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ExpensesService_StreamExpenses_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListExpensesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ExpensesServiceServer).StreamExpenses(m, &expensesServiceStreamExpensesServer{stream})
+}
+
+type ExpensesService_StreamExpensesServer interface {
+	Send(*Expense) error
+	grpc.ServerStream
+}
+
+type expensesServiceStreamExpensesServer struct {
+	grpc.ServerStream
+}
+
+func (x *expensesServiceStreamExpensesServer) Send(m *Expense) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _ExpensesService_UpdateExpense_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateExpenseRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ExpensesServiceServer).UpdateExpense(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ExpensesService_UpdateExpense_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ExpensesServiceServer).UpdateExpense(ctx, req.(*UpdateExpenseRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// ExpensesService_ServiceDesc is the grpc.ServiceDesc for ExpensesService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ExpensesService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "expenses.v1.ExpensesService",
+	HandlerType: (*ExpensesServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CreateExpense",
+			Handler:    _ExpensesService_CreateExpense_Handler,
+		},
+		{
+			MethodName: "GetExpense",
+			Handler:    _ExpensesService_GetExpense_Handler,
+		},
+		{
+			MethodName: "ListExpenses",
+			Handler:    _ExpensesService_ListExpenses_Handler,
+		},
+		{
+			MethodName: "UpdateExpense",
+			Handler:    _ExpensesService_UpdateExpense_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamExpenses",
+			Handler:       _ExpensesService_StreamExpenses_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "proto/expenses/v1/expenses.proto",
+}
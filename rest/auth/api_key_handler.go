@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"github.com/PatcharaKL/assessment/problem"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+const (
+	keyPrefix    = "exp_"
+	keyPrefixLen = 12
+)
+
+type createAPIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// generateKey returns a new plaintext API key. Only its hash is persisted, so
+// the plaintext is shown to the caller exactly once.
+func generateKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return keyPrefix + hex.EncodeToString(b), nil
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func (h *Handler) CreateAPIKeyHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	p, _ := PrincipalFrom(c)
+	req := createAPIKeyRequest{}
+
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+	if len(req.Scopes) == 0 {
+		return problem.BadRequest("at least one scope is required")
+	}
+	for _, s := range req.Scopes {
+		if !validScope(s) || !p.Can(s) {
+			return problem.BadRequest("invalid scope: " + s)
+		}
+	}
+
+	key, err := generateKey()
+	if err != nil {
+		return fmt.Errorf("can't generate api key: %w", err)
+	}
+
+	k := APIKey{Name: req.Name, Prefix: key[:keyPrefixLen], Scopes: req.Scopes, Key: key}
+	if err := h.DB.QueryRowContext(ctx, createAPIKeySQL, p.User, k.Name, k.Prefix, hashKey(key), pq.Array(k.Scopes)).Scan(&k.ID, &k.CreatedAt); err != nil {
+		return fmt.Errorf("can't create api key: %w", err)
+	}
+
+	return c.JSON(http.StatusCreated, k)
+}
+
+func (h *Handler) GetAPIKeysHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	p, _ := PrincipalFrom(c)
+
+	rows, err := h.DB.QueryContext(ctx, getAPIKeysSQL, p.User)
+	if err != nil {
+		return fmt.Errorf("can't query api keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		k := APIKey{}
+		if err := rows.Scan(&k.ID, &k.Name, &k.Prefix, pq.Array(&k.Scopes), &k.CreatedAt, &k.RevokedAt); err != nil {
+			return fmt.Errorf("can't scan api key: %w", err)
+		}
+		keys = append(keys, k)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("can't query api keys: %w", err)
+	}
+
+	return c.JSON(http.StatusOK, keys)
+}
+
+func (h *Handler) RevokeAPIKeyHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	p, _ := PrincipalFrom(c)
+	id, err := paramID(c)
+	if err != nil {
+		return err
+	}
+
+	res, err := h.DB.ExecContext(ctx, revokeAPIKeySQL, id, p.User)
+	if err != nil {
+		return fmt.Errorf("can't revoke api key: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return problem.NotFound("api key not found")
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// AuthenticateAPIKey accepts unrevoked keys of enabled users. The principal
+// is the key's owner with the owner's role and the key's scopes.
+func (h *Handler) AuthenticateAPIKey(ctx context.Context, key string) (Principal, bool, error) {
+	p := Principal{}
+	err := h.DB.QueryRowContext(ctx, findAPIKeySQL, hashKey(key)).Scan(&p.User, pq.Array(&p.Scopes), &p.Role, &p.Ledger)
+	if err == sql.ErrNoRows {
+		return Principal{}, false, nil
+	}
+	if err != nil {
+		return Principal{}, false, err
+	}
+	return p, true, nil
+}
+
+// ValidateAPIKey is the validator for echo's KeyAuth middleware. It stores
+// the principal of a valid key on the request.
+func (h *Handler) ValidateAPIKey(key string, c echo.Context) (bool, error) {
+	p, ok, err := h.AuthenticateAPIKey(c.Request().Context(), key)
+	if !ok || err != nil {
+		return false, err
+	}
+
+	SetPrincipal(c, p)
+	return true, nil
+}
@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PatcharaKL/assessment/logging"
+	"github.com/PatcharaKL/assessment/problem"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	ScopeExpensesRead   = "expenses:read"
+	ScopeExpensesWrite  = "expenses:write"
+	ScopeReportsRead    = "reports:read"
+	ScopeKeysManage     = "keys:manage"
+	ScopeUsersManage    = "users:manage"
+	ScopeWebhooksManage = "webhooks:manage"
+
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+	RoleViewer = "viewer"
+
+	principalKey = "principal"
+)
+
+var AllScopes = []string{ScopeExpensesRead, ScopeExpensesWrite, ScopeReportsRead, ScopeKeysManage, ScopeUsersManage, ScopeWebhooksManage}
+
+// Policy maps each role to the actions it may perform. Actions share their
+// names with API key scopes, so a request is allowed only when both the role
+// and the credential's scopes permit it.
+var Policy = map[string][]string{
+	RoleAdmin:  {ScopeExpensesRead, ScopeExpensesWrite, ScopeReportsRead, ScopeKeysManage, ScopeUsersManage, ScopeWebhooksManage},
+	RoleMember: {ScopeExpensesRead, ScopeExpensesWrite, ScopeReportsRead, ScopeKeysManage, ScopeWebhooksManage},
+	RoleViewer: {ScopeExpensesRead, ScopeReportsRead},
+}
+
+type APIKey struct {
+	ID        int        `json:"id"`
+	Name      string     `json:"name"`
+	Prefix    string     `json:"prefix"`
+	Scopes    []string   `json:"scopes"`
+	Key       string     `json:"key,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+type User struct {
+	ID        int       `json:"id"`
+	Username  string    `json:"username"`
+	Password  string    `json:"password,omitempty"`
+	Role      string    `json:"role"`
+	Ledger    string    `json:"ledger,omitempty"`
+	Disabled  bool      `json:"disabled"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type Principal struct {
+	User   string
+	Role   string
+	Ledger string
+	Scopes []string
+}
+
+func (p Principal) HasScope(scope string) bool {
+	return contains(p.Scopes, scope)
+}
+
+// Can reports whether the principal's role and scopes both allow action.
+func (p Principal) Can(action string) bool {
+	return contains(Policy[p.Role], action) && p.HasScope(action)
+}
+
+// Username returns the authenticated user's name, or "" for anonymous requests.
+func Username(c echo.Context) string {
+	p, _ := PrincipalFrom(c)
+	return p.User
+}
+
+// OwnerFilter returns the owner whose data the principal may access. Admins
+// get a NULL filter, which matches every row; members see their own data and
+// viewers the ledger they were granted, defaulting to their own.
+func (p Principal) OwnerFilter() sql.NullString {
+	switch {
+	case p.Role == RoleAdmin:
+		return sql.NullString{}
+	case p.Role == RoleViewer && p.Ledger != "":
+		return sql.NullString{String: p.Ledger, Valid: true}
+	default:
+		return sql.NullString{String: p.User, Valid: true}
+	}
+}
+
+// OwnerFilter is Principal.OwnerFilter for the request's principal.
+func OwnerFilter(c echo.Context) sql.NullString {
+	p, _ := PrincipalFrom(c)
+	return p.OwnerFilter()
+}
+
+type Handler struct {
+	DB *sql.DB
+}
+
+func NewApplication(db *sql.DB) *Handler {
+	return &Handler{db}
+}
+
+// SetPrincipal stores p on the echo context and on the request context, so
+// that code below the handler can reach it with PrincipalFromContext.
+func SetPrincipal(c echo.Context, p Principal) {
+	c.Set(principalKey, p)
+	req := c.Request()
+	c.SetRequest(req.WithContext(WithPrincipal(req.Context(), p)))
+	logging.SetUser(req.Context(), p.User)
+}
+
+type principalCtxKey struct{}
+
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalCtxKey{}, p)
+}
+
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalCtxKey{}).(Principal)
+	return p, ok
+}
+
+func PrincipalFrom(c echo.Context) (Principal, bool) {
+	p, ok := c.Get(principalKey).(Principal)
+	return p, ok
+}
+
+// HasBearerToken reports whether the request authenticates with an API key
+// instead of basic auth.
+func HasBearerToken(c echo.Context) bool {
+	return strings.HasPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+}
+
+func NoBearerToken(c echo.Context) bool {
+	return !HasBearerToken(c)
+}
+
+func RequirePermission(action string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			p, ok := PrincipalFrom(c)
+			if !ok || !p.Can(action) {
+				return problem.Forbidden("permission denied: " + action)
+			}
+			return next(c)
+		}
+	}
+}
+
+func validScope(scope string) bool {
+	return contains(AllScopes, scope)
+}
+
+func paramID(c echo.Context) (int, error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		return 0, problem.InvalidID("id", c.Param("id"))
+	}
+	return id, nil
+}
+
+func validRole(role string) bool {
+	_, ok := Policy[role]
+	return ok
+}
+
+func contains(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,406 @@
+//go:build unit
+// +build unit
+
+package auth
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/PatcharaKL/assessment/problem"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func setupTestServer(method, uri string, body *bytes.Buffer) (*httptest.ResponseRecorder, echo.Context) {
+	e := echo.New()
+	req := httptest.NewRequest(method, uri, body)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	return rec, c
+}
+
+// respond writes err the way the server's error handler does, so that the
+// assertions can check the response whatever the handler returned.
+func respond(c echo.Context, err error) error {
+	if err != nil {
+		problem.HTTPErrorHandler(err, c)
+	}
+	return nil
+}
+
+func TestCreateAPIKeyU(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         *bytes.Buffer
+		expectedCode int
+	}{
+		{
+			name:         "testSucceed",
+			body:         bytes.NewBufferString(`{"name": "backup script", "scopes": ["expenses:read"]}`),
+			expectedCode: http.StatusCreated,
+		},
+		{
+			name:         "testUnknownScope",
+			body:         bytes.NewBufferString(`{"name": "backup script", "scopes": ["expenses:delete"]}`),
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "testNoScope",
+			body:         bytes.NewBufferString(`{"name": "backup script"}`),
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			rec, c := setupTestServer(http.MethodPost, "/api-keys", tt.body)
+			SetPrincipal(c, Principal{User: "Patchara", Role: RoleAdmin, Scopes: AllScopes})
+
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			if tt.name == "testSucceed" {
+				mock.ExpectQuery("INSERT INTO api_keys").
+					WithArgs("Patchara", "backup script", sqlmock.AnyArg(), sqlmock.AnyArg(), pq.Array([]string{"expenses:read"})).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+			}
+			h := Handler{db}
+
+			// Act
+			err = respond(c, h.CreateAPIKeyHandler(c))
+
+			// Assertions
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.expectedCode, rec.Code)
+				assert.NoError(t, mock.ExpectationsWereMet())
+				if tt.expectedCode == http.StatusCreated {
+					assert.Contains(t, rec.Body.String(), `"key":"exp_`)
+				}
+			}
+		})
+	}
+}
+
+func TestGetAPIKeysU(t *testing.T) {
+	// Arrange
+	rec, c := setupTestServer(http.MethodGet, "/api-keys", bytes.NewBufferString(``))
+	SetPrincipal(c, Principal{User: "Patchara", Role: RoleAdmin, Scopes: AllScopes})
+
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	created := time.Date(2022, 12, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("SELECT (.+) FROM api_keys WHERE owner = \\$1").WithArgs("Patchara").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "prefix", "scopes", "created_at", "revoked_at"}).
+			AddRow(1, "backup script", "exp_0123abcd", pq.Array([]string{"expenses:read"}), created, nil))
+	h := Handler{db}
+
+	// Act
+	err := respond(c, h.GetAPIKeysHandler(c))
+
+	// Assert
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, `[{"id":1,"name":"backup script","prefix":"exp_0123abcd","scopes":["expenses:read"],"created_at":"2022-12-01T00:00:00Z"}]`, strings.TrimSpace(rec.Body.String()))
+	}
+}
+
+func TestRevokeAPIKeyU(t *testing.T) {
+	tests := []struct {
+		name         string
+		affected     int64
+		expectedCode int
+	}{
+		{name: "testSucceed", affected: 1, expectedCode: http.StatusNoContent},
+		{name: "testNotFound", affected: 0, expectedCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			rec, c := setupTestServer(http.MethodDelete, "/api-keys", bytes.NewBufferString(``))
+			c.SetPath("/api-keys/:id")
+			c.SetParamNames("id")
+			c.SetParamValues("1")
+			SetPrincipal(c, Principal{User: "Patchara", Role: RoleAdmin, Scopes: AllScopes})
+
+			db, mock, _ := sqlmock.New()
+			defer db.Close()
+			mock.ExpectExec("UPDATE api_keys SET revoked_at").WithArgs(1, "Patchara").
+				WillReturnResult(sqlmock.NewResult(0, tt.affected))
+			h := Handler{db}
+
+			// Act
+			err := respond(c, h.RevokeAPIKeyHandler(c))
+
+			// Assert
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.expectedCode, rec.Code)
+			}
+		})
+	}
+}
+
+func TestValidateAPIKeyU(t *testing.T) {
+	// Arrange
+	_, c := setupTestServer(http.MethodGet, "/expenses", bytes.NewBufferString(``))
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	mock.ExpectQuery("SELECT (.+) FROM api_keys k JOIN users u").WithArgs(hashKey("exp_valid")).
+		WillReturnRows(sqlmock.NewRows([]string{"owner", "scopes", "role", "ledger"}).AddRow("Patchara", pq.Array([]string{"expenses:read"}), RoleMember, ""))
+	mock.ExpectQuery("SELECT (.+) FROM api_keys k JOIN users u").WithArgs(hashKey("exp_revoked")).
+		WillReturnRows(sqlmock.NewRows([]string{"owner", "scopes", "role", "ledger"}))
+	h := Handler{db}
+
+	// Act
+	valid, err := h.ValidateAPIKey("exp_valid", c)
+	revoked, revokedErr := h.ValidateAPIKey("exp_revoked", c)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.True(t, valid)
+	assert.NoError(t, revokedErr)
+	assert.False(t, revoked)
+	p, ok := PrincipalFrom(c)
+	assert.True(t, ok)
+	assert.Equal(t, Principal{User: "Patchara", Role: RoleMember, Scopes: []string{"expenses:read"}}, p)
+}
+
+func TestRequirePermissionU(t *testing.T) {
+	tests := []struct {
+		name         string
+		principal    Principal
+		action       string
+		expectedCode int
+	}{
+		{name: "testAdminManagesUsers", principal: Principal{Role: RoleAdmin, Scopes: AllScopes}, action: ScopeUsersManage, expectedCode: http.StatusOK},
+		{name: "testMemberWrites", principal: Principal{Role: RoleMember, Scopes: AllScopes}, action: ScopeExpensesWrite, expectedCode: http.StatusOK},
+		{name: "testMemberCannotManageUsers", principal: Principal{Role: RoleMember, Scopes: AllScopes}, action: ScopeUsersManage, expectedCode: http.StatusForbidden},
+		{name: "testViewerReads", principal: Principal{Role: RoleViewer, Scopes: AllScopes}, action: ScopeExpensesRead, expectedCode: http.StatusOK},
+		{name: "testViewerCannotWrite", principal: Principal{Role: RoleViewer, Scopes: AllScopes}, action: ScopeExpensesWrite, expectedCode: http.StatusForbidden},
+		{name: "testKeyScopeLimitsAdmin", principal: Principal{Role: RoleAdmin, Scopes: []string{ScopeExpensesRead}}, action: ScopeExpensesWrite, expectedCode: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			rec, c := setupTestServer(http.MethodGet, "/", bytes.NewBufferString(``))
+			SetPrincipal(c, tt.principal)
+			next := func(c echo.Context) error { return c.NoContent(http.StatusOK) }
+
+			// Act
+			err := respond(c, RequirePermission(tt.action)(next)(c))
+
+			// Assert
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.expectedCode, rec.Code)
+			}
+		})
+	}
+}
+
+func TestOwnerFilterU(t *testing.T) {
+	tests := []struct {
+		name      string
+		principal Principal
+		expected  sql.NullString
+	}{
+		{name: "testAdminSeesAll", principal: Principal{User: "root", Role: RoleAdmin}, expected: sql.NullString{}},
+		{name: "testMemberSeesOwn", principal: Principal{User: "somchai", Role: RoleMember}, expected: sql.NullString{String: "somchai", Valid: true}},
+		{name: "testViewerSeesLedger", principal: Principal{User: "accountant", Role: RoleViewer, Ledger: "somchai"}, expected: sql.NullString{String: "somchai", Valid: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			_, c := setupTestServer(http.MethodGet, "/expenses", bytes.NewBufferString(``))
+			SetPrincipal(c, tt.principal)
+
+			// Act
+			actual := OwnerFilter(c)
+
+			// Assert
+			assert.Equal(t, tt.expected, actual)
+		})
+	}
+}
+
+func TestCreateUserU(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         *bytes.Buffer
+		expectedCode int
+	}{
+		{
+			name:         "testSucceed",
+			body:         bytes.NewBufferString(`{"username": "accountant", "password": "secret", "role": "viewer", "ledger": "somchai"}`),
+			expectedCode: http.StatusCreated,
+		},
+		{
+			name:         "testInvalidRole",
+			body:         bytes.NewBufferString(`{"username": "accountant", "password": "secret", "role": "owner"}`),
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			rec, c := setupTestServer(http.MethodPost, "/users", tt.body)
+
+			db, mock, _ := sqlmock.New()
+			defer db.Close()
+			if tt.name == "testSucceed" {
+				mock.ExpectQuery("INSERT INTO users").WithArgs("accountant", sqlmock.AnyArg(), RoleViewer, "somchai").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(2, time.Now()))
+			}
+			h := Handler{db}
+
+			// Act
+			err := respond(c, h.CreateUserHandler(c))
+
+			// Assert
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.expectedCode, rec.Code)
+				assert.NotContains(t, rec.Body.String(), "secret")
+				assert.NoError(t, mock.ExpectationsWereMet())
+			}
+		})
+	}
+}
+
+func TestValidateBasicAuthU(t *testing.T) {
+	// Arrange
+	_, c := setupTestServer(http.MethodGet, "/expenses", bytes.NewBufferString(``))
+	hash, _ := bcrypt.GenerateFromPassword([]byte("Password"), bcrypt.MinCost)
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	rows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"password_hash", "role", "ledger"}).AddRow(string(hash), RoleAdmin, "")
+	}
+	mock.ExpectQuery("SELECT password_hash, role, ledger FROM users").WithArgs("Patchara").WillReturnRows(rows())
+	mock.ExpectQuery("SELECT password_hash, role, ledger FROM users").WithArgs("Patchara").WillReturnRows(rows())
+	h := Handler{db}
+
+	// Act
+	wrong, wrongErr := h.ValidateBasicAuth("Patchara", "guess", c)
+	valid, err := h.ValidateBasicAuth("Patchara", "Password", c)
+
+	// Assert
+	assert.NoError(t, wrongErr)
+	assert.False(t, wrong)
+	assert.NoError(t, err)
+	assert.True(t, valid)
+	p, _ := PrincipalFrom(c)
+	assert.Equal(t, RoleAdmin, p.Role)
+}
+
+func TestUpdateUserU(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		mock         func(sqlmock.Sqlmock)
+		expectedCode int
+	}{
+		{
+			name: "testRoleAndPassword",
+			body: `{"role": "admin", "password": "s3cret"}`,
+			mock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("UPDATE users SET role").WithArgs(2, RoleAdmin, "", false).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "username", "created_at"}).AddRow(2, "Somchai", time.Now()))
+				mock.ExpectExec("UPDATE users SET password_hash").WithArgs(2, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name: "testPasswordFailureKeepsRole",
+			body: `{"role": "admin", "password": "s3cret"}`,
+			mock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("UPDATE users SET role").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "username", "created_at"}).AddRow(2, "Somchai", time.Now()))
+				mock.ExpectExec("UPDATE users SET password_hash").WillReturnError(sql.ErrConnDone)
+				mock.ExpectRollback()
+			},
+			expectedCode: http.StatusInternalServerError,
+		},
+		{
+			name: "testNotFound",
+			body: `{"role": "member"}`,
+			mock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("UPDATE users SET role").WillReturnError(sql.ErrNoRows)
+				mock.ExpectRollback()
+			},
+			expectedCode: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			db, mock, _ := sqlmock.New()
+			defer db.Close()
+			tt.mock(mock)
+			rec, c := setupTestServer(http.MethodPut, "/users/2", bytes.NewBufferString(tt.body))
+			c.SetParamNames("id")
+			c.SetParamValues("2")
+			h := Handler{DB: db}
+
+			// Act
+			err := respond(c, h.UpdateUserHandler(c))
+
+			// Assert
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedCode, rec.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestBootstrapU(t *testing.T) {
+	tests := []struct {
+		name     string
+		users    int
+		username string
+		password string
+		wantErr  string
+	}{
+		{name: "testCreatesAdmin", username: "admin", password: "s3cret"},
+		{name: "testExistingUsers", users: 1},
+		{name: "testMissingCredentials", wantErr: "no users yet: set BOOTSTRAP_ADMIN_USER and BOOTSTRAP_ADMIN_PASSWORD to create the first admin"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			db, mock, _ := sqlmock.New()
+			defer db.Close()
+			mock.ExpectQuery("SELECT count").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(tt.users))
+			if tt.username != "" {
+				mock.ExpectExec("INSERT INTO users").WithArgs(tt.username, sqlmock.AnyArg(), RoleAdmin, "").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			}
+
+			// Act
+			err := Bootstrap(context.Background(), db, tt.username, tt.password)
+
+			// Assert
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
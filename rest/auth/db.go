@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	createAPIKeySQL = "INSERT INTO api_keys (owner, name, prefix, key_hash, scopes) values ($1, $2, $3, $4, $5) RETURNING id, created_at;"
+	getAPIKeysSQL   = "SELECT id, name, prefix, scopes, created_at, revoked_at FROM api_keys WHERE owner = $1 ORDER BY id"
+	findAPIKeySQL   = "SELECT k.owner, k.scopes, u.role, u.ledger FROM api_keys k JOIN users u ON u.username = k.owner WHERE k.key_hash = $1 AND k.revoked_at IS NULL AND NOT u.disabled"
+	revokeAPIKeySQL = "UPDATE api_keys SET revoked_at = now() WHERE id = $1 AND owner = $2 AND revoked_at IS NULL"
+
+	countUsersSQL    = "SELECT count(*) FROM users"
+	createUserSQL    = "INSERT INTO users (username, password_hash, role, ledger) values ($1, $2, $3, $4) RETURNING id, created_at;"
+	getUsersSQL      = "SELECT id, username, role, ledger, disabled, created_at FROM users ORDER BY id"
+	findUserSQL      = "SELECT password_hash, role, ledger FROM users WHERE username = $1 AND NOT disabled"
+	updateUserSQL    = "UPDATE users SET role = $2, ledger = $3, disabled = $4 WHERE id = $1 RETURNING id, username, created_at"
+	setPasswordSQL   = "UPDATE users SET password_hash = $2 WHERE id = $1"
+	resetPasswordSQL = "UPDATE users SET password_hash = $2 WHERE username = $1"
+	disableUserSQL   = "UPDATE users SET disabled = true WHERE username = $1"
+)
+
+// Bootstrap creates an admin account with the given credentials on an empty
+// users table so a fresh install can sign in. It fails when the table is
+// empty and no credentials are given, rather than start a server nobody can
+// sign in to.
+func Bootstrap(ctx context.Context, db *sql.DB, username, password string) error {
+	var n int
+	if err := db.QueryRowContext(ctx, countUsersSQL).Scan(&n); err != nil {
+		return fmt.Errorf("can't count users: %w", err)
+	}
+	if n > 0 {
+		return nil
+	}
+	if username == "" || password == "" {
+		return errors.New("no users yet: set BOOTSTRAP_ADMIN_USER and BOOTSTRAP_ADMIN_PASSWORD to create the first admin")
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("can't hash bootstrap password: %w", err)
+	}
+	if _, err := db.ExecContext(ctx, createUserSQL, username, string(hash), RoleAdmin, ""); err != nil {
+		return fmt.Errorf("can't create bootstrap admin: %w", err)
+	}
+	return nil
+}
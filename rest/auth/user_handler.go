@@ -0,0 +1,187 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+
+	"github.com/PatcharaKL/assessment/problem"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func (h *Handler) CreateUserHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	u := User{}
+
+	if err := c.Bind(&u); err != nil {
+		return err
+	}
+	u, err := h.CreateUser(ctx, u)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusCreated, u)
+}
+
+// CreateUser stores u with a hash of its password and returns it with the
+// password cleared.
+func (h *Handler) CreateUser(ctx context.Context, u User) (User, error) {
+	if u.Username == "" || u.Password == "" {
+		return u, problem.BadRequest("username and password are required")
+	}
+	if !validRole(u.Role) {
+		return u, problem.BadRequest("invalid role: " + u.Role)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return u, fmt.Errorf("can't hash password: %w", err)
+	}
+
+	if err := h.DB.QueryRowContext(ctx, createUserSQL, u.Username, string(hash), u.Role, u.Ledger).Scan(&u.ID, &u.CreatedAt); err != nil {
+		return u, fmt.Errorf("can't create user: %w", err)
+	}
+
+	u.Password = ""
+	return u, nil
+}
+
+// ResetPassword replaces the password of the named user.
+func (h *Handler) ResetPassword(ctx context.Context, username, password string) error {
+	if password == "" {
+		return problem.BadRequest("password is required")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("can't hash password: %w", err)
+	}
+	return h.updateUser(ctx, resetPasswordSQL, username, string(hash))
+}
+
+// DisableUser stops the named user from signing in, with a password or with
+// any of their API keys. Their expenses are kept.
+func (h *Handler) DisableUser(ctx context.Context, username string) error {
+	return h.updateUser(ctx, disableUserSQL, username)
+}
+
+func (h *Handler) updateUser(ctx context.Context, query, username string, args ...any) error {
+	res, err := h.DB.ExecContext(ctx, query, append([]any{username}, args...)...)
+	if err != nil {
+		return fmt.Errorf("can't update user: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("can't update user: %w", err)
+	}
+	if n == 0 {
+		return problem.NotFound("user " + username + " not found")
+	}
+	return nil
+}
+
+func (h *Handler) GetUsersHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	rows, err := h.DB.QueryContext(ctx, getUsersSQL)
+	if err != nil {
+		return fmt.Errorf("can't query users: %w", err)
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		u := User{}
+		if err := rows.Scan(&u.ID, &u.Username, &u.Role, &u.Ledger, &u.Disabled, &u.CreatedAt); err != nil {
+			return fmt.Errorf("can't scan user: %w", err)
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("can't query users: %w", err)
+	}
+
+	return c.JSON(http.StatusOK, users)
+}
+
+func (h *Handler) UpdateUserHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	id, err := paramID(c)
+	if err != nil {
+		return err
+	}
+
+	u := User{}
+
+	if err := c.Bind(&u); err != nil {
+		return err
+	}
+	if !validRole(u.Role) {
+		return problem.BadRequest("invalid role: " + u.Role)
+	}
+
+	// The password is hashed before the transaction so it isn't held open
+	// for bcrypt.
+	var hash []byte
+	if u.Password != "" {
+		hash, err = bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return fmt.Errorf("can't hash password: %w", err)
+		}
+	}
+
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("can't update user: %w", err)
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, updateUserSQL, id, u.Role, u.Ledger, u.Disabled).Scan(&u.ID, &u.Username, &u.CreatedAt)
+	if err == sql.ErrNoRows {
+		return problem.NotFound("user not found")
+	}
+	if err != nil {
+		return fmt.Errorf("can't update user: %w", err)
+	}
+	if hash != nil {
+		if _, err := tx.ExecContext(ctx, setPasswordSQL, id, string(hash)); err != nil {
+			return fmt.Errorf("can't update password: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("can't update user: %w", err)
+	}
+
+	u.Password = ""
+	return c.JSON(http.StatusOK, u)
+}
+
+// AuthenticateBasic checks the credentials against the users table and
+// grants every scope the user's role allows.
+func (h *Handler) AuthenticateBasic(ctx context.Context, username, password string) (Principal, bool, error) {
+	var hash string
+	p := Principal{User: username, Scopes: AllScopes}
+	err := h.DB.QueryRowContext(ctx, findUserSQL, username).Scan(&hash, &p.Role, &p.Ledger)
+	if err == sql.ErrNoRows {
+		return Principal{}, false, nil
+	}
+	if err != nil {
+		return Principal{}, false, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return Principal{}, false, nil
+	}
+	return p, true, nil
+}
+
+// ValidateBasicAuth is the validator for echo's BasicAuth middleware. It
+// stores the principal of valid credentials on the request.
+func (h *Handler) ValidateBasicAuth(username, password string, c echo.Context) (bool, error) {
+	p, ok, err := h.AuthenticateBasic(c.Request().Context(), username, password)
+	if !ok || err != nil {
+		return false, err
+	}
+
+	SetPrincipal(c, p)
+	return true, nil
+}
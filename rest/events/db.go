@@ -0,0 +1,8 @@
+package events
+
+const (
+	lastEventSQL = "SELECT coalesce(max(id), 0) FROM outbox"
+	// eventsSQL reads the events after an id. The payload is the expense as
+	// JSON, which has no newlines, so it fits on one data line.
+	eventsSQL = "SELECT id, type, payload FROM outbox WHERE id > $1 AND ($2::text IS NULL OR owner = $2) ORDER BY id LIMIT $3"
+)
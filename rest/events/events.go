@@ -0,0 +1,215 @@
+// Package events streams expense changes to clients over Server-Sent Events.
+// Every write appends its event to the outbox in the same transaction, and
+// appends wait for each other until commit, so the outbox id orders the
+// changes as they are committed and a client that reconnects with the last
+// id it saw, in the Last-Event-ID header, is sent everything it missed.
+package events
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/PatcharaKL/assessment/problem"
+	"github.com/PatcharaKL/assessment/rest/auth"
+	"github.com/PatcharaKL/assessment/rest/expenses"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	StreamPath = "/events/stream"
+
+	// HeaderLastEventID is sent by EventSource when it reconnects.
+	HeaderLastEventID   = "Last-Event-ID"
+	MIMETextEventStream = "text/event-stream"
+
+	// retry is how long clients wait before reconnecting, in milliseconds.
+	retry = 3000
+)
+
+// Stream serves the outbox as an event stream. It is an expenses.Publisher:
+// Publish wakes the open streams to send the change. Streams also look for
+// changes every Interval, in case they weren't told.
+type Stream struct {
+	DB *sql.DB
+	// Interval is how often a stream looks for new events.
+	Interval time.Duration
+	// Heartbeat is how often an idle stream sends a comment, so proxies don't
+	// close it.
+	Heartbeat time.Duration
+	BatchSize int
+
+	mu      sync.Mutex
+	streams map[chan struct{}]struct{}
+	closed  chan struct{}
+	once    sync.Once
+}
+
+func NewStream(db *sql.DB) *Stream {
+	return &Stream{
+		DB:        db,
+		Interval:  30 * time.Second,
+		Heartbeat: 15 * time.Second,
+		BatchSize: 100,
+		streams:   map[chan struct{}]struct{}{},
+		closed:    make(chan struct{}),
+	}
+}
+
+// Publish wakes every open stream to send the new event.
+func (s *Stream) Publish(ctx context.Context, e expenses.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for wake := range s.streams {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Resync wakes every open stream, to send any changes it wasn't told about.
+func (s *Stream) Resync(ctx context.Context) {
+	s.Publish(ctx, expenses.Event{})
+}
+
+// Close ends the open streams, so the server can shut down without waiting
+// for clients to hang up. Clients reconnect, to another replica, from where
+// they were.
+func (s *Stream) Close() {
+	s.once.Do(func() { close(s.closed) })
+}
+
+func (s *Stream) subscribe() chan struct{} {
+	wake := make(chan struct{}, 1)
+	s.mu.Lock()
+	s.streams[wake] = struct{}{}
+	s.mu.Unlock()
+	return wake
+}
+
+func (s *Stream) unsubscribe(wake chan struct{}) {
+	s.mu.Lock()
+	delete(s.streams, wake)
+	s.mu.Unlock()
+}
+
+// StreamHandler sends the events of the expenses the principal may read, in
+// order, until the client hangs up. Without a Last-Event-ID it starts with
+// the next change.
+func (s *Stream) StreamHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	owner := auth.OwnerFilter(c)
+
+	last, err := s.lastEventID(c)
+	if err != nil {
+		return err
+	}
+
+	wake := s.subscribe()
+	defer s.unsubscribe(wake)
+
+	// A stream outlives the server's write timeout.
+	_ = http.NewResponseController(c.Response().Writer).SetWriteDeadline(time.Time{})
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, MIMETextEventStream)
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+	if _, err := fmt.Fprintf(res, "retry: %d\n\n", retry); err != nil {
+		return nil
+	}
+	res.Flush()
+
+	poll := time.NewTicker(s.Interval)
+	defer poll.Stop()
+	heartbeat := time.NewTicker(s.Heartbeat)
+	defer heartbeat.Stop()
+	for {
+		n, err := s.send(ctx, res, owner, &last)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, errClientGone) {
+				return nil
+			}
+			return err
+		}
+		if n > 0 {
+			heartbeat.Reset(s.Heartbeat)
+		}
+		if n == s.BatchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.closed:
+			return nil
+		case <-wake:
+		case <-poll.C:
+		case <-heartbeat.C:
+			if _, err := io.WriteString(res, ": keepalive\n\n"); err != nil {
+				return nil
+			}
+			res.Flush()
+		}
+	}
+}
+
+// lastEventID is where the stream starts: after the client's Last-Event-ID,
+// or after the latest event when it has none.
+func (s *Stream) lastEventID(c echo.Context) (int64, error) {
+	v := c.Request().Header.Get(HeaderLastEventID)
+	if v == "" {
+		var last int64
+		if err := s.DB.QueryRowContext(c.Request().Context(), lastEventSQL).Scan(&last); err != nil {
+			return 0, fmt.Errorf("can't get last event: %w", err)
+		}
+		return last, nil
+	}
+	last, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || last < 0 {
+		return 0, problem.BadRequest(fmt.Sprintf("invalid %s %q", HeaderLastEventID, v))
+	}
+	return last, nil
+}
+
+var errClientGone = errors.New("client went away")
+
+// send writes the events after last, up to BatchSize of them, and moves last
+// past them. It returns how many it sent.
+func (s *Stream) send(ctx context.Context, w *echo.Response, owner sql.NullString, last *int64) (int, error) {
+	rows, err := s.DB.QueryContext(ctx, eventsSQL, *last, owner, s.BatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("can't query events: %w", err)
+	}
+	defer rows.Close()
+
+	n := 0
+	for rows.Next() {
+		var id int64
+		var typ string
+		var payload []byte
+		if err := rows.Scan(&id, &typ, &payload); err != nil {
+			return n, fmt.Errorf("can't scan event: %w", err)
+		}
+		if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", id, typ, payload); err != nil {
+			return n, errClientGone
+		}
+		*last = id
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return n, fmt.Errorf("can't query events: %w", err)
+	}
+	if n > 0 {
+		w.Flush()
+	}
+	return n, nil
+}
@@ -0,0 +1,188 @@
+//go:build unit
+// +build unit
+
+package events
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/PatcharaKL/assessment/problem"
+	"github.com/PatcharaKL/assessment/rest/auth"
+	"github.com/PatcharaKL/assessment/rest/expenses"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// setupStream serves a Stream to a member over a real connection, so the
+// events arrive as they are flushed. Streams only look for events when they
+// are woken unless the test shortens Interval.
+func setupStream(t *testing.T) (*Stream, *httptest.Server, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	s := NewStream(db)
+	s.Interval = time.Hour
+	s.Heartbeat = time.Hour
+	e := echo.New()
+	e.HTTPErrorHandler = problem.HTTPErrorHandler
+	e.GET(StreamPath, s.StreamHandler, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			auth.SetPrincipal(c, auth.Principal{User: "Patchara", Role: auth.RoleMember, Scopes: auth.AllScopes})
+			return next(c)
+		}
+	})
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	t.Cleanup(s.Close)
+	return s, srv, mock
+}
+
+// open starts a stream and returns its lines as they arrive.
+func open(t *testing.T, srv *httptest.Server, lastEventID string) (*http.Response, <-chan string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+StreamPath, nil)
+	if lastEventID != "" {
+		req.Header.Set(HeaderLastEventID, lastEventID)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { res.Body.Close() })
+
+	lines := make(chan string, 100)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	return res, lines
+}
+
+// next returns the lines up to the next blank one, which ends a message.
+func next(t *testing.T, lines <-chan string) []string {
+	t.Helper()
+	msg := []string{}
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatalf("stream ended after %q", msg)
+			}
+			if line == "" {
+				return msg
+			}
+			msg = append(msg, line)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("no message after %q", msg)
+		}
+	}
+}
+
+func eventRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "type", "payload"})
+}
+
+func TestStreamResumesAfterLastEventIDU(t *testing.T) {
+	// Arrange
+	_, srv, mock := setupStream(t)
+	mock.ExpectQuery("SELECT id, type, payload FROM outbox").WithArgs(4, "Patchara", 100).
+		WillReturnRows(eventRows().
+			AddRow(5, expenses.EventCreated, `{"id":1,"title":"apple smoothie","amount":89,"note":"","tags":["beverage"]}`).
+			AddRow(6, expenses.EventUpdated, `{"id":1,"title":"apple smoothie","amount":79,"note":"","tags":["beverage"]}`))
+
+	// Act
+	res, lines := open(t, srv, "4")
+
+	// Assert
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, MIMETextEventStream, res.Header.Get(echo.HeaderContentType))
+	assert.Equal(t, []string{"retry: 3000"}, next(t, lines))
+	assert.Equal(t, []string{"id: 5", "event: expense.created", `data: {"id":1,"title":"apple smoothie","amount":89,"note":"","tags":["beverage"]}`}, next(t, lines))
+	assert.Equal(t, []string{"id: 6", "event: expense.updated", `data: {"id":1,"title":"apple smoothie","amount":79,"note":"","tags":["beverage"]}`}, next(t, lines))
+}
+
+func TestStreamStartsWithNextChangeU(t *testing.T) {
+	// Arrange
+	s, srv, mock := setupStream(t)
+	mock.ExpectQuery("SELECT coalesce").WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(7))
+	mock.ExpectQuery("SELECT id, type, payload FROM outbox").WithArgs(7, "Patchara", 100).WillReturnRows(eventRows())
+	mock.ExpectQuery("SELECT id, type, payload FROM outbox").WithArgs(7, "Patchara", 100).
+		WillReturnRows(eventRows().AddRow(8, expenses.EventCreated, `{"id":3}`))
+	_, lines := open(t, srv, "")
+	next(t, lines)
+
+	// Act
+	assert.Eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return len(s.streams) == 1
+	}, time.Second, 10*time.Millisecond)
+	s.Publish(context.Background(), expenses.Event{ID: 8, Type: expenses.EventCreated})
+
+	// Assert
+	assert.Equal(t, []string{"id: 8", "event: expense.created", `data: {"id":3}`}, next(t, lines))
+}
+
+func TestStreamSendsKeepaliveU(t *testing.T) {
+	// Arrange
+	s, srv, mock := setupStream(t)
+	s.Heartbeat = 10 * time.Millisecond
+	mock.ExpectQuery("SELECT id, type, payload FROM outbox").WillReturnRows(eventRows())
+
+	// Act
+	_, lines := open(t, srv, "0")
+	next(t, lines)
+
+	// Assert
+	assert.Equal(t, []string{": keepalive"}, next(t, lines))
+}
+
+func TestStreamEndsOnCloseU(t *testing.T) {
+	// Arrange
+	s, srv, mock := setupStream(t)
+	mock.ExpectQuery("SELECT id, type, payload FROM outbox").WillReturnRows(eventRows())
+	_, lines := open(t, srv, "0")
+	next(t, lines)
+
+	// Act
+	s.Close()
+
+	// Assert
+	select {
+	case _, ok := <-lines:
+		assert.False(t, ok, "the stream should end")
+	case <-time.After(2 * time.Second):
+		t.Fatal("the stream is still open")
+	}
+}
+
+func TestStreamRejectsInvalidLastEventIDU(t *testing.T) {
+	// Arrange
+	_, srv, _ := setupStream(t)
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+StreamPath, nil)
+	req.Header.Set(HeaderLastEventID, "abc")
+
+	// Act
+	res, err := http.DefaultClient.Do(req)
+
+	// Assert
+	if assert.NoError(t, err) {
+		defer res.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+		assert.True(t, strings.HasPrefix(res.Header.Get(echo.HeaderContentType), problem.MIMEApplicationProblemJSON))
+	}
+}
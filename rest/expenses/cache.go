@@ -0,0 +1,168 @@
+package expenses
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/PatcharaKL/assessment/cache"
+	"github.com/PatcharaKL/assessment/metrics"
+	"github.com/PatcharaKL/assessment/rest/auth"
+	"golang.org/x/sync/singleflight"
+)
+
+// Cache keeps the results of expense reads so repeated reads skip the
+// database. Results are kept per scope, the expenses a principal may see:
+// an owner's, or every one for admins. Each scope has a generation, part of
+// every key in it, and a write to an owner's expenses drops the generation
+// of their scope and of the admins'. The next read starts a new one, so
+// results read before the write, even those stored after it, are never
+// found again; they are left to expire or be evicted.
+//
+// Concurrent reads that miss the same key wait for a single query.
+type Cache struct {
+	Backend cache.Backend
+	// TTL bounds how long a result is kept, and so how stale it can be if
+	// an invalidation is lost.
+	TTL    time.Duration
+	Logger *slog.Logger
+
+	group singleflight.Group
+}
+
+func NewCache(b cache.Backend, ttl time.Duration, logger *slog.Logger) *Cache {
+	return &Cache{Backend: b, TTL: ttl, Logger: logger}
+}
+
+const allScope = "all"
+
+func scope(owner sql.NullString) string {
+	if !owner.Valid {
+		return allScope
+	}
+	return "owner=" + url.QueryEscape(owner.String)
+}
+
+func generationKey(scope string) string {
+	return "expenses:gen:" + scope
+}
+
+// generation returns the current generation of scope, starting a new one
+// if it was dropped.
+func (c *Cache) generation(ctx context.Context, scope string) (string, error) {
+	key := generationKey(scope)
+	gen, ok, err := c.Backend.Get(ctx, key)
+	if err != nil || ok {
+		return string(gen), err
+	}
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	gen = []byte(hex.EncodeToString(b))
+	return string(gen), c.Backend.Set(ctx, key, gen, 0)
+}
+
+// Invalidate drops what is cached of owner's expenses.
+func (c *Cache) Invalidate(ctx context.Context, owner string) {
+	keys := []string{generationKey(scope(sql.NullString{String: owner, Valid: owner != ""})), generationKey(allScope)}
+	if err := c.Backend.Delete(ctx, keys...); err != nil {
+		c.Logger.ErrorContext(ctx, "can't invalidate cached expenses", "owner", owner, "error", err)
+	}
+}
+
+// Publish invalidates what the change makes stale. Subscribed to the
+// notifier, it keeps the caches of every replica fresh.
+func (c *Cache) Publish(ctx context.Context, e Event) {
+	c.Invalidate(ctx, e.Owner)
+}
+
+// Resync drops everything when changes may have been missed. A backend
+// shared by the replicas is invalidated by the replica that made the change,
+// so only one private to this replica is purged.
+func (c *Cache) Resync(ctx context.Context) {
+	if p, ok := c.Backend.(cache.Purger); ok {
+		p.Purge()
+	}
+}
+
+// cached returns what is cached for p under what, or runs load and caches
+// its result. The cache failing only costs the query: load runs anyway.
+func cached[T any](ctx context.Context, c *Cache, p auth.Principal, what string, load func(context.Context) (T, error)) (T, error) {
+	var zero T
+	if c == nil {
+		return load(ctx)
+	}
+	gen, err := c.generation(ctx, scope(p.OwnerFilter()))
+	if err != nil {
+		c.Logger.WarnContext(ctx, "can't read expense cache", "error", err)
+		return load(ctx)
+	}
+	key := "expenses:" + scope(p.OwnerFilter()) + ":" + gen + ":" + what
+
+	b, ok, err := c.Backend.Get(ctx, key)
+	if err != nil {
+		c.Logger.WarnContext(ctx, "can't read expense cache", "key", key, "error", err)
+	}
+	if ok {
+		var v T
+		if err := json.Unmarshal(b, &v); err == nil {
+			metrics.CacheLookup("expenses", true)
+			return v, nil
+		}
+	}
+	metrics.CacheLookup("expenses", false)
+
+	// The query is shared by every request waiting for it, so it doesn't
+	// stop when the first of them goes away; the statement timeout bounds it.
+	res := c.group.DoChan(key, func() (any, error) {
+		ctx := context.WithoutCancel(ctx)
+		v, err := load(ctx)
+		if err != nil {
+			return v, err
+		}
+		if b, err := json.Marshal(v); err == nil {
+			if err := c.Backend.Set(ctx, key, b, c.TTL); err != nil {
+				c.Logger.WarnContext(ctx, "can't write expense cache", "key", key, "error", err)
+			}
+		}
+		return v, nil
+	})
+	select {
+	case r := <-res:
+		if r.Err != nil {
+			return zero, r.Err
+		}
+		return r.Val.(T), nil
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	}
+}
+
+// key returns the filter and page as a query string with the tags sorted and
+// repeats removed, so listings that match the same expenses share a key.
+func (f Filter) key(page Page) string {
+	q := url.Values{}
+	tags := slices.Clone(f.Tags)
+	slices.Sort(tags)
+	q["tag"] = slices.Compact(tags)
+	if f.MinAmount != nil {
+		q.Set("min", strconv.FormatFloat(*f.MinAmount, 'g', -1, 64))
+	}
+	if f.MaxAmount != nil {
+		q.Set("max", strconv.FormatFloat(*f.MaxAmount, 'g', -1, 64))
+	}
+	if f.Search != "" {
+		q.Set("q", f.Search)
+	}
+	q.Set("after", strconv.Itoa(page.After))
+	q.Set("limit", strconv.Itoa(page.Limit))
+	return q.Encode()
+}
@@ -0,0 +1,230 @@
+//go:build unit
+// +build unit
+
+package expenses
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/PatcharaKL/assessment/cache"
+	"github.com/PatcharaKL/assessment/rest/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	member = auth.Principal{User: "Patchara", Role: auth.RoleMember, Scopes: auth.AllScopes}
+	admin  = auth.Principal{User: "admin", Role: auth.RoleAdmin, Scopes: auth.AllScopes}
+)
+
+func setupCachedService(t *testing.T) (*Service, *cache.LRU, sqlmock.Sqlmock, *bytes.Buffer) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	var logs bytes.Buffer
+	lru := cache.NewLRU(100)
+	s := &Service{DB: db, Cache: NewCache(lru, time.Minute, slog.New(slog.NewTextHandler(&logs, nil)))}
+	return s, lru, mock, &logs
+}
+
+func expenseRows(titles ...string) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags", "version", "updated_at"})
+	for i, title := range titles {
+		rows.AddRow(i+1, title, 89, "", "{beverage}", 1, time.Now())
+	}
+	return rows
+}
+
+func expectGet(mock sqlmock.Sqlmock, owner any, title string) {
+	mock.ExpectQuery(regexp.QuoteMeta(getExpenseSQL)).WithArgs(1, owner).WillReturnRows(expenseRows(title))
+}
+
+func expectUpdate(mock sqlmock.Sqlmock, owner string) {
+	mock.ExpectBegin()
+	mock.ExpectQuery("UPDATE expenses").WillReturnRows(sqlmock.NewRows([]string{"owner"}).AddRow(owner))
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("INSERT INTO outbox").WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+	mock.ExpectCommit()
+}
+
+func TestCacheServesRepeatedReadsU(t *testing.T) {
+	// Arrange
+	s, _, mock, _ := setupCachedService(t)
+	ctx := context.Background()
+	expectGet(mock, "Patchara", "apple smoothie")
+	mock.ExpectQuery(regexp.QuoteMeta(getExpensesSQL)).WithArgs("Patchara").WillReturnRows(expenseRows("apple smoothie", "iced tea"))
+
+	// Act
+	first, firstErr := s.Get(ctx, member, 1)
+	second, secondErr := s.Get(ctx, member, 1)
+	list, _ := s.List(ctx, member)
+	cachedList, listErr := s.List(ctx, member)
+
+	// Assert
+	assert.NoError(t, firstErr)
+	assert.NoError(t, secondErr)
+	assert.NoError(t, listErr)
+	assert.Equal(t, first, second)
+	assert.Equal(t, Expenses{ID: 1, Title: "apple smoothie", Amount: 89, Note: "", Tags: []string{"beverage"}}, second)
+	assert.Equal(t, list, cachedList)
+	assert.NoError(t, mock.ExpectationsWereMet(), "each read queries once")
+}
+
+func TestCacheKeepsScopesApartU(t *testing.T) {
+	// Arrange
+	s, _, mock, _ := setupCachedService(t)
+	ctx := context.Background()
+	expectGet(mock, "Patchara", "apple smoothie")
+	expectGet(mock, nil, "apple smoothie")
+	expectGet(mock, "Somchai", "apple smoothie")
+
+	// Act
+	s.Get(ctx, member, 1)
+	s.Get(ctx, admin, 1)
+	s.Get(ctx, auth.Principal{User: "Somchai", Role: auth.RoleMember}, 1)
+
+	// Assert
+	assert.NoError(t, mock.ExpectationsWereMet(), "principals who see different expenses don't share results")
+}
+
+func TestCacheInvalidatesOnWriteU(t *testing.T) {
+	// Arrange
+	s, _, mock, _ := setupCachedService(t)
+	ctx := context.Background()
+	expectGet(mock, "Patchara", "apple smoothie")
+	expectGet(mock, nil, "apple smoothie")
+	expectUpdate(mock, "Patchara")
+	expectGet(mock, "Patchara", "iced tea")
+	expectGet(mock, nil, "iced tea")
+	s.Get(ctx, member, 1)
+	s.Get(ctx, admin, 1)
+
+	// Act
+	_, updateErr := s.Update(ctx, member, 1, Expenses{Title: "iced tea", Amount: 89, Tags: []string{"beverage"}})
+	own, _ := s.Get(ctx, member, 1)
+	all, _ := s.Get(ctx, admin, 1)
+
+	// Assert
+	assert.NoError(t, updateErr)
+	assert.Equal(t, "iced tea", own.Title, "the writer reads its own write")
+	assert.Equal(t, "iced tea", all.Title, "the owner's write invalidates the admins' results")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCacheInvalidatesOnChangesFromOtherReplicasU(t *testing.T) {
+	// Arrange
+	s, lru, mock, _ := setupCachedService(t)
+	ctx := context.Background()
+	expectGet(mock, "Patchara", "apple smoothie")
+	expectGet(mock, "Patchara", "iced tea")
+	expectGet(mock, "Patchara", "green tea")
+	s.Get(ctx, member, 1)
+
+	// Act
+	s.Cache.Publish(ctx, Event{Type: EventUpdated, Owner: "Patchara", Expense: Expenses{ID: 1}})
+	changed, _ := s.Get(ctx, member, 1)
+	s.Cache.Resync(ctx)
+	resynced, _ := s.Get(ctx, member, 1)
+
+	// Assert
+	assert.Equal(t, "iced tea", changed.Title)
+	assert.Equal(t, "green tea", resynced.Title)
+	assert.Equal(t, 2, lru.Len(), "resync purges the memory cache")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCacheNormalizesQueriesU(t *testing.T) {
+	// Arrange
+	s, _, mock, _ := setupCachedService(t)
+	ctx := context.Background()
+	minAmount := 50.0
+	mock.ExpectQuery(regexp.QuoteMeta(findExpensesSQL)).WillReturnRows(sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags"}))
+	mock.ExpectQuery(regexp.QuoteMeta(findExpensesSQL)).WillReturnRows(sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags"}))
+
+	// Act
+	s.Find(ctx, member, Filter{Tags: []string{"food", "beverage"}, MinAmount: &minAmount}, Page{Limit: 10})
+	s.Find(ctx, member, Filter{Tags: []string{"beverage", "food", "beverage"}, MinAmount: &minAmount}, Page{Limit: 10})
+	s.Find(ctx, member, Filter{Tags: []string{"beverage", "food"}, MinAmount: &minAmount}, Page{After: 1, Limit: 10})
+
+	// Assert
+	assert.NoError(t, mock.ExpectationsWereMet(), "tags match in any order, but pages differ")
+}
+
+func TestCacheSharesConcurrentMissesU(t *testing.T) {
+	// Arrange
+	s, _, mock, _ := setupCachedService(t)
+	mock.ExpectQuery(regexp.QuoteMeta(getExpenseSQL)).WithArgs(1, "Patchara").
+		WillDelayFor(50 * time.Millisecond).WillReturnRows(expenseRows("apple smoothie"))
+
+	// Act
+	var wg sync.WaitGroup
+	errs := make([]error, 10)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = s.Get(context.Background(), member, 1)
+		}(i)
+	}
+	wg.Wait()
+
+	// Assert
+	for _, err := range errs {
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet(), "only one of the reads queries")
+}
+
+func TestCacheDoesNotKeepErrorsU(t *testing.T) {
+	// Arrange
+	s, _, mock, _ := setupCachedService(t)
+	ctx := context.Background()
+	mock.ExpectQuery(regexp.QuoteMeta(getExpenseSQL)).WillReturnRows(expenseRows())
+	expectGet(mock, "Patchara", "apple smoothie")
+
+	// Act
+	_, notFound := s.Get(ctx, member, 1)
+	e, err := s.Get(ctx, member, 1)
+
+	// Assert
+	assert.Error(t, notFound)
+	assert.NoError(t, err)
+	assert.Equal(t, "apple smoothie", e.Title)
+}
+
+// failingBackend is a cache that is down.
+type failingBackend struct{}
+
+func (failingBackend) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	return nil, false, errors.New("connection refused")
+}
+func (failingBackend) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return errors.New("connection refused")
+}
+func (failingBackend) Delete(ctx context.Context, keys ...string) error {
+	return errors.New("connection refused")
+}
+
+func TestCacheFailureFallsBackToDatabaseU(t *testing.T) {
+	// Arrange
+	s, _, mock, logs := setupCachedService(t)
+	s.Cache.Backend = failingBackend{}
+	expectGet(mock, "Patchara", "apple smoothie")
+
+	// Act
+	e, err := s.Get(context.Background(), member, 1)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, "apple smoothie", e.Title)
+	assert.Contains(t, logs.String(), "can't read expense cache")
+}
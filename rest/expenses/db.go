@@ -1,40 +1,50 @@
 package expenses
 
 import (
+	"context"
 	"database/sql"
-	"log"
-	"os"
 
-	_ "github.com/lib/pq"
+	"github.com/PatcharaKL/assessment/sqlstmt"
 )
 
 const (
-	createExpensesTableSQL = `
-	CREATE TABLE IF NOT EXISTS expenses (
-		id SERIAL PRIMARY KEY,
-		title TEXT,
-		amount FLOAT,
-		note TEXT,
-		tags TEXT[]
-	);
-	`
-	createExpenseSQL = "INSERT INTO expenses (title, amount, note, tags) values ($1, $2, $3, $4) RETURNING id;"
-	getExpensesSQL   = "SELECT * FROM expenses"
-	getExpenseSQL    = "SELECT * FROM expenses WHERE id = $1"
-	updateExpenseSQL = "UPDATE expenses SET title = $2, amount = $3, note = $4, tags = $5 WHERE id = $1"
+	createExpenseSQL = "INSERT INTO expenses (title, amount, note, tags, owner) values ($1, $2, $3, $4, $5) RETURNING id;"
+	getExpensesSQL   = "SELECT id, title, amount, note, tags, version, updated_at FROM expenses WHERE $1::text IS NULL OR owner = $1"
+	getExpenseSQL    = "SELECT id, title, amount, note, tags, version, updated_at FROM expenses WHERE id = $1 AND ($2::text IS NULL OR owner = $2)"
+	lockExpenseSQL   = "SELECT version, updated_at FROM expenses WHERE id = $1 AND ($2::text IS NULL OR owner = $2) FOR UPDATE"
+	updateExpenseSQL = "UPDATE expenses SET title = $2, amount = $3, note = $4, tags = $5, version = version + 1, updated_at = now() WHERE id = $1 AND ($6::text IS NULL OR owner = $6) RETURNING owner"
+	assignUnownedSQL = "UPDATE expenses SET owner = $1, version = version + 1, updated_at = now() WHERE owner IS NULL"
+	appendEventSQL   = "INSERT INTO outbox (type, owner, payload) values ($1, $2, $3) RETURNING id, created_at"
+	// lockOutboxSQL makes other writers wait to append until the transaction
+	// ends. Outbox ids are taken at insert, not at commit, so without it a
+	// reader could see id 11 committed before id 10 and skip 10 for good.
+	lockOutboxSQL = "SELECT pg_advisory_xact_lock(hashtext('outbox'))"
 )
 
-func InitDB() *sql.DB {
-	db, err := sql.Open("postgres", os.Getenv("DATABASE_STR"))
-	if err != nil {
-		log.Fatal("Connect to database error", err)
-	}
+// filterSQL narrows a query by Filter. Each NULL or empty argument matches
+// every row, so one statement serves every combination.
+const filterSQL = " WHERE ($1::text IS NULL OR owner = $1)" +
+	" AND ($2::text[] IS NULL OR tags @> $2)" +
+	" AND ($3::float8 IS NULL OR amount >= $3)" +
+	" AND ($4::float8 IS NULL OR amount <= $4)" +
+	" AND ($5::text = '' OR strpos(lower(title), lower($5)) > 0)"
 
-	_, err = db.Exec(createExpensesTableSQL)
+const (
+	findExpensesSQL = "SELECT id, title, amount, note, tags FROM expenses" + filterSQL + " AND id > $6 ORDER BY id LIMIT $7"
+	totalsSQL       = "SELECT count(*), coalesce(sum(amount), 0) FROM expenses" + filterSQL
+	tagTotalsSQL    = "SELECT tag, count(*), sum(amount) FROM expenses, unnest(tags) AS tag WHERE ($1::text IS NULL OR owner = $1) AND ($2::text[] IS NULL OR tag = ANY($2)) GROUP BY tag ORDER BY tag"
+	byTagsSQL       = "SELECT tag, id, title, amount, note, tags FROM (SELECT tag, id, title, amount, note, tags, row_number() OVER (PARTITION BY tag ORDER BY id) AS n FROM expenses, unnest(tags) AS tag WHERE ($1::text IS NULL OR owner = $1) AND tag = ANY($2)) t WHERE n <= $3 ORDER BY tag, id"
+)
 
-	if err != nil {
-		log.Fatal("can't create table", err)
-	}
+// queries are the statements PrepareStatements prepares: every query the
+// service runs.
+var queries = []string{
+	createExpenseSQL, getExpensesSQL, getExpenseSQL, lockExpenseSQL, updateExpenseSQL, assignUnownedSQL, lockOutboxSQL, appendEventSQL,
+	findExpensesSQL, totalsSQL, tagTotalsSQL, byTagsSQL,
+}
 
-	return db
+// PrepareStatements prepares the service's queries once, for the Stmts of a
+// Service. The server closes them when it stops.
+func PrepareStatements(ctx context.Context, db *sql.DB) (*sqlstmt.Registry, error) {
+	return sqlstmt.Prepare(ctx, db, queries...)
 }
@@ -0,0 +1,122 @@
+package expenses
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/PatcharaKL/assessment/sqlstmt"
+)
+
+const (
+	EventCreated = "expense.created"
+	EventUpdated = "expense.updated"
+)
+
+// EventTypes lists every event the service publishes. There is no delete
+// event: no API deletes expenses.
+var EventTypes = []string{EventCreated, EventUpdated}
+
+// Event describes a change made through the service. ID is its position in
+// the outbox, which orders every change. Owner is the user the expense
+// belongs to, who may differ from the user who changed it.
+type Event struct {
+	ID      int64
+	Type    string
+	Owner   string
+	Expense Expenses
+	Time    time.Time
+}
+
+// Publisher is told about every change after it has been committed.
+// Publish must not fail the change, so it reports its own errors.
+type Publisher interface {
+	Publish(ctx context.Context, e Event)
+}
+
+// Recorder is told about every change inside the transaction that makes it,
+// with tx, so that what it stores is committed or rolled back along with the
+// change. An error fails the change.
+type Recorder interface {
+	Record(ctx context.Context, tx sqlstmt.Querier, e Event) error
+}
+
+// Recorders tells each of its recorders in turn and stops at the first
+// error.
+type Recorders []Recorder
+
+func (rs Recorders) Record(ctx context.Context, tx sqlstmt.Querier, e Event) error {
+	for _, r := range rs {
+		if err := r.Record(ctx, tx, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Publishers tells each of its publishers in turn.
+type Publishers []Publisher
+
+func (ps Publishers) Publish(ctx context.Context, e Event) {
+	for _, p := range ps {
+		p.Publish(ctx, e)
+	}
+}
+
+// appendEvent adds the change to the outbox, and tells the recorder, in the
+// transaction that makes it, so either all are stored or none is. Appends
+// are serialized until commit, so the outbox ids are in commit order.
+func (s *Service) appendEvent(ctx context.Context, tx sqlstmt.Querier, typ, owner string, e Expenses) (Event, error) {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return Event{}, err
+	}
+	ev := Event{Type: typ, Owner: owner, Expense: e}
+	if _, err := tx.ExecContext(ctx, lockOutboxSQL); err != nil {
+		return Event{}, fmt.Errorf("can't lock outbox: %w", err)
+	}
+	if err := tx.QueryRowContext(ctx, appendEventSQL, typ, sql.NullString{String: owner, Valid: owner != ""}, payload).Scan(&ev.ID, &ev.Time); err != nil {
+		return Event{}, fmt.Errorf("can't append %s event: %w", typ, err)
+	}
+	if s.Recorder != nil {
+		if err := s.Recorder.Record(ctx, tx, ev); err != nil {
+			return Event{}, err
+		}
+	}
+	return ev, nil
+}
+
+// publish drops what the change makes stale from the cache before telling
+// the publishers, so the writer reads its own write.
+func (s *Service) publish(ctx context.Context, e Event) {
+	if s.Cache != nil {
+		s.Cache.Invalidate(ctx, e.Owner)
+	}
+	if s.Events != nil {
+		s.Events.Publish(ctx, e)
+	}
+}
+
+// inTx runs fn in a transaction and commits it when fn succeeds. fn runs its
+// queries on tx, which uses the prepared statements when there are some.
+func (s *Service) inTx(ctx context.Context, fn func(tx sqlstmt.Querier) error) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("can't begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var q sqlstmt.Querier = tx
+	if s.Stmts != nil {
+		q = s.Stmts.Tx(tx)
+	}
+	if err := fn(q); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("can't commit transaction: %w", err)
+	}
+	return nil
+}
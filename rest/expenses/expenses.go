@@ -1,23 +1,35 @@
 package expenses
 
-import "database/sql"
+import (
+	"database/sql"
+	"strconv"
 
+	"github.com/PatcharaKL/assessment/problem"
+	"github.com/labstack/echo/v4"
+)
+
+// Expenses is validated with the validation package before it is stored.
 type Expenses struct {
 	ID     int      `json:"id"`
-	Title  string   `json:"title"`
-	Amount float64  `json:"amount"`
-	Note   string   `json:"note"`
-	Tags   []string `json:"tags"`
+	Title  string   `json:"title" validate:"required,max=200"`
+	Amount float64  `json:"amount" validate:"gt=0"`
+	Note   string   `json:"note" validate:"max=1000"`
+	Tags   []string `json:"tags" validate:"max=20,dive,max=32,tag"`
 }
 
+// Handler serves the REST API of Service.
 type Handler struct {
-	DB *sql.DB
+	Service *Service
 }
 
 func NewApplication(db *sql.DB) *Handler {
-	return &Handler{db}
+	return &Handler{Service: NewService(db)}
 }
 
-type Err struct {
-	Message string `json:"message"`
+func expenseID(c echo.Context) (int, error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		return 0, problem.InvalidID("id", c.Param("id"))
+	}
+	return id, nil
 }
@@ -5,6 +5,7 @@ package expenses
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
@@ -16,6 +17,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/PatcharaKL/assessment/migrations"
+	"github.com/PatcharaKL/assessment/problem"
 	"github.com/labstack/echo/v4"
 	_ "github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
@@ -90,6 +93,22 @@ func TestUpdateExpenseIn(t *testing.T) {
 	}
 }
 
+func TestConditionalGetExpenseIn(t *testing.T) {
+	res := request(http.MethodGet, uri("expenses/1"), strings.NewReader(""))
+	etag := res.Header.Get("ETag")
+
+	req, _ := http.NewRequest(http.MethodGet, uri("expenses/1"), nil)
+	req.Header.Set("If-None-Match", etag)
+	cached, err := http.DefaultClient.Do(req)
+
+	if assert.Nil(t, err) {
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.NotEmpty(t, etag)
+		assert.NotEmpty(t, res.Header.Get("Last-Modified"))
+		assert.Equal(t, http.StatusNotModified, cached.StatusCode)
+	}
+}
+
 func uri(path ...string) string {
 	host := "http://localhost:80"
 	if path == nil {
@@ -123,6 +142,7 @@ const serverPort = 80
 
 func setupServer() {
 	eh := echo.New()
+	eh.HTTPErrorHandler = problem.HTTPErrorHandler
 
 	go func(e *echo.Echo) {
 		db := initTestDatabase()
@@ -149,10 +169,78 @@ func testsEndpoint(e *echo.Echo, h *Handler) {
 	e.Start(fmt.Sprintf(":%d", serverPort))
 }
 
+// initTestDatabase creates the schema with the same migrations the server
+// runs, retrying while the database starts.
 func initTestDatabase() *sql.DB {
 	db, err := sql.Open("postgres", "postgresql://root:root@db/go-example-db?sslmode=disable")
 	if err != nil {
 		log.Fatal(err)
 	}
-	return db
+	deadline := time.Now().Add(time.Minute)
+	for {
+		_, err := migrations.Up(context.Background(), db)
+		if err == nil {
+			return db
+		}
+		if time.Now().After(deadline) {
+			log.Fatal(err)
+		}
+		log.Println(err)
+		time.Sleep(time.Second)
+	}
+}
+
+// TestOutboxCommitOrderIn opens a write, then tries to commit a second one
+// before it. Readers must never see the second write's event while the first,
+// with a lower id, is still to come.
+func TestOutboxCommitOrderIn(t *testing.T) {
+	db := initTestDatabase()
+	defer db.Close()
+	s := NewService(db)
+	ctx := context.Background()
+	e := Expenses{Title: "apple smoothie", Amount: 89, Tags: []string{}}
+
+	first, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Rollback()
+	firstEvent, err := s.appendEvent(ctx, first, EventCreated, "outbox-order", e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type result struct {
+		id  int64
+		err error
+	}
+	second := make(chan result, 1)
+	go func() {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			second <- result{err: err}
+			return
+		}
+		defer tx.Rollback()
+		ev, err := s.appendEvent(ctx, tx, EventCreated, "outbox-order", e)
+		if err == nil {
+			err = tx.Commit()
+		}
+		second <- result{ev.ID, err}
+	}()
+
+	select {
+	case <-second:
+		t.Fatal("the second write committed while the first was open")
+	case <-time.After(500 * time.Millisecond):
+	}
+	var visible int
+	err = db.QueryRowContext(ctx, "SELECT count(*) FROM outbox WHERE id >= $1", firstEvent.ID).Scan(&visible)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, visible, "an event is visible ahead of the open write")
+
+	assert.NoError(t, first.Commit())
+	got := <-second
+	assert.NoError(t, got.err)
+	assert.Greater(t, got.id, firstEvent.ID)
 }
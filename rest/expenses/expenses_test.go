@@ -5,13 +5,21 @@ package expenses
 
 import (
 	"bytes"
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/PatcharaKL/assessment/problem"
+	"github.com/PatcharaKL/assessment/rest/auth"
+	"github.com/PatcharaKL/assessment/sqlstmt"
 	"github.com/labstack/echo/v4"
 	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
@@ -23,12 +31,23 @@ func setupTestServer(method, uri string, body *bytes.Buffer) (*httptest.Response
 	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 	rec := httptest.NewRecorder()
 	c := e.NewContext(req, rec)
+	auth.SetPrincipal(c, auth.Principal{User: "Patchara", Role: auth.RoleMember, Scopes: auth.AllScopes})
 	return rec, c
 }
+
+// respond writes err the way the server's error handler does, so that the
+// assertions can check the response whatever the handler returned.
+func respond(c echo.Context, err error) error {
+	if err != nil {
+		problem.HTTPErrorHandler(err, c)
+	}
+	return nil
+}
 func TestCreateExpenseU(t *testing.T) {
 	successRes := "{\"id\":1,\"title\":\"strawberry smoothie\",\"amount\":79,\"note\":\"night market promotion discount 10 bath\",\"tags\":[\"food\",\"beverage\"]}"
-	badRequestRes := "{\"message\":\"code=400, message=Syntax error: offset=115, error=invalid character '}' looking for beginning of object key string, internal=invalid character '}' looking for beginning of object key string\"}"
-	InternalServerErrorRes := "{\"message\":\"all expectations were already fulfilled, call to Query 'INSERT INTO expenses (title, amount, note, tags) values ($1, $2, $3, $4) RETURNING id;' with args [{Name: Ordinal:1 Value:strawberry smoothie} {Name: Ordinal:2 Value:79} {Name: Ordinal:3 Value:night market promotion discount 10 bath} {Name: Ordinal:4 Value:{\\\"food\\\",\\\"beverage\\\"}}] was not expected\"}"
+	badRequestRes := `{"type":"about:blank","title":"Bad Request","status":400,"detail":"Syntax error: offset=115, error=invalid character '}' looking for beginning of object key string","instance":"/expenses","code":"invalid_request"}`
+	unprocessableRes := `{"type":"about:blank","title":"Unprocessable Entity","status":422,"detail":"request has invalid fields","instance":"/expenses","code":"validation_failed","errors":[{"field":"title","message":"is required"},{"field":"amount","message":"must be greater than 0"},{"field":"tags[1]","message":"may only contain letters, digits, spaces, '-' and '_'"}]}`
+	InternalServerErrorRes := `{"type":"about:blank","title":"Internal Server Error","status":500,"detail":"internal server error","instance":"/expenses","code":"internal_error"}`
 
 	tests := []struct {
 		name         string
@@ -68,6 +87,16 @@ func TestCreateExpenseU(t *testing.T) {
 			expectedRes:  InternalServerErrorRes,
 			expectedCode: http.StatusInternalServerError,
 		},
+		{
+			name: "testUnprocessableEntity",
+			body: bytes.NewBufferString(`{
+				"title": "",
+				"amount": -79,
+				"tags": ["food", "beverage!"]
+			}`),
+			expectedRes:  unprocessableRes,
+			expectedCode: http.StatusUnprocessableEntity,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -87,12 +116,17 @@ func TestCreateExpenseU(t *testing.T) {
 
 			// Set up mock to expect a query and return mock rows
 			if tt.name != "testInternalServerError" {
-				mock.ExpectQuery("INSERT INTO expenses").WithArgs("strawberry smoothie", 79.00, "night market promotion discount 10 bath", pq.Array([]string{"food", "beverage"})).WillReturnRows(expectedRow)
+				mock.ExpectBegin()
+				mock.ExpectQuery("INSERT INTO expenses").WithArgs("strawberry smoothie", 79.00, "night market promotion discount 10 bath", pq.Array([]string{"food", "beverage"}), "Patchara").WillReturnRows(expectedRow)
+				mock.ExpectExec("SELECT pg_advisory_xact_lock").WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery("INSERT INTO outbox").WithArgs(EventCreated, "Patchara", sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+				mock.ExpectCommit()
 			}
-			h := Handler{db}
+			h := Handler{Service: NewService(db)}
 
 			// Act
-			err = h.CreateExpensesHandler(c)
+			err = respond(c, h.CreateExpensesHandler(c))
 
 			// Assertions
 			fmt.Println(strings.TrimSpace(rec.Body.String()))
@@ -104,9 +138,131 @@ func TestCreateExpenseU(t *testing.T) {
 	}
 }
 
+// setupStatements prepares the service's queries on mock, the way the server
+// does when it starts.
+func setupStatements(t *testing.T, db *sql.DB, mock sqlmock.Sqlmock) *sqlstmt.Registry {
+	for _, q := range queries {
+		mock.ExpectPrepare(regexp.QuoteMeta(q)).WillBeClosed()
+	}
+	stmts, err := PrepareStatements(context.Background(), db)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when preparing statements", err)
+	}
+	return stmts
+}
+
+func TestHandlerReusesStatementsU(t *testing.T) {
+	// Arrange
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	stmts := setupStatements(t, db, mock)
+	h := Handler{Service: &Service{DB: db, Stmts: stmts}}
+	for i := 0; i < 3; i++ {
+		mock.ExpectQuery("SELECT (.+) FROM expenses").WithArgs("Patchara").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags", "version", "updated_at"}).AddRow(1, "apple smoothie", 89, "", "{beverage}", 1, time.Now()))
+	}
+
+	// Act
+	codes := []int{}
+	for i := 0; i < 3; i++ {
+		rec, c := setupTestServer(http.MethodGet, "/expenses", bytes.NewBufferString(``))
+		respond(c, h.GetExpensesHandler(c))
+		codes = append(codes, rec.Code)
+	}
+	closeErr := stmts.Close()
+
+	// Assert
+	assert.Equal(t, []int{http.StatusOK, http.StatusOK, http.StatusOK}, codes)
+	assert.NoError(t, closeErr)
+	assert.NoError(t, mock.ExpectationsWereMet(), "each statement is prepared once and closed")
+}
+
+// recordingPublisher keeps the events it is told about.
+type recordingPublisher struct {
+	events []Event
+}
+
+func (r *recordingPublisher) Publish(ctx context.Context, e Event) {
+	r.events = append(r.events, e)
+}
+
+func TestWritesPublishEventsU(t *testing.T) {
+	// Arrange
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	events := &recordingPublisher{}
+	h := Handler{Service: &Service{DB: db, Events: events}}
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO expenses").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("INSERT INTO outbox").WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery("UPDATE expenses").WillReturnRows(sqlmock.NewRows([]string{"owner"}).AddRow("Somchai"))
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("INSERT INTO outbox").WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(2, time.Now()))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery("UPDATE expenses").WillReturnRows(sqlmock.NewRows([]string{"owner"}))
+	mock.ExpectRollback()
+	body := `{"title": "apple smoothie", "amount": 89, "tags": ["beverage"]}`
+
+	// Act
+	_, create := setupTestServer(http.MethodPost, "/expenses", bytes.NewBufferString(body))
+	createErr := h.CreateExpensesHandler(create)
+	for _, id := range []string{"1", "2"} {
+		_, update := setupTestServer(http.MethodPut, "/expenses/"+id, bytes.NewBufferString(body))
+		update.SetParamNames("id")
+		update.SetParamValues(id)
+		h.UpdateExpensesHandler(update)
+	}
+
+	// Assert
+	assert.NoError(t, createErr)
+	if assert.Len(t, events.events, 2, "a failed update publishes nothing") {
+		assert.Equal(t, EventCreated, events.events[0].Type)
+		assert.Equal(t, "Patchara", events.events[0].Owner)
+		assert.Equal(t, 1, events.events[0].Expense.ID)
+		assert.Equal(t, int64(1), events.events[0].ID)
+		assert.Equal(t, EventUpdated, events.events[1].Type)
+		assert.Equal(t, int64(2), events.events[1].ID, "the outbox orders the events")
+		assert.Equal(t, "Somchai", events.events[1].Owner, "the owner, not the user who updated it")
+	}
+}
+
+// failingRecorder refuses every change it is told about.
+type failingRecorder struct{}
+
+func (failingRecorder) Record(context.Context, sqlstmt.Querier, Event) error {
+	return errors.New("can't queue webhook deliveries")
+}
+
+func TestRecorderFailsWriteU(t *testing.T) {
+	// Arrange
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	events := &recordingPublisher{}
+	s := Service{DB: db, Recorder: failingRecorder{}, Events: events}
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO expenses").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("INSERT INTO outbox").WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+	mock.ExpectRollback()
+
+	// Act
+	_, err := s.Create(context.Background(), auth.Principal{User: "Patchara"}, Expenses{Title: "apple smoothie", Amount: 89, Tags: []string{}})
+
+	// Assert
+	assert.Error(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Empty(t, events.events, "a rolled back change is published")
+}
+
 func TestGetExpenseByIDU(t *testing.T) {
 	successRes := "{\"id\":1,\"title\":\"strawberry smoothie\",\"amount\":79,\"note\":\"night market promotion discount 10 bath\",\"tags\":[\"food\",\"beverage\"]}"
-	InternalServerErrorRes := "{\"message\":\"all expectations were already fulfilled, call to Query 'SELECT * FROM expenses WHERE id = $1' with args [{Name: Ordinal:1 Value:1}] was not expected\"}"
+	notFoundRes := `{"type":"about:blank","title":"Not Found","status":404,"detail":"expense 1 not found","instance":"/expenses","code":"not_found"}`
+	invalidIDRes := `{"type":"about:blank","title":"Bad Request","status":400,"detail":"id must be a positive integer, got \"abc\"","instance":"/expenses","code":"invalid_id"}`
+	InternalServerErrorRes := `{"type":"about:blank","title":"Internal Server Error","status":500,"detail":"internal server error","instance":"/expenses","code":"internal_error"}`
 
 	tests := []struct {
 		name         string
@@ -123,6 +279,16 @@ func TestGetExpenseByIDU(t *testing.T) {
 			expectedRes:  InternalServerErrorRes,
 			expectedCode: http.StatusInternalServerError,
 		},
+		{
+			name:         "testNotFound",
+			expectedRes:  notFoundRes,
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "testInvalidID",
+			expectedRes:  invalidIDRes,
+			expectedCode: http.StatusBadRequest,
+		},
 	}
 	for _, tt := range tests {
 		// Arrange
@@ -130,6 +296,9 @@ func TestGetExpenseByIDU(t *testing.T) {
 		c.SetPath("/expenses/:id")
 		c.SetParamNames("id")
 		c.SetParamValues("1")
+		if tt.name == "testInvalidID" {
+			c.SetParamValues("abc")
+		}
 
 		db, mock, err := sqlmock.New()
 		if err != nil {
@@ -138,17 +307,20 @@ func TestGetExpenseByIDU(t *testing.T) {
 		defer db.Close()
 
 		// Set up mock rows to return when querying
-		expectedRow := sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags"}).
-			AddRow(1, "strawberry smoothie", 79, "night market promotion discount 10 bath", pq.Array([]string{"food", "beverage"}))
+		expectedRow := sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags", "version", "updated_at"}).
+			AddRow(1, "strawberry smoothie", 79, "night market promotion discount 10 bath", pq.Array([]string{"food", "beverage"}), 1, time.Now())
 
 		// Set up mock to expect a query and return mock rows
-		if tt.name != "testInternalServerError" {
-			mock.ExpectQuery("SELECT \\* FROM expenses WHERE id = \\$1").WithArgs("1").WillReturnRows(expectedRow)
+		switch tt.name {
+		case "testSucceed":
+			mock.ExpectQuery("SELECT (.+) FROM expenses WHERE id = \\$1").WithArgs(1, "Patchara").WillReturnRows(expectedRow)
+		case "testNotFound":
+			mock.ExpectQuery("SELECT (.+) FROM expenses WHERE id = \\$1").WithArgs(1, "Patchara").WillReturnError(sql.ErrNoRows)
 		}
-		h := Handler{db}
+		h := Handler{Service: NewService(db)}
 
 		// Act
-		err = h.GetExpenseByIdHandler(c)
+		err = respond(c, h.GetExpenseByIdHandler(c))
 
 		// Assertions
 		fmt.Println(strings.TrimSpace(rec.Body.String()))
@@ -161,9 +333,10 @@ func TestGetExpenseByIDU(t *testing.T) {
 
 func TestUpdateExpenseU(t *testing.T) {
 	successRes := "{\"id\":1,\"title\":\"apple smoothie\",\"amount\":89,\"note\":\"no discount\",\"tags\":[\"beverage\"]}"
-	badRequestRes := "{\"message\":\"code=400, message=Syntax error: offset=95, error=invalid character '}' looking for beginning of object key string, internal=invalid character '}' looking for beginning of object key string\"}"
-	prepareStmtErrorRes := "{\"message\":\"can't prepare update expense statement:all expectations were already fulfilled, call to Prepare 'UPDATE expenses SET title = $2, amount = $3, note = $4, tags = $5 WHERE id = $1' query was not expected\"}"
-	ExecStmtErrorRes := "{\"message\":\"Can't update expense data:all expectations were already fulfilled, call to ExecQuery 'UPDATE expenses SET title = $2, amount = $3, note = $4, tags = $5 WHERE id = $1' with args [{Name: Ordinal:1 Value:1} {Name: Ordinal:2 Value:strawberry smoothie} {Name: Ordinal:3 Value:79} {Name: Ordinal:4 Value:night market promotion discount 10 bath} {Name: Ordinal:5 Value:{\\\"food\\\",\\\"beverage\\\"}}] was not expected\"}"
+	badRequestRes := `{"type":"about:blank","title":"Bad Request","status":400,"detail":"Syntax error: offset=95, error=invalid character '}' looking for beginning of object key string","instance":"/expenses","code":"invalid_request"}`
+	notFoundRes := `{"type":"about:blank","title":"Not Found","status":404,"detail":"expense 1 not found","instance":"/expenses","code":"not_found"}`
+	beginErrorRes := `{"type":"about:blank","title":"Internal Server Error","status":500,"detail":"internal server error","instance":"/expenses","code":"internal_error"}`
+	ExecStmtErrorRes := `{"type":"about:blank","title":"Internal Server Error","status":500,"detail":"internal server error","instance":"/expenses","code":"internal_error"}`
 
 	tests := []struct {
 		name         string
@@ -195,14 +368,14 @@ func TestUpdateExpenseU(t *testing.T) {
 			expectedCode: http.StatusBadRequest,
 		},
 		{
-			name: "testPrepareError",
+			name: "testBeginError",
 			body: bytes.NewBufferString(`{
 				"title": "strawberry smoothie",
 				"amount": 79,
 				"note": "night market promotion discount 10 bath",
 				"tags": ["food", "beverage"]
 			}`),
-			expectedRes:  prepareStmtErrorRes,
+			expectedRes:  beginErrorRes,
 			expectedCode: http.StatusInternalServerError,
 		},
 		{
@@ -216,6 +389,17 @@ func TestUpdateExpenseU(t *testing.T) {
 			expectedRes:  ExecStmtErrorRes,
 			expectedCode: http.StatusInternalServerError,
 		},
+		{
+			name: "testNotFound",
+			body: bytes.NewBufferString(`{
+				"title": "apple smoothie",
+				"amount": 89,
+				"note": "no discount",
+				"tags": ["beverage"]
+			}`),
+			expectedRes:  notFoundRes,
+			expectedCode: http.StatusNotFound,
+		},
 	}
 	for _, tt := range tests {
 		rec, c := setupTestServer(http.MethodPost, "/expenses", tt.body)
@@ -234,16 +418,27 @@ func TestUpdateExpenseU(t *testing.T) {
 			AddRow("1", "strawberry smoothie", 79, "night market promotion discount 10 bath", pq.Array([]string{"food", "beverage"}))
 
 		// Set up mock to expect a query and return mock rows
-		if tt.name != "testPrepareError" {
-			expectPrepare := mock.ExpectPrepare("UPDATE expenses SET (.+) WHERE (.+)")
-			if tt.name != "testExecError" {
-				expectPrepare.ExpectExec().WithArgs("1", "apple smoothie", 89.00, "no discount", pq.Array([]string{"beverage"})).WillReturnResult(sqlmock.NewResult(0, 0))
+		stmts := setupStatements(t, db, mock)
+		if tt.name == "testBeginError" {
+			mock.ExpectBegin().WillReturnError(errors.New("connection refused"))
+		} else {
+			mock.ExpectBegin()
+		}
+		if tt.name != "testBeginError" && tt.name != "testExecError" {
+			owners := sqlmock.NewRows([]string{"owner"})
+			if tt.name != "testNotFound" {
+				owners.AddRow("Patchara")
 			}
+			mock.ExpectQuery("UPDATE expenses SET (.+) WHERE (.+)").WithArgs(1, "apple smoothie", 89.00, "no discount", pq.Array([]string{"beverage"}), "Patchara").WillReturnRows(owners)
+			mock.ExpectExec("SELECT pg_advisory_xact_lock").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectQuery("INSERT INTO outbox").WithArgs(EventUpdated, "Patchara", sqlmock.AnyArg()).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+			mock.ExpectCommit()
 		}
-		h := Handler{db}
+		h := Handler{Service: &Service{DB: db, Stmts: stmts}}
 
 		// Act
-		err = h.UpdateExpensesHandler(c)
+		err = respond(c, h.UpdateExpensesHandler(c))
 
 		// Assertions
 		fmt.Println(strings.TrimSpace(rec.Body.String()))
@@ -254,11 +449,63 @@ func TestUpdateExpenseU(t *testing.T) {
 	}
 }
 
+func TestUpdateExpenseIfMatchU(t *testing.T) {
+	updated := time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC)
+	tagger := newTagger()
+	tagger.add(1, 2, updated)
+	current := tagger.validators().ETag
+
+	tests := []struct {
+		name         string
+		ifMatch      string
+		expectedCode int
+	}{
+		{"testCurrent", current, http.StatusOK},
+		{"testAny", "*", http.StatusOK},
+		{"testChanged", `"stale"`, http.StatusPreconditionFailed},
+		{"testWeak", "W/" + current, http.StatusPreconditionFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			rec, c := setupTestServer(http.MethodPut, "/expenses/1", bytes.NewBufferString(`{"title": "apple smoothie", "amount": 89}`))
+			c.Request().Header.Set("If-Match", tt.ifMatch)
+			c.SetPath("/expenses/:id")
+			c.SetParamNames("id")
+			c.SetParamValues("1")
+			db, mock, _ := sqlmock.New()
+			defer db.Close()
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta(lockExpenseSQL)).WithArgs(1, "Patchara").
+				WillReturnRows(sqlmock.NewRows([]string{"version", "updated_at"}).AddRow(2, updated))
+			if tt.expectedCode == http.StatusOK {
+				mock.ExpectQuery("UPDATE expenses").WillReturnRows(sqlmock.NewRows([]string{"owner"}).AddRow("Patchara"))
+				mock.ExpectExec("SELECT pg_advisory_xact_lock").WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery("INSERT INTO outbox").WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+				mock.ExpectCommit()
+			} else {
+				mock.ExpectRollback()
+			}
+			h := Handler{Service: NewService(db)}
+
+			// Act
+			err := respond(c, h.UpdateExpensesHandler(c))
+
+			// Assert
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedCode, rec.Code)
+			if tt.expectedCode == http.StatusPreconditionFailed {
+				assert.Contains(t, rec.Body.String(), `"code":"precondition_failed"`)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
 func TestGetExpensesU(t *testing.T) {
 	successRes := "[{\"id\":1,\"title\":\"strawberry smoothie\",\"amount\":79,\"note\":\"night market promotion discount 10 bath\",\"tags\":[\"food\",\"beverage\"]},{\"id\":2,\"title\":\"apple smoothie\",\"amount\":89,\"note\":\"no discount\",\"tags\":[\"beverage\"]}]"
-	prepareStmtErrorRes := "{\"message\":\"can't prepare query all expenses statement:all expectations were already fulfilled, call to Prepare 'SELECT * FROM expenses' query was not expected\"}"
-	queryStmtErrorRes := "{\"message\":\"can't query expenses: all expectations were already fulfilled, call to Query 'SELECT * FROM expenses' with args [] was not expected\"}"
-	scanErrorRes := "{\"message\":\"can't scan user:sql: Scan error on column index 4, name \\\"tags\\\": pq: unable to parse array; expected '{' at offset 0\"}"
+	queryStmtErrorRes := `{"type":"about:blank","title":"Internal Server Error","status":500,"detail":"internal server error","instance":"/expenses","code":"internal_error"}`
+	scanErrorRes := `{"type":"about:blank","title":"Internal Server Error","status":500,"detail":"internal server error","instance":"/expenses","code":"internal_error"}`
 
 	tests := []struct {
 		name         string
@@ -270,11 +517,6 @@ func TestGetExpensesU(t *testing.T) {
 			expectedRes:  successRes,
 			expectedCode: http.StatusOK,
 		},
-		{
-			name:         "testPrepareStmtError",
-			expectedRes:  prepareStmtErrorRes,
-			expectedCode: http.StatusInternalServerError,
-		},
 		{
 			name:         "testQueryStmtError",
 			expectedRes:  queryStmtErrorRes,
@@ -299,25 +541,23 @@ func TestGetExpensesU(t *testing.T) {
 		// Set up mock rows to return when querying
 		var expectedRow *sqlmock.Rows
 		if tt.name != "testScanError" {
-			expectedRow = sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags"}).
-				AddRow(1, "strawberry smoothie", 79, "night market promotion discount 10 bath", pq.Array([]string{"food", "beverage"})).
-				AddRow(2, "apple smoothie", 89, "no discount", pq.Array([]string{"beverage"}))
+			expectedRow = sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags", "version", "updated_at"}).
+				AddRow(1, "strawberry smoothie", 79, "night market promotion discount 10 bath", pq.Array([]string{"food", "beverage"}), 1, time.Now()).
+				AddRow(2, "apple smoothie", 89, "no discount", pq.Array([]string{"beverage"}), 1, time.Now())
 		} else {
-			expectedRow = sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags"}).
-				AddRow(1, "strawberry smoothie", 79, "night market promotion discount 10 bath", "food").
-				AddRow(2, "apple smoothie", 89, "no discount", pq.Array([]string{"beverage"}))
+			expectedRow = sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags", "version", "updated_at"}).
+				AddRow(1, "strawberry smoothie", 79, "night market promotion discount 10 bath", "food", 1, time.Now()).
+				AddRow(2, "apple smoothie", 89, "no discount", pq.Array([]string{"beverage"}), 1, time.Now())
 		}
-		if tt.name != "testPrepareStmtError" {
+		stmts := setupStatements(t, db, mock)
+		if tt.name != "testQueryStmtError" {
 			// Set up mock to expect a query and return mock rows
-			expectedPrepare := mock.ExpectPrepare("SELECT \\* FROM expenses")
-			if tt.name != "testQueryStmtError" {
-				expectedPrepare.ExpectQuery().WillReturnRows(expectedRow)
-			}
+			mock.ExpectQuery("SELECT (.+) FROM expenses").WithArgs("Patchara").WillReturnRows(expectedRow)
 		}
-		h := Handler{db}
+		h := Handler{Service: &Service{DB: db, Stmts: stmts}}
 
 		// Act
-		err = h.GetExpensesHandler(c)
+		err = respond(c, h.GetExpensesHandler(c))
 
 		// Assertions
 		fmt.Println(strings.TrimSpace(rec.Body.String()))
@@ -328,6 +568,53 @@ func TestGetExpensesU(t *testing.T) {
 	}
 }
 
+func TestConditionalGetExpenseU(t *testing.T) {
+	// Arrange
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	h := Handler{Service: NewService(db)}
+	updated := time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC)
+	expectVersion := func(version int) {
+		mock.ExpectQuery("SELECT (.+) FROM expenses WHERE id = \\$1").WithArgs(1, "Patchara").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags", "version", "updated_at"}).
+				AddRow(1, "apple smoothie", 89, "", "{beverage}", version, updated.Add(time.Duration(version)*time.Minute)))
+	}
+	get := func(header, value string) *httptest.ResponseRecorder {
+		rec, c := setupTestServer(http.MethodGet, "/expenses/1", bytes.NewBufferString(``))
+		if header != "" {
+			c.Request().Header.Set(header, value)
+		}
+		c.SetPath("/expenses/:id")
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		respond(c, h.GetExpenseByIdHandler(c))
+		return rec
+	}
+	expectVersion(1)
+	expectVersion(1)
+	expectVersion(2)
+	expectVersion(2)
+
+	// Act
+	first := get("", "")
+	etag := first.Header().Get("ETag")
+	unchanged := get("If-None-Match", etag)
+	changed := get("If-None-Match", etag)
+	notModifiedSince := get("If-Modified-Since", changed.Header().Get("Last-Modified"))
+
+	// Assert
+	assert.Equal(t, http.StatusOK, first.Code)
+	assert.Regexp(t, `^"[A-Za-z0-9_-]+"$`, etag, "a strong tag")
+	assert.Equal(t, "Wed, 01 Mar 2023 12:01:00 GMT", first.Header().Get("Last-Modified"))
+	assert.Equal(t, http.StatusNotModified, unchanged.Code)
+	assert.Empty(t, unchanged.Body.String())
+	assert.Equal(t, etag, unchanged.Header().Get("ETag"))
+	assert.Equal(t, http.StatusOK, changed.Code, "an update bumps the version")
+	assert.NotEqual(t, etag, changed.Header().Get("ETag"))
+	assert.Equal(t, http.StatusNotModified, notModifiedSince.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
 func TestNewApplicationInit(t *testing.T) {
 	// Arrange
 	db, _, _ := sqlmock.New()
@@ -340,3 +627,35 @@ func TestNewApplicationInit(t *testing.T) {
 	// Assert
 	assert.Equal(t, expected, actual)
 }
+
+func TestEachPagesU(t *testing.T) {
+	// Arrange
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	pageSize := eachPageSize
+	eachPageSize = 2
+	t.Cleanup(func() { eachPageSize = pageSize })
+	columns := []string{"id", "title", "amount", "note", "tags"}
+	mock.ExpectQuery(regexp.QuoteMeta(findExpensesSQL)).WithArgs("Patchara", nil, nil, nil, "", 0, 2).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(1, "apple smoothie", 89, "", "{beverage}").AddRow(4, "mango smoothie", 99, "", "{beverage}")).
+		RowsWillBeClosed()
+	mock.ExpectQuery(regexp.QuoteMeta(findExpensesSQL)).WithArgs("Patchara", nil, nil, nil, "", 4, 2).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(7, "iPhone 14 Pro Max 1TB", 66900, "", "{gadget}")).
+		RowsWillBeClosed()
+	s := NewService(db)
+	ids := []int{}
+	inUse := []int{}
+
+	// Act
+	err := s.Each(context.Background(), member, func(e Expenses) error {
+		ids = append(ids, e.ID)
+		inUse = append(inUse, db.Stats().InUse)
+		return nil
+	})
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 4, 7}, ids)
+	assert.Equal(t, []int{0, 0, 0}, inUse, "no connection is held while fn runs")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -3,44 +3,36 @@ package expenses
 import (
 	"net/http"
 
+	"github.com/PatcharaKL/assessment/conditional"
+	"github.com/PatcharaKL/assessment/rest/auth"
 	"github.com/labstack/echo/v4"
-	"github.com/lib/pq"
 )
 
 func (h *Handler) GetExpenseByIdHandler(c echo.Context) error {
-	id := c.Param("id")
-
-	row := h.DB.QueryRow(getExpenseSQL, id)
-
-	e := Expenses{}
-
-	if err := row.Scan(&e.ID, &e.Title, &e.Amount, &e.Note, pq.Array(&e.Tags)); err != nil {
-		return c.JSON(http.StatusInternalServerError, Err{Message: err.Error()})
+	id, err := expenseID(c)
+	if err != nil {
+		return err
 	}
 
+	p, _ := auth.PrincipalFrom(c)
+	e, v, err := h.Service.GetValidated(c.Request().Context(), p, id)
+	if err != nil {
+		return err
+	}
+	if conditional.NotModified(c, v) {
+		return c.NoContent(http.StatusNotModified)
+	}
 	return c.JSON(http.StatusOK, e)
 }
 
 func (h *Handler) GetExpensesHandler(c echo.Context) error {
-	stmt, err := h.DB.Prepare(getExpensesSQL)
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, Err{Message: "can't prepare query all expenses statement:" + err.Error()})
-	}
-
-	rows, err := stmt.Query()
+	p, _ := auth.PrincipalFrom(c)
+	expenses, v, err := h.Service.ListValidated(c.Request().Context(), p)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, Err{Message: "can't query expenses: " + err.Error()})
+		return err
 	}
-
-	expenses := []Expenses{}
-	for rows.Next() {
-		e := Expenses{}
-		err = rows.Scan(&e.ID, &e.Title, &e.Amount, &e.Note, pq.Array(&e.Tags))
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, Err{Message: "can't scan user:" + err.Error()})
-		}
-		expenses = append(expenses, e)
+	if conditional.NotModified(c, v) {
+		return c.NoContent(http.StatusNotModified)
 	}
-
 	return c.JSON(http.StatusOK, expenses)
 }
@@ -0,0 +1,127 @@
+package expenses
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/PatcharaKL/assessment/rest/auth"
+	"github.com/lib/pq"
+)
+
+// Filter narrows a listing. Zero fields match every expense.
+type Filter struct {
+	// Tags the expense must all have.
+	Tags      []string
+	MinAmount *float64
+	MaxAmount *float64
+	// Search matches titles containing it, ignoring case.
+	Search string
+}
+
+func (f Filter) args(p auth.Principal) []any {
+	var tags any
+	if len(f.Tags) > 0 {
+		tags = pq.Array(f.Tags)
+	}
+	return []any{p.OwnerFilter(), tags, f.MinAmount, f.MaxAmount, f.Search}
+}
+
+// Page selects up to Limit expenses with an ID above After, in ID order, so
+// pages stay stable while expenses are added.
+type Page struct {
+	After int
+	Limit int
+}
+
+type Totals struct {
+	Tag   string
+	Count int
+	Sum   float64
+}
+
+// Find returns a page of the expenses that match f.
+func (s *Service) Find(ctx context.Context, p auth.Principal, f Filter, page Page) ([]Expenses, error) {
+	return cached(ctx, s.Cache, p, "find?"+f.key(page), func(ctx context.Context) ([]Expenses, error) {
+		return s.find(ctx, p, f, page)
+	})
+}
+
+func (s *Service) find(ctx context.Context, p auth.Principal, f Filter, page Page) ([]Expenses, error) {
+	rows, err := s.db().QueryContext(ctx, findExpensesSQL, append(f.args(p), page.After, page.Limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("can't query expenses: %w", err)
+	}
+	defer rows.Close()
+
+	expenses := []Expenses{}
+	for rows.Next() {
+		e := Expenses{}
+		if err := rows.Scan(&e.ID, &e.Title, &e.Amount, &e.Note, pq.Array(&e.Tags)); err != nil {
+			return nil, fmt.Errorf("can't scan expense: %w", err)
+		}
+		expenses = append(expenses, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("can't query expenses: %w", err)
+	}
+	return expenses, nil
+}
+
+// Totals counts and sums the expenses that match f.
+func (s *Service) Totals(ctx context.Context, p auth.Principal, f Filter) (Totals, error) {
+	t := Totals{}
+	if err := s.db().QueryRowContext(ctx, totalsSQL, f.args(p)...).Scan(&t.Count, &t.Sum); err != nil {
+		return Totals{}, fmt.Errorf("can't total expenses: %w", err)
+	}
+	return t, nil
+}
+
+// TagTotals counts and sums the expenses under each of tags, or under every
+// tag in use when tags is empty. Tags without expenses are left out.
+func (s *Service) TagTotals(ctx context.Context, p auth.Principal, tags []string) ([]Totals, error) {
+	var filter any
+	if len(tags) > 0 {
+		filter = pq.Array(tags)
+	}
+	rows, err := s.db().QueryContext(ctx, tagTotalsSQL, p.OwnerFilter(), filter)
+	if err != nil {
+		return nil, fmt.Errorf("can't total tags: %w", err)
+	}
+	defer rows.Close()
+
+	totals := []Totals{}
+	for rows.Next() {
+		t := Totals{}
+		if err := rows.Scan(&t.Tag, &t.Count, &t.Sum); err != nil {
+			return nil, fmt.Errorf("can't scan tag totals: %w", err)
+		}
+		totals = append(totals, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("can't total tags: %w", err)
+	}
+	return totals, nil
+}
+
+// ByTags returns up to limit expenses for each of tags in one query.
+func (s *Service) ByTags(ctx context.Context, p auth.Principal, tags []string, limit int) (map[string][]Expenses, error) {
+	rows, err := s.db().QueryContext(ctx, byTagsSQL, p.OwnerFilter(), pq.Array(tags), limit)
+	if err != nil {
+		return nil, fmt.Errorf("can't query expenses by tag: %w", err)
+	}
+	defer rows.Close()
+
+	byTag := map[string][]Expenses{}
+	for rows.Next() {
+		var tag string
+		e := Expenses{}
+		if err := rows.Scan(&tag, &e.ID, &e.Title, &e.Amount, &e.Note, pq.Array(&e.Tags)); err != nil {
+			return nil, fmt.Errorf("can't scan expense: %w", err)
+		}
+		byTag[tag] = append(byTag[tag], e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("can't query expenses by tag: %w", err)
+	}
+	return byTag, nil
+}
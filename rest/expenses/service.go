@@ -0,0 +1,254 @@
+package expenses
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/PatcharaKL/assessment/conditional"
+	"github.com/PatcharaKL/assessment/metrics"
+	"github.com/PatcharaKL/assessment/problem"
+	"github.com/PatcharaKL/assessment/rest/auth"
+	"github.com/PatcharaKL/assessment/sqlstmt"
+	"github.com/PatcharaKL/assessment/validation"
+	"github.com/lib/pq"
+)
+
+// Service holds the expense rules shared by the REST and gRPC APIs. Each
+// method acts for the principal p and only sees the expenses p may access.
+type Service struct {
+	DB *sql.DB
+	// Stmts are the queries prepared by PrepareStatements. Without them the
+	// queries run unprepared, which suits one-off commands.
+	Stmts    *sqlstmt.Registry
+	Recorder Recorder
+	Events   Publisher
+	// Cache keeps the results of Get, List and Find. Without it every read
+	// queries the database.
+	Cache *Cache
+}
+
+func NewService(db *sql.DB) *Service {
+	return &Service{DB: db}
+}
+
+func (s *Service) db() sqlstmt.Querier {
+	if s.Stmts != nil {
+		return s.Stmts
+	}
+	return s.DB
+}
+
+func (s *Service) Create(ctx context.Context, p auth.Principal, e Expenses) (Expenses, error) {
+	return s.CreateWith(ctx, p, e, func(tx sqlstmt.Querier) (int, error) {
+		var id int
+		if err := tx.QueryRowContext(ctx, createExpenseSQL, e.Title, e.Amount, e.Note, pq.Array(e.Tags), p.User).Scan(&id); err != nil {
+			return 0, fmt.Errorf("can't create expense: %w", err)
+		}
+		return id, nil
+	})
+}
+
+// CreateWith is Create for expenses stored with more than Create stores,
+// such as group expenses. insert stores e and what goes with it in tx and
+// returns its id; the event, cache and publishers are seen to as for Create.
+func (s *Service) CreateWith(ctx context.Context, p auth.Principal, e Expenses, insert func(tx sqlstmt.Querier) (int, error)) (Expenses, error) {
+	if err := validation.Struct(e); err != nil {
+		return Expenses{}, err
+	}
+
+	var ev Event
+	err := s.inTx(ctx, func(tx sqlstmt.Querier) error {
+		var err error
+		if e.ID, err = insert(tx); err != nil {
+			return err
+		}
+		ev, err = s.appendEvent(ctx, tx, EventCreated, p.User, e)
+		return err
+	})
+	if err != nil {
+		return Expenses{}, err
+	}
+
+	metrics.ExpenseCreated(e.Amount, metrics.DefaultCurrency)
+	s.publish(ctx, ev)
+	return e, nil
+}
+
+func (s *Service) Get(ctx context.Context, p auth.Principal, id int) (Expenses, error) {
+	e, _, err := s.GetValidated(ctx, p, id)
+	return e, err
+}
+
+// GetValidated is Get that also returns the validators of the expense, to
+// answer conditional requests.
+func (s *Service) GetValidated(ctx context.Context, p auth.Principal, id int) (Expenses, conditional.Validators, error) {
+	v, err := cached(ctx, s.Cache, p, "id:"+strconv.Itoa(id), func(ctx context.Context) (versioned[Expenses], error) {
+		row := s.db().QueryRowContext(ctx, getExpenseSQL, id, p.OwnerFilter())
+		e := Expenses{}
+		var version int64
+		var updated time.Time
+		err := row.Scan(&e.ID, &e.Title, &e.Amount, &e.Note, pq.Array(&e.Tags), &version, &updated)
+		if errors.Is(err, sql.ErrNoRows) {
+			return versioned[Expenses]{}, problem.NotFound(fmt.Sprintf("expense %d not found", id))
+		}
+		if err != nil {
+			return versioned[Expenses]{}, fmt.Errorf("can't get expense: %w", err)
+		}
+		t := newTagger()
+		t.add(e.ID, version, updated)
+		return versioned[Expenses]{Value: e, Validators: t.validators()}, nil
+	})
+	return v.Value, v.Validators, err
+}
+
+func (s *Service) List(ctx context.Context, p auth.Principal) ([]Expenses, error) {
+	expenses, _, err := s.ListValidated(ctx, p)
+	return expenses, err
+}
+
+// ListValidated is List that also returns the validators of the list, to
+// answer conditional requests.
+func (s *Service) ListValidated(ctx context.Context, p auth.Principal) ([]Expenses, conditional.Validators, error) {
+	v, err := cached(ctx, s.Cache, p, "list", func(ctx context.Context) (versioned[[]Expenses], error) {
+		expenses := []Expenses{}
+		t := newTagger()
+		err := s.each(ctx, p, func(e Expenses, version int64, updated time.Time) error {
+			expenses = append(expenses, e)
+			t.add(e.ID, version, updated)
+			return nil
+		})
+		if err != nil {
+			return versioned[[]Expenses]{}, err
+		}
+		return versioned[[]Expenses]{Value: expenses, Validators: t.validators()}, nil
+	})
+	return v.Value, v.Validators, err
+}
+
+// eachPageSize is how many expenses Each reads at a time.
+var eachPageSize = 100
+
+// Each calls fn for every expense in ID order, without loading them all
+// first, and stops at the first error fn returns. It reads a page at a time
+// and closes the page's rows before calling fn, so a slow fn, such as a send
+// to a slow gRPC client, holds no connection and isn't cut off by the query
+// timeout.
+func (s *Service) Each(ctx context.Context, p auth.Principal, fn func(Expenses) error) error {
+	page := Page{Limit: eachPageSize}
+	for {
+		expenses, err := s.find(ctx, p, Filter{}, page)
+		if err != nil {
+			return err
+		}
+		for _, e := range expenses {
+			if err := fn(e); err != nil {
+				return err
+			}
+		}
+		if len(expenses) < page.Limit {
+			return nil
+		}
+		page.After = expenses[len(expenses)-1].ID
+	}
+}
+
+// each calls fn for every expense, with its version and last update, from
+// one query.
+func (s *Service) each(ctx context.Context, p auth.Principal, fn func(e Expenses, version int64, updated time.Time) error) error {
+	rows, err := s.db().QueryContext(ctx, getExpensesSQL, p.OwnerFilter())
+	if err != nil {
+		return fmt.Errorf("can't query expenses: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e := Expenses{}
+		var version int64
+		var updated time.Time
+		if err := rows.Scan(&e.ID, &e.Title, &e.Amount, &e.Note, pq.Array(&e.Tags), &version, &updated); err != nil {
+			return fmt.Errorf("can't scan expense: %w", err)
+		}
+		if err := fn(e, version, updated); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("can't query expenses: %w", err)
+	}
+	return nil
+}
+
+func (s *Service) Update(ctx context.Context, p auth.Principal, id int, e Expenses) (Expenses, error) {
+	return s.UpdateIfMatch(ctx, p, id, e, "")
+}
+
+// UpdateIfMatch is Update that only goes ahead while the expense's entity
+// tag is in ifMatch, the value of an If-Match header, so that a client
+// can't overwrite a change it hasn't seen. An empty ifMatch always goes
+// ahead.
+func (s *Service) UpdateIfMatch(ctx context.Context, p auth.Principal, id int, e Expenses, ifMatch string) (Expenses, error) {
+	if err := validation.Struct(e); err != nil {
+		return Expenses{}, err
+	}
+	e.ID = id
+
+	var ev Event
+	err := s.inTx(ctx, func(tx sqlstmt.Querier) error {
+		if ifMatch != "" {
+			if err := checkMatch(ctx, tx, p, id, ifMatch); err != nil {
+				return err
+			}
+		}
+		var owner sql.NullString
+		err := tx.QueryRowContext(ctx, updateExpenseSQL, id, e.Title, e.Amount, e.Note, pq.Array(e.Tags), p.OwnerFilter()).Scan(&owner)
+		if errors.Is(err, sql.ErrNoRows) {
+			return problem.NotFound(fmt.Sprintf("expense %d not found", id))
+		}
+		if err != nil {
+			return fmt.Errorf("can't update expense: %w", err)
+		}
+		ev, err = s.appendEvent(ctx, tx, EventUpdated, owner.String, e)
+		return err
+	})
+	if err != nil {
+		return Expenses{}, err
+	}
+
+	s.publish(ctx, ev)
+	return e, nil
+}
+
+// AssignUnowned gives owner the expenses that have none, those created
+// before expenses had owners, and returns how many there were. Until they
+// have an owner only admins can see them.
+func (s *Service) AssignUnowned(ctx context.Context, owner string) (int64, error) {
+	res, err := s.db().ExecContext(ctx, assignUnownedSQL, owner)
+	if err != nil {
+		return 0, fmt.Errorf("can't assign unowned expenses: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// checkMatch locks the expense until the transaction ends and fails unless
+// its entity tag, the one GetValidated gives, is in ifMatch.
+func checkMatch(ctx context.Context, tx sqlstmt.Querier, p auth.Principal, id int, ifMatch string) error {
+	var version int64
+	var updated time.Time
+	err := tx.QueryRowContext(ctx, lockExpenseSQL, id, p.OwnerFilter()).Scan(&version, &updated)
+	if errors.Is(err, sql.ErrNoRows) {
+		return problem.NotFound(fmt.Sprintf("expense %d not found", id))
+	}
+	if err != nil {
+		return fmt.Errorf("can't lock expense: %w", err)
+	}
+	t := newTagger()
+	t.add(id, version, updated)
+	if !conditional.Matches(ifMatch, t.validators().ETag) {
+		return problem.PreconditionFailed(fmt.Sprintf("expense %d has changed since it was read", id))
+	}
+	return nil
+}
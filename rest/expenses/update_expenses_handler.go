@@ -3,26 +3,25 @@ package expenses
 import (
 	"net/http"
 
+	"github.com/PatcharaKL/assessment/rest/auth"
 	"github.com/labstack/echo/v4"
-	"github.com/lib/pq"
 )
 
 func (h *Handler) UpdateExpensesHandler(c echo.Context) error {
-	id := c.Param("id")
-	e := Expenses{}
+	id, err := expenseID(c)
+	if err != nil {
+		return err
+	}
 
+	e := Expenses{}
 	if err := c.Bind(&e); err != nil {
-		return c.JSON(http.StatusBadRequest, Err{Message: err.Error()})
+		return err
 	}
 
-	stmt, err := h.DB.Prepare(updateExpenseSQL)
+	p, _ := auth.PrincipalFrom(c)
+	e, err = h.Service.UpdateIfMatch(c.Request().Context(), p, id, e, c.Request().Header.Get("If-Match"))
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, Err{Message: "can't prepare update expense statement:" + err.Error()})
+		return err
 	}
-
-	if _, err := stmt.Exec(id, e.Title, e.Amount, e.Note, pq.Array(e.Tags)); err != nil {
-		return c.JSON(http.StatusInternalServerError, Err{Message: "Can't update expense data:" + err.Error()})
-	}
-
 	return c.JSON(http.StatusOK, e)
 }
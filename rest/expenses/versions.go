@@ -0,0 +1,44 @@
+package expenses
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"time"
+
+	"github.com/PatcharaKL/assessment/conditional"
+)
+
+// versioned is a read result with its validators, which is what the cache
+// keeps of Get and List.
+type versioned[T any] struct {
+	Value      T                      `json:"value"`
+	Validators conditional.Validators `json:"validators"`
+}
+
+// tagger derives the validators of a response from the expenses in it. Every
+// write bumps an expense's version, so the entity tag, a digest of the ids
+// and versions in order, changes whenever the response would.
+type tagger struct {
+	h        hash.Hash
+	modified time.Time
+}
+
+func newTagger() *tagger {
+	return &tagger{h: sha256.New()}
+}
+
+func (t *tagger) add(id int, version int64, updated time.Time) {
+	fmt.Fprintf(t.h, "%d.%d;", id, version)
+	if updated.After(t.modified) {
+		t.modified = updated
+	}
+}
+
+func (t *tagger) validators() conditional.Validators {
+	return conditional.Validators{
+		ETag:         `"` + base64.RawURLEncoding.EncodeToString(t.h.Sum(nil)[:18]) + `"`,
+		LastModified: t.modified,
+	}
+}
@@ -0,0 +1,152 @@
+package groups
+
+import (
+	"math/bits"
+	"sort"
+	"time"
+)
+
+// Balance is a member's net position in a group: positive when the group owes
+// them money, negative when they owe the group.
+type Balance struct {
+	User    string  `json:"user"`
+	Balance float64 `json:"balance"`
+}
+
+type Transfer struct {
+	From   string  `json:"from"`
+	To     string  `json:"to"`
+	Amount float64 `json:"amount"`
+}
+
+// Settlement is a payment between two members that clears part of a debt.
+type Settlement struct {
+	ID        int       `json:"id"`
+	GroupID   int       `json:"group_id"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Amount    float64   `json:"amount"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// maxExactSettle bounds the members with a nonzero balance for which
+// settleUp searches for the fewest transfers, a search over every subset of
+// them. Larger groups are settled greedily, in at most one transfer fewer
+// than their members.
+const maxExactSettle = 16
+
+type position struct {
+	user  string
+	cents int64
+}
+
+// settleUp returns the fewest transfers that bring every balance to zero.
+// Members whose balances cancel out can settle among themselves, and k such
+// members need k-1 transfers, so the fewest transfers come from splitting
+// the members into as many zero-sum subgroups as possible. Each subgroup is
+// then settled greedily.
+func settleUp(balances []Balance) []Transfer {
+	var positions []position
+	for _, b := range balances {
+		if c := toCents(b.Balance); c != 0 {
+			positions = append(positions, position{b.User, c})
+		}
+	}
+
+	transfers := []Transfer{}
+	for _, g := range zeroSumGroups(positions) {
+		transfers = append(transfers, settleGreedily(g)...)
+	}
+	return transfers
+}
+
+// zeroSumGroups splits positions into as many groups summing to zero as it
+// can. For each subset of positions it finds, by dynamic programming over
+// subsets of one member fewer, the most zero-sum groups the subset splits
+// into, then walks back from the whole set to recover them. Groups keep the
+// order of positions.
+func zeroSumGroups(positions []position) [][]position {
+	n := len(positions)
+	if n > maxExactSettle {
+		return [][]position{positions}
+	}
+
+	full := 1<<n - 1
+	sums := make([]int64, full+1)
+	groups := make([]int8, full+1)
+	last := make([]int8, full+1)
+	for m := 1; m <= full; m++ {
+		sums[m] = sums[m&(m-1)] + positions[bits.TrailingZeros(uint(m))].cents
+		groups[m] = -1
+		for i := 0; i < n; i++ {
+			if rest := m &^ (1 << i); rest != m && groups[rest] > groups[m] {
+				groups[m], last[m] = groups[rest], int8(i)
+			}
+		}
+		if sums[m] == 0 {
+			groups[m]++
+		}
+	}
+
+	var masks []int
+	for m := full; m != 0; {
+		members := 0
+		for {
+			members |= 1 << last[m]
+			m &^= 1 << last[m]
+			if m == 0 || sums[m] == 0 {
+				break
+			}
+		}
+		masks = append(masks, members)
+	}
+	sort.Slice(masks, func(i, j int) bool { return bits.TrailingZeros(uint(masks[i])) < bits.TrailingZeros(uint(masks[j])) })
+
+	out := make([][]position, len(masks))
+	for g, members := range masks {
+		for i, p := range positions {
+			if members&(1<<i) != 0 {
+				out[g] = append(out[g], p)
+			}
+		}
+	}
+	return out
+}
+
+// settleGreedily repeatedly pays the largest creditor from the largest
+// debtor, which settles at least one member each step and so needs at most
+// len(positions)-1 transfers.
+func settleGreedily(positions []position) []Transfer {
+	var debtors, creditors []position
+	for _, p := range positions {
+		switch {
+		case p.cents < 0:
+			debtors = append(debtors, position{p.user, -p.cents})
+		case p.cents > 0:
+			creditors = append(creditors, p)
+		}
+	}
+
+	transfers := []Transfer{}
+	for len(debtors) > 0 && len(creditors) > 0 {
+		sort.SliceStable(debtors, func(i, j int) bool { return debtors[i].cents > debtors[j].cents })
+		sort.SliceStable(creditors, func(i, j int) bool { return creditors[i].cents > creditors[j].cents })
+
+		d, c := &debtors[0], &creditors[0]
+		amount := d.cents
+		if c.cents < amount {
+			amount = c.cents
+		}
+		transfers = append(transfers, Transfer{From: d.user, To: c.user, Amount: fromCents(amount)})
+
+		d.cents -= amount
+		c.cents -= amount
+		if d.cents == 0 {
+			debtors = debtors[1:]
+		}
+		if c.cents == 0 {
+			creditors = creditors[1:]
+		}
+	}
+	return transfers
+}
@@ -0,0 +1,108 @@
+package groups
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/PatcharaKL/assessment/problem"
+	"github.com/labstack/echo/v4"
+)
+
+func (h *Handler) balances(ctx context.Context, groupID int) ([]Balance, error) {
+	members, err := h.members(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := h.DB.QueryContext(ctx, getBalancesSQL, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := map[string]float64{}
+	for rows.Next() {
+		var user string
+		var amount float64
+		if err := rows.Scan(&user, &amount); err != nil {
+			return nil, err
+		}
+		totals[user] = amount
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	balances := []Balance{}
+	for _, m := range members {
+		balances = append(balances, Balance{User: m, Balance: fromCents(toCents(totals[m]))})
+	}
+	return balances, nil
+}
+
+func (h *Handler) GetBalancesHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	id, err := h.groupFromParam(c)
+	if err != nil {
+		return err
+	}
+
+	balances, err := h.balances(ctx, id)
+	if err != nil {
+		return fmt.Errorf("can't compute balances: %w", err)
+	}
+
+	return c.JSON(http.StatusOK, balances)
+}
+
+func (h *Handler) SettleUpHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	id, err := h.groupFromParam(c)
+	if err != nil {
+		return err
+	}
+
+	balances, err := h.balances(ctx, id)
+	if err != nil {
+		return fmt.Errorf("can't compute balances: %w", err)
+	}
+
+	return c.JSON(http.StatusOK, settleUp(balances))
+}
+
+func (h *Handler) CreateSettlementHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	id, err := h.groupFromParam(c)
+	if err != nil {
+		return err
+	}
+
+	s := Settlement{}
+	if err := c.Bind(&s); err != nil {
+		return err
+	}
+	s.GroupID = id
+	if toCents(s.Amount) <= 0 {
+		return problem.BadRequest("amount must be positive")
+	}
+	// Balances are kept to the cent, like the split lines.
+	s.Amount = fromCents(toCents(s.Amount))
+	if s.From == s.To {
+		return problem.BadRequest("from and to must be different members")
+	}
+
+	members, err := h.members(ctx, id)
+	if err != nil {
+		return fmt.Errorf("can't get group members: %w", err)
+	}
+	if !contains(members, s.From) || !contains(members, s.To) {
+		return problem.BadRequest("from and to must be members of the group")
+	}
+
+	if err := h.DB.QueryRowContext(ctx, createSettlementSQL, s.GroupID, s.From, s.To, s.Amount).Scan(&s.ID, &s.CreatedAt); err != nil {
+		return fmt.Errorf("can't record settlement: %w", err)
+	}
+
+	return c.JSON(http.StatusCreated, s)
+}
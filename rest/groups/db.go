@@ -0,0 +1,25 @@
+package groups
+
+const (
+	createGroupSQL        = "INSERT INTO expense_groups (name, created_by) values ($1, $2) RETURNING id, created_at;"
+	getGroupSQL           = "SELECT name, created_by, created_at FROM expense_groups WHERE id = $1"
+	addMemberSQL          = "INSERT INTO group_members (group_id, username) values ($1, $2) ON CONFLICT DO NOTHING"
+	getMembersSQL         = "SELECT username FROM group_members WHERE group_id = $1 ORDER BY username"
+	isMemberSQL           = "SELECT EXISTS (SELECT 1 FROM group_members WHERE group_id = $1 AND username = $2)"
+	createGroupExpenseSQL = "INSERT INTO expenses (title, amount, note, tags, owner, group_id, paid_by, split_method) values ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id;"
+	createSplitSQL        = "INSERT INTO expense_splits (expense_id, username, amount, percent, shares) values ($1, $2, $3, $4, $5)"
+	getGroupExpensesSQL   = "SELECT id, title, amount, note, tags, paid_by, split_method FROM expenses WHERE group_id = $1 ORDER BY id"
+	createSettlementSQL   = "INSERT INTO group_settlements (group_id, from_user, to_user, amount) values ($1, $2, $3, $4) RETURNING id, created_at;"
+	getBalancesSQL        = `
+	SELECT username, SUM(amount) FROM (
+		SELECT paid_by AS username, amount FROM expenses WHERE group_id = $1
+		UNION ALL
+		SELECT s.username, -s.amount FROM expense_splits s JOIN expenses e ON e.id = s.expense_id WHERE e.group_id = $1
+		UNION ALL
+		SELECT from_user, amount FROM group_settlements WHERE group_id = $1
+		UNION ALL
+		SELECT to_user, -amount FROM group_settlements WHERE group_id = $1
+	) entries GROUP BY username
+	`
+	getGroupSplitsSQL = "SELECT s.expense_id, s.username, s.amount, s.percent, s.shares FROM expense_splits s JOIN expenses e ON e.id = s.expense_id WHERE e.group_id = $1 ORDER BY s.id"
+)
@@ -0,0 +1,117 @@
+package groups
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/PatcharaKL/assessment/problem"
+	"github.com/PatcharaKL/assessment/rest/auth"
+	"github.com/PatcharaKL/assessment/sqlstmt"
+	"github.com/PatcharaKL/assessment/validation"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+func (h *Handler) CreateGroupExpenseHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	id, err := h.groupFromParam(c)
+	if err != nil {
+		return err
+	}
+
+	e := GroupExpense{}
+	if err := c.Bind(&e); err != nil {
+		return err
+	}
+	if err := validation.Struct(e); err != nil {
+		return err
+	}
+	e.GroupID = id
+	if e.PaidBy == "" {
+		e.PaidBy = auth.Username(c)
+	}
+
+	members, err := h.members(ctx, id)
+	if err != nil {
+		return fmt.Errorf("can't get group members: %w", err)
+	}
+	if !contains(members, e.PaidBy) {
+		return problem.BadRequest("paid_by must be a member of the group")
+	}
+	if err := e.Split.Resolve(e.Amount, members); err != nil {
+		return problem.BadRequest("invalid split: " + err.Error())
+	}
+
+	// The expense goes through the expenses service, like any other, so it
+	// has its event, webhook deliveries and cache invalidation.
+	p, _ := auth.PrincipalFrom(c)
+	created, err := h.Expenses.CreateWith(ctx, p, e.Expenses, func(tx sqlstmt.Querier) (int, error) {
+		var expenseID int
+		if err := tx.QueryRowContext(ctx, createGroupExpenseSQL, e.Title, e.Amount, e.Note, pq.Array(e.Tags), p.User, e.GroupID, e.PaidBy, e.Split.Method).Scan(&expenseID); err != nil {
+			return 0, fmt.Errorf("can't create group expense: %w", err)
+		}
+		for _, l := range e.Split.Lines {
+			if _, err := tx.ExecContext(ctx, createSplitSQL, expenseID, l.User, l.Amount, l.Percent, l.Shares); err != nil {
+				return 0, fmt.Errorf("can't create split line: %w", err)
+			}
+		}
+		return expenseID, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	e.Expenses = created
+	return c.JSON(http.StatusCreated, e)
+}
+
+func (h *Handler) GetGroupExpensesHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	id, err := h.groupFromParam(c)
+	if err != nil {
+		return err
+	}
+
+	rows, err := h.DB.QueryContext(ctx, getGroupExpensesSQL, id)
+	if err != nil {
+		return fmt.Errorf("can't query group expenses: %w", err)
+	}
+	defer rows.Close()
+
+	list := []GroupExpense{}
+	index := map[int]int{}
+	for rows.Next() {
+		e := GroupExpense{GroupID: id}
+		if err := rows.Scan(&e.ID, &e.Title, &e.Amount, &e.Note, pq.Array(&e.Tags), &e.PaidBy, &e.Split.Method); err != nil {
+			return fmt.Errorf("can't scan group expense: %w", err)
+		}
+		e.Split.Lines = []SplitLine{}
+		index[e.ID] = len(list)
+		list = append(list, e)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("can't query group expenses: %w", err)
+	}
+
+	splits, err := h.DB.QueryContext(ctx, getGroupSplitsSQL, id)
+	if err != nil {
+		return fmt.Errorf("can't query split lines: %w", err)
+	}
+	defer splits.Close()
+
+	for splits.Next() {
+		var expenseID int
+		l := SplitLine{}
+		if err := splits.Scan(&expenseID, &l.User, &l.Amount, &l.Percent, &l.Shares); err != nil {
+			return fmt.Errorf("can't scan split line: %w", err)
+		}
+		if i, ok := index[expenseID]; ok {
+			list[i].Split.Lines = append(list[i].Split.Lines, l)
+		}
+	}
+	if err := splits.Err(); err != nil {
+		return fmt.Errorf("can't query split lines: %w", err)
+	}
+
+	return c.JSON(http.StatusOK, list)
+}
@@ -0,0 +1,95 @@
+package groups
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/PatcharaKL/assessment/problem"
+	"github.com/PatcharaKL/assessment/rest/auth"
+	"github.com/labstack/echo/v4"
+)
+
+func (h *Handler) CreateGroupHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	g := Group{}
+
+	if err := c.Bind(&g); err != nil {
+		return err
+	}
+	if g.Name == "" {
+		return problem.BadRequest("group name is required")
+	}
+
+	g.CreatedBy = auth.Username(c)
+	if !contains(g.Members, g.CreatedBy) {
+		g.Members = append([]string{g.CreatedBy}, g.Members...)
+	}
+
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("can't begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := tx.QueryRowContext(ctx, createGroupSQL, g.Name, g.CreatedBy).Scan(&g.ID, &g.CreatedAt); err != nil {
+		return fmt.Errorf("can't create group: %w", err)
+	}
+	for _, m := range g.Members {
+		if _, err := tx.ExecContext(ctx, addMemberSQL, g.ID, m); err != nil {
+			return fmt.Errorf("can't add group member: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("can't commit group: %w", err)
+	}
+
+	return c.JSON(http.StatusCreated, g)
+}
+
+func (h *Handler) GetGroupHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	id, err := h.groupFromParam(c)
+	if err != nil {
+		return err
+	}
+
+	g := Group{ID: id}
+	err = h.DB.QueryRowContext(ctx, getGroupSQL, id).Scan(&g.Name, &g.CreatedBy, &g.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return problem.NotFound(fmt.Sprintf("group %d not found", id))
+	}
+	if err != nil {
+		return fmt.Errorf("can't get group: %w", err)
+	}
+	if g.Members, err = h.members(ctx, id); err != nil {
+		return fmt.Errorf("can't get group members: %w", err)
+	}
+
+	return c.JSON(http.StatusOK, g)
+}
+
+func (h *Handler) AddGroupMemberHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	id, err := h.groupFromParam(c)
+	if err != nil {
+		return err
+	}
+
+	m := struct {
+		Username string `json:"username"`
+	}{}
+	if err := c.Bind(&m); err != nil {
+		return err
+	}
+	if m.Username == "" {
+		return problem.BadRequest("username is required")
+	}
+
+	if _, err := h.DB.ExecContext(ctx, addMemberSQL, id, m.Username); err != nil {
+		return fmt.Errorf("can't add group member: %w", err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
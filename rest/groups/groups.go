@@ -0,0 +1,102 @@
+package groups
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/PatcharaKL/assessment/problem"
+	"github.com/PatcharaKL/assessment/rest/auth"
+	"github.com/PatcharaKL/assessment/rest/expenses"
+	"github.com/labstack/echo/v4"
+)
+
+type Group struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Members   []string  `json:"members"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// GroupExpense is an expense paid by one member and split between several.
+type GroupExpense struct {
+	expenses.Expenses
+	GroupID int    `json:"group_id"`
+	PaidBy  string `json:"paid_by"`
+	Split   Split  `json:"split"`
+}
+
+type Handler struct {
+	DB *sql.DB
+	// Expenses stores group expenses, so that they are published and
+	// invalidate the cache like any other expense.
+	Expenses *expenses.Service
+}
+
+func NewApplication(db *sql.DB) *Handler {
+	return &Handler{DB: db, Expenses: expenses.NewService(db)}
+}
+
+// canAccess reports whether the principal is a member of the group. Admins
+// can access every group.
+func (h *Handler) canAccess(c echo.Context, groupID int) (bool, error) {
+	ctx := c.Request().Context()
+	p, _ := auth.PrincipalFrom(c)
+	if p.Role == auth.RoleAdmin {
+		return true, nil
+	}
+
+	var ok bool
+	err := h.DB.QueryRowContext(ctx, isMemberSQL, groupID, p.User).Scan(&ok)
+	return ok, err
+}
+
+// groupFromParam parses the :id parameter and checks that the principal may
+// access the group. Groups the principal can't access are reported as not
+// found, so their ids don't leak.
+func (h *Handler) groupFromParam(c echo.Context) (int, error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		return 0, problem.InvalidID("id", c.Param("id"))
+	}
+
+	allowed, err := h.canAccess(c, id)
+	if err != nil {
+		return 0, fmt.Errorf("can't check group membership: %w", err)
+	}
+	if !allowed {
+		return 0, problem.NotFound(fmt.Sprintf("group %d not found", id))
+	}
+
+	return id, nil
+}
+
+func (h *Handler) members(ctx context.Context, groupID int) ([]string, error) {
+	rows, err := h.DB.QueryContext(ctx, getMembersSQL, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []string{}
+	for rows.Next() {
+		var m string
+		if err := rows.Scan(&m); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+func contains(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
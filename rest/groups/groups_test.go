@@ -0,0 +1,417 @@
+//go:build unit
+// +build unit
+
+package groups
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/PatcharaKL/assessment/cache"
+	"github.com/PatcharaKL/assessment/problem"
+	"github.com/PatcharaKL/assessment/rest/auth"
+	"github.com/PatcharaKL/assessment/rest/expenses"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupTestServer(method, uri string, body *bytes.Buffer) (*httptest.ResponseRecorder, echo.Context) {
+	e := echo.New()
+	req := httptest.NewRequest(method, uri, body)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	auth.SetPrincipal(c, auth.Principal{User: "alice", Role: auth.RoleMember, Scopes: auth.AllScopes})
+	return rec, c
+}
+
+// respond writes err the way the server's error handler does, so that the
+// assertions can check the response whatever the handler returned.
+func respond(c echo.Context, err error) error {
+	if err != nil {
+		problem.HTTPErrorHandler(err, c)
+	}
+	return nil
+}
+
+func TestSplitResolveU(t *testing.T) {
+	members := []string{"alice", "bob", "carol"}
+	tests := []struct {
+		name     string
+		amount   float64
+		split    Split
+		expected []float64
+		wantErr  string
+	}{
+		{
+			name:     "testEqualAllMembers",
+			amount:   100,
+			split:    Split{Method: SplitEqual},
+			expected: []float64{33.34, 33.33, 33.33},
+		},
+		{
+			name:     "testEqualSubset",
+			amount:   79,
+			split:    Split{Method: SplitEqual, Lines: []SplitLine{{User: "bob"}, {User: "carol"}}},
+			expected: []float64{39.5, 39.5},
+		},
+		{
+			name:     "testExact",
+			amount:   89,
+			split:    Split{Method: SplitExact, Lines: []SplitLine{{User: "alice", Amount: 50}, {User: "bob", Amount: 39}}},
+			expected: []float64{50, 39},
+		},
+		{
+			name:    "testExactMismatch",
+			amount:  89,
+			split:   Split{Method: SplitExact, Lines: []SplitLine{{User: "alice", Amount: 50}, {User: "bob", Amount: 30}}},
+			wantErr: "split amounts sum to 80.00, expected 89.00",
+		},
+		{
+			name:     "testPercentage",
+			amount:   10,
+			split:    Split{Method: SplitPercentage, Lines: []SplitLine{{User: "alice", Percent: 33.33}, {User: "bob", Percent: 33.33}, {User: "carol", Percent: 33.34}}},
+			expected: []float64{3.34, 3.33, 3.33},
+		},
+		{
+			name:    "testPercentageNot100",
+			amount:  10,
+			split:   Split{Method: SplitPercentage, Lines: []SplitLine{{User: "alice", Percent: 50}, {User: "bob", Percent: 40}}},
+			wantErr: "split percentages sum to 90.00, expected 100",
+		},
+		{
+			name:     "testShares",
+			amount:   100,
+			split:    Split{Method: SplitShares, Lines: []SplitLine{{User: "alice", Shares: 2}, {User: "bob", Shares: 1}}},
+			expected: []float64{66.67, 33.33},
+		},
+		{
+			name:    "testNonMember",
+			amount:  100,
+			split:   Split{Method: SplitShares, Lines: []SplitLine{{User: "dave", Shares: 1}}},
+			wantErr: `"dave" is not a member of the group`,
+		},
+		{
+			name:    "testUnknownMethod",
+			amount:  100,
+			split:   Split{Method: "random"},
+			wantErr: "unknown split method: random",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Act
+			err := tt.split.Resolve(tt.amount, members)
+
+			// Assert
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			if assert.NoError(t, err) {
+				var sum int64
+				actual := []float64{}
+				for _, l := range tt.split.Lines {
+					actual = append(actual, l.Amount)
+					sum += toCents(l.Amount)
+				}
+				assert.Equal(t, tt.expected, actual)
+				assert.Equal(t, toCents(tt.amount), sum)
+			}
+		})
+	}
+}
+
+// recordingPublisher keeps the events it is told about.
+type recordingPublisher struct {
+	events []expenses.Event
+}
+
+func (r *recordingPublisher) Publish(_ context.Context, e expenses.Event) {
+	r.events = append(r.events, e)
+}
+
+func TestCreateGroupExpenseU(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         *bytes.Buffer
+		expectedCode int
+	}{
+		{
+			name: "testSucceed",
+			body: bytes.NewBufferString(`{
+				"title": "hotel",
+				"amount": 3000,
+				"tags": ["trip"],
+				"split": {"method": "equal"}
+			}`),
+			expectedCode: http.StatusCreated,
+		},
+		{
+			name: "testInvalidSplit",
+			body: bytes.NewBufferString(`{
+				"title": "hotel",
+				"amount": 3000,
+				"split": {"method": "exact", "lines": [{"user": "alice", "amount": 1000}]}
+			}`),
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name: "testInvalidBody",
+			body: bytes.NewBufferString(`{
+				"amount": -1,
+				"split": {"method": "equal"}
+			}`),
+			expectedCode: http.StatusUnprocessableEntity,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			rec, c := setupTestServer(http.MethodPost, "/groups", tt.body)
+			c.SetPath("/groups/:id/expenses")
+			c.SetParamNames("id")
+			c.SetParamValues("7")
+
+			db, mock, _ := sqlmock.New()
+			defer db.Close()
+			mock.ExpectQuery("SELECT EXISTS").WithArgs(7, "alice").
+				WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+			if tt.expectedCode != http.StatusUnprocessableEntity {
+				mock.ExpectQuery("SELECT username FROM group_members").WithArgs(7).
+					WillReturnRows(sqlmock.NewRows([]string{"username"}).AddRow("alice").AddRow("bob"))
+			}
+			if tt.name == "testSucceed" {
+				mock.ExpectBegin()
+				mock.ExpectQuery("INSERT INTO expenses").
+					WithArgs("hotel", 3000.00, "", pq.Array([]string{"trip"}), "alice", 7, "alice", SplitEqual).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(11))
+				mock.ExpectExec("INSERT INTO expense_splits").WithArgs(11, "alice", 1500.00, 0.00, 0).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("INSERT INTO expense_splits").WithArgs(11, "bob", 1500.00, 0.00, 0).WillReturnResult(sqlmock.NewResult(2, 1))
+				mock.ExpectExec("SELECT pg_advisory_xact_lock").WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery("INSERT INTO outbox").WithArgs(expenses.EventCreated, "alice", sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(5, time.Now()))
+				mock.ExpectCommit()
+			}
+			h := NewApplication(db)
+			events := &recordingPublisher{}
+			h.Expenses.Events = events
+
+			// Act
+			err := respond(c, h.CreateGroupExpenseHandler(c))
+
+			// Assert
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.expectedCode, rec.Code)
+				if tt.expectedCode >= http.StatusBadRequest {
+					assert.Equal(t, problem.MIMEApplicationProblemJSON, rec.Header().Get(echo.HeaderContentType))
+				}
+				assert.NoError(t, mock.ExpectationsWereMet())
+			}
+			if tt.expectedCode != http.StatusCreated {
+				assert.Empty(t, events.events)
+			} else if assert.Len(t, events.events, 1) {
+				assert.Equal(t, expenses.EventCreated, events.events[0].Type)
+				assert.Equal(t, int64(5), events.events[0].ID)
+				assert.Equal(t, 11, events.events[0].Expense.ID)
+			}
+		})
+	}
+}
+
+func TestCreateGroupExpenseInvalidatesCacheU(t *testing.T) {
+	// Arrange
+	_, c := setupTestServer(http.MethodPost, "/groups", bytes.NewBufferString(`{"title": "hotel", "amount": 3000, "split": {"method": "equal"}}`))
+	c.SetPath("/groups/:id/expenses")
+	c.SetParamNames("id")
+	c.SetParamValues("7")
+	p, _ := auth.PrincipalFrom(c)
+	ctx := context.Background()
+
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	columns := []string{"id", "title", "amount", "note", "tags", "version", "updated_at"}
+	mock.ExpectQuery("SELECT id, title, amount, note, tags, version, updated_at FROM expenses").WithArgs("alice").
+		WillReturnRows(sqlmock.NewRows(columns))
+	mock.ExpectQuery("SELECT EXISTS").WithArgs(7, "alice").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery("SELECT username FROM group_members").WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"username"}).AddRow("alice"))
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO expenses").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(11))
+	mock.ExpectExec("INSERT INTO expense_splits").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("INSERT INTO outbox").WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(5, time.Now()))
+	mock.ExpectCommit()
+	mock.ExpectQuery("SELECT id, title, amount, note, tags, version, updated_at FROM expenses").WithArgs("alice").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(11, "hotel", 3000, "", "{}", 1, time.Now()))
+	h := NewApplication(db)
+	h.Expenses.Cache = expenses.NewCache(cache.NewLRU(100), time.Minute, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	h.Expenses.List(ctx, p)
+
+	// Act
+	err := h.CreateGroupExpenseHandler(c)
+	list, listErr := h.Expenses.List(ctx, p)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.NoError(t, listErr)
+	assert.Len(t, list, 1, "the writer reads its own write")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetGroupNotMemberU(t *testing.T) {
+	// Arrange
+	rec, c := setupTestServer(http.MethodGet, "/groups", bytes.NewBufferString(``))
+	c.SetPath("/groups/:id")
+	c.SetParamNames("id")
+	c.SetParamValues("7")
+
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	mock.ExpectQuery("SELECT EXISTS").WithArgs(7, "alice").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	h := NewApplication(db)
+
+	// Act
+	err := respond(c, h.GetGroupHandler(c))
+
+	// Assert
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	}
+}
+
+func TestSettleUpU(t *testing.T) {
+	tests := []struct {
+		name     string
+		balances []Balance
+		expected []Transfer
+	}{
+		{
+			name:     "testSettled",
+			balances: []Balance{{"alice", 0}, {"bob", 0}},
+			expected: []Transfer{},
+		},
+		{
+			name:     "testOneCreditor",
+			balances: []Balance{{"alice", 2000}, {"bob", -1000}, {"carol", -1000}},
+			expected: []Transfer{{From: "bob", To: "alice", Amount: 1000}, {From: "carol", To: "alice", Amount: 1000}},
+		},
+		{
+			name:     "testChainCollapses",
+			balances: []Balance{{"alice", 30.5}, {"bob", -10}, {"carol", -20.5}},
+			expected: []Transfer{{From: "carol", To: "alice", Amount: 20.5}, {From: "bob", To: "alice", Amount: 10}},
+		},
+		{
+			// Paying the largest creditor from the largest debtor takes five
+			// transfers here; bob and frank cancel out and settle apart.
+			name:     "testFewestTransfers",
+			balances: []Balance{{"alice", -9}, {"bob", 7}, {"carol", -2}, {"dave", 5}, {"erin", 6}, {"frank", -7}},
+			expected: []Transfer{
+				{From: "alice", To: "erin", Amount: 6},
+				{From: "alice", To: "dave", Amount: 3},
+				{From: "carol", To: "dave", Amount: 2},
+				{From: "frank", To: "bob", Amount: 7},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Act
+			actual := settleUp(tt.balances)
+
+			// Assert
+			assert.Equal(t, tt.expected, actual)
+		})
+	}
+}
+
+func TestSettleUpLargeGroupU(t *testing.T) {
+	// Arrange
+	balances := []Balance{}
+	for i := 0; i < maxExactSettle+4; i++ {
+		balances = append(balances, Balance{User: fmt.Sprintf("member%02d", i), Balance: float64(i%5) - 2})
+	}
+
+	// Act
+	transfers := settleUp(balances)
+
+	// Assert
+	net := map[string]int64{}
+	for _, b := range balances {
+		net[b.User] = toCents(b.Balance)
+	}
+	for _, tr := range transfers {
+		net[tr.From] += toCents(tr.Amount)
+		net[tr.To] -= toCents(tr.Amount)
+	}
+	for user, cents := range net {
+		assert.Zero(t, cents, user)
+	}
+	assert.LessOrEqual(t, len(transfers), len(balances)-1)
+}
+
+func TestGetBalancesU(t *testing.T) {
+	// Arrange
+	rec, c := setupTestServer(http.MethodGet, "/groups", bytes.NewBufferString(``))
+	c.SetPath("/groups/:id/balances")
+	c.SetParamNames("id")
+	c.SetParamValues("7")
+
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	mock.ExpectQuery("SELECT EXISTS").WithArgs(7, "alice").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery("SELECT username FROM group_members").WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"username"}).AddRow("alice").AddRow("bob").AddRow("carol"))
+	mock.ExpectQuery("SELECT username, SUM\\(amount\\)").WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"username", "sum"}).AddRow("alice", 1500.0000001).AddRow("bob", -1500.0))
+	h := NewApplication(db)
+
+	// Act
+	err := respond(c, h.GetBalancesHandler(c))
+
+	// Assert
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `[{"user":"alice","balance":1500},{"user":"bob","balance":-1500},{"user":"carol","balance":0}]`, rec.Body.String())
+	}
+}
+
+func TestCreateSettlementRoundsToCentsU(t *testing.T) {
+	// Arrange
+	rec, c := setupTestServer(http.MethodPost, "/groups", bytes.NewBufferString(`{"from": "bob", "to": "alice", "amount": 10.004}`))
+	c.SetPath("/groups/:id/settlements")
+	c.SetParamNames("id")
+	c.SetParamValues("7")
+
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	mock.ExpectQuery("SELECT EXISTS").WithArgs(7, "alice").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery("SELECT username FROM group_members").WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"username"}).AddRow("alice").AddRow("bob"))
+	mock.ExpectQuery("INSERT INTO group_settlements").WithArgs(7, "bob", "alice", 10.0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+	h := NewApplication(db)
+
+	// Act
+	err := respond(c, h.CreateSettlementHandler(c))
+
+	// Assert
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"amount":10,`)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
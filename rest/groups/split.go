@@ -0,0 +1,138 @@
+package groups
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	SplitEqual      = "equal"
+	SplitExact      = "exact"
+	SplitPercentage = "percentage"
+	SplitShares     = "shares"
+)
+
+type SplitLine struct {
+	User    string  `json:"user"`
+	Amount  float64 `json:"amount"`
+	Percent float64 `json:"percent,omitempty"`
+	Shares  int     `json:"shares,omitempty"`
+}
+
+type Split struct {
+	Method string      `json:"method"`
+	Lines  []SplitLine `json:"lines"`
+}
+
+func toCents(v float64) int64 {
+	return int64(math.Round(v * 100))
+}
+
+func fromCents(c int64) float64 {
+	return float64(c) / 100
+}
+
+// Resolve validates the split against the group members and fills in each
+// line's amount. Amounts are computed in cents, and any remainder left by
+// rounding goes one cent at a time to the first lines, so the lines always
+// sum exactly to amount.
+func (s *Split) Resolve(amount float64, members []string) error {
+	total := toCents(amount)
+	if total <= 0 {
+		return fmt.Errorf("amount must be positive")
+	}
+
+	switch s.Method {
+	case "":
+		s.Method = SplitEqual
+	case SplitEqual, SplitExact, SplitPercentage, SplitShares:
+	default:
+		return fmt.Errorf("unknown split method: %s", s.Method)
+	}
+	if s.Method == SplitEqual && len(s.Lines) == 0 {
+		for _, m := range members {
+			s.Lines = append(s.Lines, SplitLine{User: m})
+		}
+	}
+	if len(s.Lines) == 0 {
+		return fmt.Errorf("split has no lines")
+	}
+
+	seen := map[string]bool{}
+	for _, l := range s.Lines {
+		if !contains(members, l.User) {
+			return fmt.Errorf("%q is not a member of the group", l.User)
+		}
+		if seen[l.User] {
+			return fmt.Errorf("%q appears more than once in the split", l.User)
+		}
+		seen[l.User] = true
+	}
+
+	weights := make([]int64, len(s.Lines))
+	switch s.Method {
+	case SplitEqual:
+		for i := range weights {
+			weights[i] = 1
+		}
+	case SplitExact:
+		var sum int64
+		for i, l := range s.Lines {
+			weights[i] = toCents(l.Amount)
+			if weights[i] < 0 {
+				return fmt.Errorf("split amount for %q must not be negative", l.User)
+			}
+			sum += weights[i]
+		}
+		if sum != total {
+			return fmt.Errorf("split amounts sum to %.2f, expected %.2f", fromCents(sum), fromCents(total))
+		}
+	case SplitPercentage:
+		var sum int64
+		for i, l := range s.Lines {
+			weights[i] = toCents(l.Percent)
+			if weights[i] < 0 {
+				return fmt.Errorf("split percent for %q must not be negative", l.User)
+			}
+			sum += weights[i]
+		}
+		if sum != 100*100 {
+			return fmt.Errorf("split percentages sum to %.2f, expected 100", fromCents(sum))
+		}
+	case SplitShares:
+		for i, l := range s.Lines {
+			if l.Shares <= 0 {
+				return fmt.Errorf("split shares for %q must be positive", l.User)
+			}
+			weights[i] = int64(l.Shares)
+		}
+	}
+
+	for i, c := range distribute(total, weights) {
+		s.Lines[i].Amount = fromCents(c)
+	}
+	return nil
+}
+
+// distribute divides total in proportion to weights and hands the rounding
+// remainder to the first entries.
+func distribute(total int64, weights []int64) []int64 {
+	var sum int64
+	for _, w := range weights {
+		sum += w
+	}
+
+	out := make([]int64, len(weights))
+	var allocated int64
+	for i, w := range weights {
+		out[i] = total * w / sum
+		allocated += out[i]
+	}
+	for i := 0; allocated < total; i = (i + 1) % len(out) {
+		if weights[i] > 0 {
+			out[i]++
+			allocated++
+		}
+	}
+	return out
+}
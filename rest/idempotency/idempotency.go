@@ -0,0 +1,176 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/PatcharaKL/assessment/logging"
+	"github.com/PatcharaKL/assessment/problem"
+	"github.com/PatcharaKL/assessment/rest/auth"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	HeaderIdempotencyKey = "Idempotency-Key"
+
+	deleteExpiredSQL = "DELETE FROM idempotency_keys WHERE owner = $1 AND key = $2 AND expires_at < now()"
+	reserveKeySQL    = "INSERT INTO idempotency_keys (owner, key, request_hash, expires_at) values ($1, $2, $3, $4) ON CONFLICT DO NOTHING"
+	getKeySQL        = "SELECT request_hash, status_code, content_type, response_body FROM idempotency_keys WHERE owner = $1 AND key = $2"
+	saveResponseSQL  = "UPDATE idempotency_keys SET status_code = $3, content_type = $4, response_body = $5 WHERE owner = $1 AND key = $2"
+	releaseKeySQL    = "DELETE FROM idempotency_keys WHERE owner = $1 AND key = $2"
+	purgeExpiredSQL  = "DELETE FROM idempotency_keys WHERE expires_at < now()"
+
+	// sweepInterval is how often Run deletes expired keys. An expired key
+	// is already ignored by the next request that uses it, so the sweep only
+	// bounds the size of the table.
+	sweepInterval = time.Hour
+
+	CodeKeyReused     problem.Code = "idempotency_key_reused"
+	CodeKeyInProgress problem.Code = "idempotency_key_in_progress"
+)
+
+// Store remembers the response to each Idempotency-Key so that a retried
+// request is answered with the original response instead of running again.
+type Store struct {
+	DB  *sql.DB
+	TTL time.Duration
+}
+
+func New(db *sql.DB, ttl time.Duration) *Store {
+	return &Store{db, ttl}
+}
+
+type recorder struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// Middleware honors the Idempotency-Key header. Keys are scoped to the
+// authenticated user; reusing a key with a different request body is
+// rejected with 422, and a key whose first request is still running gets 409.
+// Server errors are not stored, so the client may retry them.
+func (s *Store) Middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		key := c.Request().Header.Get(HeaderIdempotencyKey)
+		if key == "" {
+			return next(c)
+		}
+		owner := auth.Username(c)
+		ctx := c.Request().Context()
+
+		body, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return problem.BadRequest("can't read request body: " + err.Error())
+		}
+		c.Request().Body = io.NopCloser(bytes.NewReader(body))
+		hash := requestHash(c.Request().Method, c.Path(), body)
+
+		if _, err := s.DB.ExecContext(ctx, deleteExpiredSQL, owner, key); err != nil {
+			return fmt.Errorf("can't expire idempotency key: %w", err)
+		}
+		res, err := s.DB.ExecContext(ctx, reserveKeySQL, owner, key, hash, time.Now().Add(s.TTL))
+		if err != nil {
+			return fmt.Errorf("can't reserve idempotency key: %w", err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return s.replay(c, owner, key, hash)
+		}
+
+		// The key is released or completed even if the client has gone or
+		// the handler panics, or it would answer 409 to every retry until it
+		// expires.
+		done := context.WithoutCancel(ctx)
+		defer func() {
+			if r := recover(); r != nil {
+				if _, err := s.DB.ExecContext(done, releaseKeySQL, owner, key); err != nil {
+					slog.ErrorContext(ctx, "can't release idempotency key", append(logging.Attrs(ctx), slog.String("error", err.Error()))...)
+				}
+				panic(r)
+			}
+		}()
+
+		rec := &recorder{ResponseWriter: c.Response().Writer}
+		c.Response().Writer = rec
+		if err := next(c); err != nil {
+			c.Error(err)
+		}
+
+		status := c.Response().Status
+		if status >= http.StatusInternalServerError {
+			_, err = s.DB.ExecContext(done, releaseKeySQL, owner, key)
+		} else {
+			_, err = s.DB.ExecContext(done, saveResponseSQL, owner, key, status, c.Response().Header().Get(echo.HeaderContentType), rec.body.Bytes())
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "can't store idempotent response", append(logging.Attrs(ctx), slog.String("error", err.Error()))...)
+		}
+		return nil
+	}
+}
+
+// Sweep deletes the expired keys of every user and returns how many there
+// were.
+func (s *Store) Sweep(ctx context.Context) (int64, error) {
+	res, err := s.DB.ExecContext(ctx, purgeExpiredSQL)
+	if err != nil {
+		return 0, fmt.Errorf("can't delete expired idempotency keys: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// Run sweeps expired keys every sweepInterval until ctx is cancelled.
+func (s *Store) Run(ctx context.Context, logger *slog.Logger) {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if _, err := s.Sweep(ctx); err != nil && ctx.Err() == nil {
+			logger.Error("can't sweep idempotency keys", "error", err)
+		}
+	}
+}
+
+func (s *Store) replay(c echo.Context, owner, key, hash string) error {
+	ctx := c.Request().Context()
+	var storedHash string
+	var status sql.NullInt64
+	var contentType sql.NullString
+	var body []byte
+
+	err := s.DB.QueryRowContext(ctx, getKeySQL, owner, key).Scan(&storedHash, &status, &contentType, &body)
+	if err != nil {
+		return fmt.Errorf("can't load idempotency key: %w", err)
+	}
+	if storedHash != hash {
+		return problem.New(http.StatusUnprocessableEntity, CodeKeyReused, "Idempotency-Key was already used with a different request")
+	}
+	if !status.Valid {
+		return problem.New(http.StatusConflict, CodeKeyInProgress, "a request with this Idempotency-Key is still in progress")
+	}
+
+	return c.Blob(int(status.Int64), contentType.String, body)
+}
+
+func requestHash(method, path string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method + " " + path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,207 @@
+//go:build unit
+// +build unit
+
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/PatcharaKL/assessment/problem"
+	"github.com/PatcharaKL/assessment/rest/auth"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+const body = `{"title": "strawberry smoothie", "amount": 79}`
+
+func setupTestServer(key string) (*httptest.ResponseRecorder, echo.Context) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/expenses", bytes.NewBufferString(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if key != "" {
+		req.Header.Set(HeaderIdempotencyKey, key)
+	}
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/expenses")
+	auth.SetPrincipal(c, auth.Principal{User: "Patchara", Role: auth.RoleMember})
+	return rec, c
+}
+
+// respond writes err the way the server's error handler does, so that the
+// assertions can check the response whatever the handler returned.
+func respond(c echo.Context, err error) error {
+	if err != nil {
+		problem.HTTPErrorHandler(err, c)
+	}
+	return nil
+}
+
+func TestMiddlewareU(t *testing.T) {
+	stored := []byte(`{"id":1,"title":"strawberry smoothie"}`)
+
+	tests := []struct {
+		name         string
+		key          string
+		setup        func(mock sqlmock.Sqlmock)
+		expectedCode int
+		expectedBody string
+		handlerCalls int
+	}{
+		{
+			name:         "testNoKey",
+			setup:        func(mock sqlmock.Sqlmock) {},
+			expectedCode: http.StatusCreated,
+			expectedBody: `{"id":1}`,
+			handlerCalls: 1,
+		},
+		{
+			name: "testFirstRequest",
+			key:  "abc",
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec("DELETE FROM idempotency_keys").WithArgs("Patchara", "abc").WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec("INSERT INTO idempotency_keys").WithArgs("Patchara", "abc", requestHash(http.MethodPost, "/expenses", []byte(body)), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("UPDATE idempotency_keys").WithArgs("Patchara", "abc", http.StatusCreated, echo.MIMEApplicationJSONCharsetUTF8, []byte("{\"id\":1}\n")).WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			expectedCode: http.StatusCreated,
+			expectedBody: `{"id":1}`,
+			handlerCalls: 1,
+		},
+		{
+			name: "testRetryReplays",
+			key:  "abc",
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec("DELETE FROM idempotency_keys").WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec("INSERT INTO idempotency_keys").WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery("SELECT request_hash").WithArgs("Patchara", "abc").
+					WillReturnRows(sqlmock.NewRows([]string{"request_hash", "status_code", "content_type", "response_body"}).
+						AddRow(requestHash(http.MethodPost, "/expenses", []byte(body)), http.StatusCreated, echo.MIMEApplicationJSON, stored))
+			},
+			expectedCode: http.StatusCreated,
+			expectedBody: string(stored),
+		},
+		{
+			name: "testDifferentBody",
+			key:  "abc",
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec("DELETE FROM idempotency_keys").WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec("INSERT INTO idempotency_keys").WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery("SELECT request_hash").
+					WillReturnRows(sqlmock.NewRows([]string{"request_hash", "status_code", "content_type", "response_body"}).
+						AddRow("other", http.StatusCreated, echo.MIMEApplicationJSON, stored))
+			},
+			expectedCode: http.StatusUnprocessableEntity,
+			expectedBody: `{"type":"about:blank","title":"Unprocessable Entity","status":422,"detail":"Idempotency-Key was already used with a different request","instance":"/expenses","code":"idempotency_key_reused"}`,
+		},
+		{
+			name: "testInProgress",
+			key:  "abc",
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec("DELETE FROM idempotency_keys").WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec("INSERT INTO idempotency_keys").WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery("SELECT request_hash").
+					WillReturnRows(sqlmock.NewRows([]string{"request_hash", "status_code", "content_type", "response_body"}).
+						AddRow(requestHash(http.MethodPost, "/expenses", []byte(body)), nil, nil, nil))
+			},
+			expectedCode: http.StatusConflict,
+			expectedBody: `{"type":"about:blank","title":"Conflict","status":409,"detail":"a request with this Idempotency-Key is still in progress","instance":"/expenses","code":"idempotency_key_in_progress"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			rec, c := setupTestServer(tt.key)
+			db, mock, _ := sqlmock.New()
+			defer db.Close()
+			tt.setup(mock)
+			calls := 0
+			next := func(c echo.Context) error {
+				calls++
+				return c.JSON(http.StatusCreated, map[string]int{"id": 1})
+			}
+			s := New(db, time.Hour)
+
+			// Act
+			err := respond(c, s.Middleware(next)(c))
+
+			// Assert
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.expectedCode, rec.Code)
+				assert.Equal(t, tt.expectedBody, strings.TrimSpace(rec.Body.String()))
+				assert.Equal(t, tt.handlerCalls, calls)
+				assert.NoError(t, mock.ExpectationsWereMet())
+			}
+		})
+	}
+}
+
+func TestMiddlewareClientGoneU(t *testing.T) {
+	// Arrange
+	rec, c := setupTestServer("abc")
+	ctx, cancel := context.WithCancel(c.Request().Context())
+	c.SetRequest(c.Request().WithContext(ctx))
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	mock.ExpectExec("DELETE FROM idempotency_keys").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO idempotency_keys").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE idempotency_keys").WithArgs("Patchara", "abc", http.StatusCreated, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	next := func(c echo.Context) error {
+		cancel()
+		return c.JSON(http.StatusCreated, map[string]int{"id": 1})
+	}
+	s := New(db, time.Hour)
+
+	// Act
+	err := respond(c, s.Middleware(next)(c))
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.NoError(t, mock.ExpectationsWereMet(), "the response is stored after the client disconnects")
+}
+
+func TestMiddlewarePanicU(t *testing.T) {
+	// Arrange
+	_, c := setupTestServer("abc")
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	mock.ExpectExec("DELETE FROM idempotency_keys").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO idempotency_keys").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(releaseKeySQL)).WithArgs("Patchara", "abc").WillReturnResult(sqlmock.NewResult(0, 1))
+	next := func(c echo.Context) error {
+		panic("nil map")
+	}
+	s := New(db, time.Hour)
+
+	// Act
+	act := func() { s.Middleware(next)(c) }
+
+	// Assert
+	assert.PanicsWithValue(t, "nil map", act, "the panic is left to the Recover middleware")
+	assert.NoError(t, mock.ExpectationsWereMet(), "the key is released so that a retry can run")
+}
+
+func TestSweepU(t *testing.T) {
+	// Arrange
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	mock.ExpectExec(regexp.QuoteMeta(purgeExpiredSQL)).WillReturnResult(sqlmock.NewResult(0, 3))
+	s := New(db, time.Hour)
+
+	// Act
+	n, err := s.Sweep(context.Background())
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,70 @@
+package webhooks
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"syscall"
+)
+
+// Deliveries are sent from inside the server's network, so a webhook must
+// not reach into it: every address a receiver resolves to has to be public.
+// That rules out loopback, private (RFC 1918 and unique local), link-local,
+// which holds the 169.254.169.254 metadata endpoint, shared (RFC 6598),
+// unspecified and multicast addresses.
+var notPublic = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+}
+
+// lookupIP resolves the hosts of webhook URLs.
+var lookupIP = net.DefaultResolver.LookupNetIP
+
+func publicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+	for _, p := range notPublic {
+		if p.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkHost returns why deliveries can't be sent to host, or "" when they
+// can.
+func checkHost(ctx context.Context, host string) string {
+	addrs := []netip.Addr{}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		addrs = append(addrs, ip)
+	} else if addrs, err = lookupIP(ctx, "ip", host); err != nil {
+		return "host can't be resolved"
+	}
+	for _, ip := range addrs {
+		if !publicAddr(ip) {
+			return "must not point at a loopback, private or link-local address"
+		}
+	}
+	return ""
+}
+
+// dialPublic is the Control of the dispatcher's dialer. It sees the address
+// after DNS, so a host that pointed at a public address when it was
+// registered can't be pointed into the network later.
+func dialPublic(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if !publicAddr(ip) {
+		return fmt.Errorf("webhook address %s is not public", ip)
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package webhooks
+
+const (
+	createWebhookSQL = "INSERT INTO webhooks (owner, url, events, secret) values ($1, $2, $3, $4) RETURNING id, created_at;"
+	getWebhooksSQL   = "SELECT id, url, events, created_at FROM webhooks WHERE owner = $1 ORDER BY id"
+	deleteWebhookSQL = "DELETE FROM webhooks WHERE id = $1 AND owner = $2"
+	ownsWebhookSQL   = "SELECT EXISTS (SELECT 1 FROM webhooks WHERE id = $1 AND owner = $2)"
+
+	// enqueueSQL adds a delivery for every webhook of the owner that
+	// subscribes to the event; an empty filter subscribes to all events.
+	enqueueSQL = "INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, payload)" +
+		" SELECT id, $2, $3, $4 FROM webhooks WHERE owner = $1 AND (events = '{}' OR $3 = ANY (events))"
+
+	getDeliveriesSQL = "SELECT id, event_id, event_type, status, attempts, next_attempt_at, last_status, last_error, created_at, delivered_at" +
+		" FROM webhook_deliveries WHERE webhook_id = $1 ORDER BY id DESC LIMIT $2"
+	redeliverSQL = "UPDATE webhook_deliveries SET status = 'pending', attempts = 0, next_attempt_at = now()" +
+		" WHERE id = $1 AND webhook_id = $2" +
+		" RETURNING id, event_id, event_type, status, attempts, next_attempt_at, last_status, last_error, created_at, delivered_at"
+
+	// claimSQL takes the next due delivery and pushes its next attempt past
+	// the lease, so other replicas skip it while it is being sent. A replica
+	// that dies mid-delivery leaves it to be retried after the lease.
+	claimSQL = "WITH due AS (" +
+		" SELECT id FROM webhook_deliveries WHERE status = 'pending' AND next_attempt_at <= now()" +
+		" ORDER BY next_attempt_at LIMIT 1 FOR UPDATE SKIP LOCKED)" +
+		" UPDATE webhook_deliveries d SET next_attempt_at = now() + make_interval(secs => $1)" +
+		" FROM due, webhooks w WHERE d.id = due.id AND w.id = d.webhook_id" +
+		" RETURNING d.id, d.webhook_id, d.event_id, d.event_type, d.payload, d.attempts, w.url, w.secret"
+	deliveredSQL = "UPDATE webhook_deliveries SET status = 'delivered', attempts = attempts + 1, last_status = $2, last_error = '', delivered_at = now() WHERE id = $1"
+	failedSQL    = "UPDATE webhook_deliveries SET status = $2, attempts = attempts + 1, last_status = $3, last_error = $4," +
+		" next_attempt_at = now() + make_interval(secs => $5) WHERE id = $1"
+)
@@ -0,0 +1,269 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/PatcharaKL/assessment/rest/expenses"
+	"github.com/PatcharaKL/assessment/sqlstmt"
+)
+
+// Every delivery is a POST of a Payload with these headers. The signature
+// is "sha256=" and the hex HMAC-SHA256, keyed with the webhook's secret, of
+// the timestamp, a '.' and the body. Receivers should recompute it, compare
+// in constant time and reject old timestamps to stop replays.
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+
+	signaturePrefix = "sha256="
+	// errorLimit caps the error kept for the deliveries list.
+	errorLimit = 500
+	// drainLimit is how much of a response is read, and thrown away, so
+	// that its connection can be reused.
+	drainLimit = 4096
+)
+
+// Payload is the body of a delivery. ID identifies the event, by its
+// position in the outbox: every delivery and redelivery of it carries the
+// same ID.
+type Payload struct {
+	ID        string            `json:"id"`
+	Type      string            `json:"type"`
+	CreatedAt time.Time         `json:"created_at"`
+	Data      expenses.Expenses `json:"data"`
+}
+
+// Sign returns the signature header value for a body sent at timestamp, in
+// Unix seconds.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is the one Sign gives for the body.
+func Verify(secret string, timestamp int64, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+// Dispatcher queues deliveries for expense events and sends them. It is the
+// expenses.Recorder and one of the expenses.Publishers of the server; Run
+// sends in the background.
+type Dispatcher struct {
+	DB     *sql.DB
+	Client *http.Client
+	Logger *slog.Logger
+	// MaxAttempts is how many times a delivery is tried before it is dead.
+	MaxAttempts int
+	// RetryBase is the wait after the first failed attempt. It doubles with
+	// every further failure, up to RetryMax.
+	RetryBase time.Duration
+	RetryMax  time.Duration
+	// Interval is how often Run looks for deliveries that are due.
+	Interval time.Duration
+	// BatchSize is how many deliveries DeliverDue sends before it returns.
+	BatchSize int
+
+	wake chan struct{}
+}
+
+func NewDispatcher(db *sql.DB, maxAttempts int, timeout time.Duration, logger *slog.Logger) *Dispatcher {
+	// Receivers are dialled directly, never through a proxy, so that
+	// dialPublic sees their addresses.
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = (&net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second, Control: dialPublic}).DialContext
+	return &Dispatcher{
+		DB:          db,
+		Client:      &http.Client{Timeout: timeout, Transport: transport},
+		Logger:      logger,
+		MaxAttempts: maxAttempts,
+		RetryBase:   30 * time.Second,
+		RetryMax:    6 * time.Hour,
+		Interval:    5 * time.Second,
+		BatchSize:   50,
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// Record queues a delivery of e to each of the owner's webhooks that
+// subscribe to it. It runs in the transaction that makes the change, so a
+// committed change always has its deliveries.
+func (d *Dispatcher) Record(ctx context.Context, tx sqlstmt.Querier, e expenses.Event) error {
+	id := "evt_" + strconv.FormatInt(e.ID, 10)
+	body, err := json.Marshal(Payload{ID: id, Type: e.Type, CreatedAt: e.Time.UTC(), Data: e.Expense})
+	if err != nil {
+		return fmt.Errorf("can't queue webhook deliveries: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, enqueueSQL, e.Owner, id, e.Type, body); err != nil {
+		return fmt.Errorf("can't queue webhook deliveries: %w", err)
+	}
+	return nil
+}
+
+// Publish wakes Run to send the deliveries Record queued, now that they are
+// committed.
+func (d *Dispatcher) Publish(context.Context, expenses.Event) {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run sends due deliveries every Interval, and as soon as a change is
+// published, until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+		for {
+			n, err := d.DeliverDue(ctx)
+			if err != nil && ctx.Err() == nil {
+				d.Logger.Error("can't send webhook deliveries", "error", err)
+			}
+			if err != nil || n < d.BatchSize {
+				break
+			}
+		}
+	}
+}
+
+type claimed struct {
+	id, webhookID     int
+	eventID, typ, url string
+	secret            string
+	payload           []byte
+	attempts          int
+}
+
+// DeliverDue sends up to BatchSize deliveries that are due and records the
+// outcome of each. It returns how many it claimed. Deliveries are claimed one
+// at a time, just before they are sent, so the lease only has to cover one
+// send.
+func (d *Dispatcher) DeliverDue(ctx context.Context) (int, error) {
+	n := 0
+	for n < d.BatchSize {
+		c, err := d.claim(ctx)
+		if errors.Is(err, sql.ErrNoRows) {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		n++
+
+		status, sendErr := d.send(ctx, c)
+		if ctx.Err() != nil {
+			// Shutting down: the lease expires and another attempt is made.
+			return n, ctx.Err()
+		}
+		if err := d.record(ctx, c, status, sendErr); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// claim takes the next due delivery for the time it takes to send it.
+func (d *Dispatcher) claim(ctx context.Context) (claimed, error) {
+	lease := d.Client.Timeout + 30*time.Second
+	c := claimed{}
+	err := d.DB.QueryRowContext(ctx, claimSQL, lease.Seconds()).
+		Scan(&c.id, &c.webhookID, &c.eventID, &c.typ, &c.payload, &c.attempts, &c.url, &c.secret)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return c, fmt.Errorf("can't claim webhook delivery: %w", err)
+	}
+	return c, err
+}
+
+// send POSTs the delivery and returns the response status, 0 when there was
+// no response.
+func (d *Dispatcher) send(ctx context.Context, c claimed) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(c.payload))
+	if err != nil {
+		return 0, err
+	}
+	ts := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "expenses-webhooks/1")
+	req.Header.Set(HeaderEvent, c.typ)
+	req.Header.Set(HeaderDelivery, strconv.Itoa(c.id))
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(ts, 10))
+	req.Header.Set(HeaderSignature, Sign(c.secret, ts, c.payload))
+
+	res, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	// The body is not kept: the deliveries list would show whatever the
+	// receiver's address answers to whoever registered it.
+	io.Copy(io.Discard, io.LimitReader(res.Body, drainLimit))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("receiver answered %s", res.Status)
+	}
+	return res.StatusCode, nil
+}
+
+func (d *Dispatcher) record(ctx context.Context, c claimed, status int, sendErr error) error {
+	if sendErr == nil {
+		if _, err := d.DB.ExecContext(ctx, deliveredSQL, c.id, status); err != nil {
+			return fmt.Errorf("can't record webhook delivery: %w", err)
+		}
+		return nil
+	}
+
+	attempts := c.attempts + 1
+	state := StatusPending
+	if attempts >= d.MaxAttempts {
+		state = StatusDead
+	}
+	lastStatus := sql.NullInt64{Int64: int64(status), Valid: status != 0}
+	msg := sendErr.Error()
+	if len(msg) > errorLimit {
+		msg = msg[:errorLimit]
+	}
+	d.Logger.WarnContext(ctx, "webhook delivery failed",
+		"delivery", c.id, "webhook", c.webhookID, "attempt", attempts, "state", state, "error", msg)
+
+	if _, err := d.DB.ExecContext(ctx, failedSQL, c.id, state, lastStatus, msg, d.backoff(attempts).Seconds()); err != nil {
+		return fmt.Errorf("can't record webhook delivery: %w", err)
+	}
+	return nil
+}
+
+// backoff is the wait after the given number of failed attempts.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	wait := d.RetryBase
+	for i := 1; i < attempts && wait < d.RetryMax; i++ {
+		wait *= 2
+	}
+	if wait > d.RetryMax {
+		wait = d.RetryMax
+	}
+	return wait
+}
@@ -0,0 +1,286 @@
+// Package webhooks tells other systems about expense changes. Users
+// subscribe a URL to some or all events; every change is queued as one
+// delivery per subscription and POSTed, signed, by the Dispatcher, which
+// retries failures with exponential backoff until they are delivered or
+// given up as dead.
+package webhooks
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/PatcharaKL/assessment/problem"
+	"github.com/PatcharaKL/assessment/rest/auth"
+	"github.com/PatcharaKL/assessment/rest/expenses"
+	"github.com/PatcharaKL/assessment/validation"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	// StatusDead is a delivery that failed every attempt. It stays dead
+	// until it is redelivered.
+	StatusDead = "dead"
+
+	secretPrefix = "whsec_"
+	// deliveriesLimit is how many of the latest deliveries are listed.
+	deliveriesLimit = 100
+)
+
+type Webhook struct {
+	ID  int    `json:"id"`
+	URL string `json:"url" validate:"required,max=2000"`
+	// Events filters which events are delivered; empty means all of them.
+	Events []string `json:"events" validate:"max=20,dive,required"`
+	// Secret signs the deliveries. It is returned only when the webhook is
+	// created.
+	Secret    string    `json:"secret,omitempty" validate:"max=200"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type Delivery struct {
+	ID            int        `json:"id"`
+	EventID       string     `json:"event_id"`
+	EventType     string     `json:"event_type"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+	LastStatus    *int       `json:"last_status,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	DeliveredAt   *time.Time `json:"delivered_at,omitempty"`
+}
+
+type Handler struct {
+	DB *sql.DB
+}
+
+func NewApplication(db *sql.DB) *Handler {
+	return &Handler{db}
+}
+
+func generateSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return secretPrefix + hex.EncodeToString(b), nil
+}
+
+// validate checks what the validate tags can't: that the URL is absolute
+// http or https on a public host and that the events exist.
+func validate(ctx context.Context, w Webhook) error {
+	if err := validation.Struct(w); err != nil {
+		return err
+	}
+	fields := []validation.FieldError{}
+	if u, err := url.Parse(w.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		fields = append(fields, validation.FieldError{Field: "url", Message: "must be an absolute http or https URL"})
+	} else if msg := checkHost(ctx, u.Hostname()); msg != "" {
+		fields = append(fields, validation.FieldError{Field: "url", Message: msg})
+	}
+	for i, e := range w.Events {
+		if !contains(expenses.EventTypes, e) {
+			fields = append(fields, validation.FieldError{Field: fmt.Sprintf("events[%d]", i), Message: "is not a known event"})
+		}
+	}
+	if len(fields) > 0 {
+		return &validation.Errors{Message: validation.Message, Errors: fields}
+	}
+	return nil
+}
+
+func contains(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateWebhookHandler subscribes a URL. Without a secret in the request
+// one is generated; either way it is in the response.
+func (h *Handler) CreateWebhookHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	p, _ := auth.PrincipalFrom(c)
+	w := Webhook{}
+
+	if err := c.Bind(&w); err != nil {
+		return err
+	}
+	if w.Events == nil {
+		w.Events = []string{}
+	}
+	if err := validate(ctx, w); err != nil {
+		return err
+	}
+	if w.Secret == "" {
+		secret, err := generateSecret()
+		if err != nil {
+			return fmt.Errorf("can't generate webhook secret: %w", err)
+		}
+		w.Secret = secret
+	}
+
+	if err := h.DB.QueryRowContext(ctx, createWebhookSQL, p.User, w.URL, pq.Array(w.Events), w.Secret).Scan(&w.ID, &w.CreatedAt); err != nil {
+		return fmt.Errorf("can't create webhook: %w", err)
+	}
+
+	return c.JSON(http.StatusCreated, w)
+}
+
+func (h *Handler) GetWebhooksHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	p, _ := auth.PrincipalFrom(c)
+
+	rows, err := h.DB.QueryContext(ctx, getWebhooksSQL, p.User)
+	if err != nil {
+		return fmt.Errorf("can't query webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	hooks := []Webhook{}
+	for rows.Next() {
+		w := Webhook{}
+		if err := rows.Scan(&w.ID, &w.URL, pq.Array(&w.Events), &w.CreatedAt); err != nil {
+			return fmt.Errorf("can't scan webhook: %w", err)
+		}
+		hooks = append(hooks, w)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("can't query webhooks: %w", err)
+	}
+
+	return c.JSON(http.StatusOK, hooks)
+}
+
+// DeleteWebhookHandler unsubscribes a URL along with its deliveries,
+// including those not sent yet.
+func (h *Handler) DeleteWebhookHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	p, _ := auth.PrincipalFrom(c)
+	id, err := paramID(c, "id")
+	if err != nil {
+		return err
+	}
+
+	res, err := h.DB.ExecContext(ctx, deleteWebhookSQL, id, p.User)
+	if err != nil {
+		return fmt.Errorf("can't delete webhook: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return problem.NotFound("webhook not found")
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// GetDeliveriesHandler lists the latest deliveries of a webhook, newest
+// first.
+func (h *Handler) GetDeliveriesHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	id, err := h.webhookFromParam(c)
+	if err != nil {
+		return err
+	}
+
+	rows, err := h.DB.QueryContext(ctx, getDeliveriesSQL, id, deliveriesLimit)
+	if err != nil {
+		return fmt.Errorf("can't query webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	list := []Delivery{}
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return err
+		}
+		list = append(list, d)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("can't query webhook deliveries: %w", err)
+	}
+
+	return c.JSON(http.StatusOK, list)
+}
+
+// RedeliverHandler queues a delivery to be sent again straight away with a
+// fresh set of attempts, whatever its state. Receivers see the same event
+// id, so they can tell a redelivery from a new event.
+func (h *Handler) RedeliverHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	id, err := h.webhookFromParam(c)
+	if err != nil {
+		return err
+	}
+	deliveryID, err := paramID(c, "delivery_id")
+	if err != nil {
+		return err
+	}
+
+	d, err := scanDelivery(h.DB.QueryRowContext(ctx, redeliverSQL, deliveryID, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return problem.NotFound("delivery not found")
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusAccepted, d)
+}
+
+// webhookFromParam parses the :id parameter and checks that the webhook
+// belongs to the principal. Other users' webhooks are reported as not found.
+func (h *Handler) webhookFromParam(c echo.Context) (int, error) {
+	id, err := paramID(c, "id")
+	if err != nil {
+		return 0, err
+	}
+	p, _ := auth.PrincipalFrom(c)
+
+	var ok bool
+	if err := h.DB.QueryRowContext(c.Request().Context(), ownsWebhookSQL, id, p.User).Scan(&ok); err != nil {
+		return 0, fmt.Errorf("can't get webhook: %w", err)
+	}
+	if !ok {
+		return 0, problem.NotFound("webhook not found")
+	}
+	return id, nil
+}
+
+func paramID(c echo.Context, name string) (int, error) {
+	id, err := strconv.Atoi(c.Param(name))
+	if err != nil || id <= 0 {
+		return 0, problem.InvalidID(name, c.Param(name))
+	}
+	return id, nil
+}
+
+func scanDelivery(row interface{ Scan(...any) error }) (Delivery, error) {
+	d := Delivery{}
+	var lastStatus sql.NullInt64
+	err := row.Scan(&d.ID, &d.EventID, &d.EventType, &d.Status, &d.Attempts, &d.NextAttemptAt, &lastStatus, &d.LastError, &d.CreatedAt, &d.DeliveredAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return d, err
+	}
+	if err != nil {
+		return d, fmt.Errorf("can't scan webhook delivery: %w", err)
+	}
+	if lastStatus.Valid {
+		n := int(lastStatus.Int64)
+		d.LastStatus = &n
+	}
+	return d, nil
+}
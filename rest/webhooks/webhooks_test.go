@@ -0,0 +1,444 @@
+//go:build unit
+// +build unit
+
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/PatcharaKL/assessment/problem"
+	"github.com/PatcharaKL/assessment/rest/auth"
+	"github.com/PatcharaKL/assessment/rest/expenses"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupTestServer(method, uri string, body *bytes.Buffer) (*httptest.ResponseRecorder, echo.Context) {
+	e := echo.New()
+	req := httptest.NewRequest(method, uri, body)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	auth.SetPrincipal(c, auth.Principal{User: "Patchara", Role: auth.RoleMember, Scopes: auth.AllScopes})
+	return rec, c
+}
+
+// respond writes err the way the server's error handler does, so that the
+// assertions can check the response whatever the handler returned.
+func respond(c echo.Context, err error) error {
+	if err != nil {
+		problem.HTTPErrorHandler(err, c)
+	}
+	return nil
+}
+
+// received is a request that reached the test receiver.
+type received struct {
+	header http.Header
+	body   []byte
+}
+
+// setupReceiver starts a local HTTP receiver that answers with the given
+// status codes in turn, repeating the last one.
+func setupReceiver(t *testing.T, codes ...int) (*httptest.Server, chan received) {
+	got := make(chan received, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got <- received{r.Header.Clone(), body}
+		code := codes[0]
+		if len(codes) > 1 {
+			codes = codes[1:]
+		}
+		w.WriteHeader(code)
+		w.Write([]byte("receiver says " + strconv.Itoa(code)))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, got
+}
+
+func setupDispatcher(t *testing.T) (*Dispatcher, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	d := NewDispatcher(db, 3, time.Second, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	// The test receivers listen on loopback, which the dispatcher's own
+	// client refuses to dial.
+	d.Client = &http.Client{Timeout: time.Second}
+	d.RetryBase = time.Minute
+	d.RetryMax = 3 * time.Minute
+	return d, mock
+}
+
+// setupLookup resolves hosts to the given addresses until the test ends.
+func setupLookup(t *testing.T, hosts map[string]string) {
+	lookup := lookupIP
+	t.Cleanup(func() { lookupIP = lookup })
+	lookupIP = func(_ context.Context, _, host string) ([]netip.Addr, error) {
+		ip, ok := hosts[host]
+		if !ok {
+			return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+		}
+		return []netip.Addr{netip.MustParseAddr(ip)}, nil
+	}
+}
+
+// expectNothingDue ends DeliverDue: the next claim finds no delivery due.
+func expectNothingDue(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery("WITH due AS").WillReturnRows(sqlmock.NewRows(claimColumns))
+}
+
+var claimColumns = []string{"id", "webhook_id", "event_id", "event_type", "payload", "attempts", "url", "secret"}
+
+const testPayload = `{"id":"evt_1","type":"expense.created","created_at":"2024-01-01T00:00:00Z","data":{"id":1,"title":"strawberry smoothie","amount":79,"note":"","tags":["food"]}}`
+
+func TestSignAndVerifyU(t *testing.T) {
+	// Arrange
+	body := []byte(testPayload)
+
+	// Act
+	sig := Sign("whsec_test", 1700000000, body)
+
+	// Assert
+	assert.True(t, strings.HasPrefix(sig, "sha256="))
+	assert.True(t, Verify("whsec_test", 1700000000, body, sig))
+	assert.False(t, Verify("whsec_other", 1700000000, body, sig), "wrong secret")
+	assert.False(t, Verify("whsec_test", 1700000001, body, sig), "wrong timestamp")
+	assert.False(t, Verify("whsec_test", 1700000000, append(body, ' '), sig), "changed body")
+}
+
+func TestCreateWebhookU(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		expectedCode int
+	}{
+		{"testGeneratedSecret", `{"url": "https://reimburse.example.com/hooks", "events": ["expense.created"]}`, http.StatusCreated},
+		{"testGivenSecret", `{"url": "http://93.184.216.34:9000/hooks", "secret": "shared"}`, http.StatusCreated},
+		{"testInvalidURL", `{"url": "reimburse.example.com/hooks"}`, http.StatusUnprocessableEntity},
+		{"testUnknownEvent", `{"url": "https://reimburse.example.com/hooks", "events": ["expense.exploded"]}`, http.StatusUnprocessableEntity},
+		{"testLoopback", `{"url": "http://localhost:9000/hooks"}`, http.StatusUnprocessableEntity},
+		{"testPrivateHost", `{"url": "https://intranet.example.com/hooks"}`, http.StatusUnprocessableEntity},
+		{"testMetadataAddress", `{"url": "http://169.254.169.254/latest/meta-data/"}`, http.StatusUnprocessableEntity},
+		{"testMappedPrivateAddress", `{"url": "http://[::ffff:10.0.0.1]/hooks"}`, http.StatusUnprocessableEntity},
+		{"testUnresolvedHost", `{"url": "https://nowhere.example.com/hooks"}`, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			setupLookup(t, map[string]string{
+				"reimburse.example.com": "93.184.216.34",
+				"intranet.example.com":  "192.168.1.20",
+				"localhost":             "127.0.0.1",
+			})
+			rec, c := setupTestServer(http.MethodPost, "/webhooks", bytes.NewBufferString(tt.body))
+			db, mock, _ := sqlmock.New()
+			defer db.Close()
+			if tt.expectedCode == http.StatusCreated {
+				mock.ExpectQuery("INSERT INTO webhooks").WithArgs("Patchara", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+			}
+			h := Handler{db}
+
+			// Act
+			err := respond(c, h.CreateWebhookHandler(c))
+
+			// Assert
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.expectedCode, rec.Code, rec.Body.String())
+				assert.NoError(t, mock.ExpectationsWereMet())
+			}
+			if tt.expectedCode != http.StatusCreated {
+				return
+			}
+			w := Webhook{}
+			if assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &w)) {
+				assert.NotEmpty(t, w.Secret)
+				assert.NotNil(t, w.Events)
+			}
+			if tt.name == "testGeneratedSecret" {
+				assert.True(t, strings.HasPrefix(w.Secret, secretPrefix))
+			}
+		})
+	}
+}
+
+func TestRedeliverU(t *testing.T) {
+	tests := []struct {
+		name         string
+		owns         bool
+		found        bool
+		expectedCode int
+	}{
+		{"testSucceed", true, true, http.StatusAccepted},
+		{"testOtherUsersWebhook", false, false, http.StatusNotFound},
+		{"testUnknownDelivery", true, false, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			rec, c := setupTestServer(http.MethodPost, "/", &bytes.Buffer{})
+			c.SetParamNames("id", "delivery_id")
+			c.SetParamValues("1", "7")
+			db, mock, _ := sqlmock.New()
+			defer db.Close()
+			mock.ExpectQuery("SELECT EXISTS").WithArgs(1, "Patchara").
+				WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(tt.owns))
+			if tt.owns {
+				rows := sqlmock.NewRows([]string{"id", "event_id", "event_type", "status", "attempts", "next_attempt_at", "last_status", "last_error", "created_at", "delivered_at"})
+				if tt.found {
+					rows.AddRow(7, "evt_1", expenses.EventCreated, StatusPending, 0, time.Now(), 500, "receiver answered 500", time.Now(), nil)
+				}
+				mock.ExpectQuery("UPDATE webhook_deliveries SET status = 'pending'").WithArgs(7, 1).WillReturnRows(rows)
+			}
+			h := Handler{db}
+
+			// Act
+			err := respond(c, h.RedeliverHandler(c))
+
+			// Assert
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.expectedCode, rec.Code, rec.Body.String())
+				assert.NoError(t, mock.ExpectationsWereMet())
+			}
+		})
+	}
+}
+
+func TestRecordQueuesDeliveriesU(t *testing.T) {
+	// Arrange
+	d, mock := setupDispatcher(t)
+	var payload []byte
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO webhook_deliveries").
+		WithArgs("Patchara", "evt_42", expenses.EventUpdated, argCapture{&payload}).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+	e := expenses.Event{ID: 42, Type: expenses.EventUpdated, Owner: "Patchara", Expense: expenses.Expenses{ID: 1, Title: "apple smoothie", Amount: 89, Tags: []string{}}, Time: time.Now()}
+	tx, _ := d.DB.Begin()
+
+	// Act
+	err := d.Record(context.Background(), tx, e)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.NoError(t, tx.Commit())
+	assert.NoError(t, mock.ExpectationsWereMet())
+	p := Payload{}
+	if assert.NoError(t, json.Unmarshal(payload, &p)) {
+		assert.Equal(t, "evt_42", p.ID)
+		assert.Equal(t, expenses.EventUpdated, p.Type)
+		assert.Equal(t, e.Expense, p.Data)
+	}
+	assert.Len(t, d.wake, 0, "Run is woken up before the change is committed")
+
+	d.Publish(context.Background(), e)
+	assert.Len(t, d.wake, 1, "Run is woken up")
+}
+
+// argCapture matches any argument and keeps it.
+type argCapture struct {
+	dst *[]byte
+}
+
+func (a argCapture) Match(v driver.Value) bool {
+	b, ok := v.([]byte)
+	*a.dst = b
+	return ok
+}
+
+func TestDeliverDueSignsRequestU(t *testing.T) {
+	// Arrange
+	d, mock := setupDispatcher(t)
+	srv, got := setupReceiver(t, http.StatusNoContent)
+	mock.ExpectQuery("WITH due AS").WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(claimColumns).AddRow(7, 1, "evt_1", expenses.EventCreated, []byte(testPayload), 0, srv.URL, "whsec_test"))
+	mock.ExpectExec("UPDATE webhook_deliveries SET status = 'delivered'").WithArgs(7, http.StatusNoContent).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectNothingDue(mock)
+
+	// Act
+	n, err := d.DeliverDue(context.Background())
+
+	// Assert
+	if assert.NoError(t, err) {
+		assert.Equal(t, 1, n)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	}
+	r := <-got
+	ts, _ := strconv.ParseInt(r.header.Get(HeaderTimestamp), 10, 64)
+	assert.Equal(t, testPayload, string(r.body))
+	assert.Equal(t, expenses.EventCreated, r.header.Get(HeaderEvent))
+	assert.Equal(t, "7", r.header.Get(HeaderDelivery))
+	assert.True(t, Verify("whsec_test", ts, r.body, r.header.Get(HeaderSignature)), "signature verifies")
+	assert.InDelta(t, time.Now().Unix(), ts, 5)
+}
+
+func TestDeliverDueRetriesWithBackoffU(t *testing.T) {
+	tests := []struct {
+		name          string
+		attempts      int
+		expectedState string
+		expectedWait  float64
+	}{
+		{"testFirstFailure", 0, StatusPending, 60},
+		{"testSecondFailure", 1, StatusPending, 120},
+		{"testLastAttempt", 2, StatusDead, 180},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			d, mock := setupDispatcher(t)
+			srv, got := setupReceiver(t, http.StatusServiceUnavailable)
+			mock.ExpectQuery("WITH due AS").
+				WillReturnRows(sqlmock.NewRows(claimColumns).AddRow(7, 1, "evt_1", expenses.EventCreated, []byte(testPayload), tt.attempts, srv.URL, "whsec_test"))
+			mock.ExpectExec("UPDATE webhook_deliveries SET status = \\$2").
+				WithArgs(7, tt.expectedState, sql.NullInt64{Int64: http.StatusServiceUnavailable, Valid: true}, "receiver answered 503 Service Unavailable", tt.expectedWait).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			expectNothingDue(mock)
+
+			// Act
+			_, err := d.DeliverDue(context.Background())
+
+			// Assert
+			assert.NoError(t, err)
+			assert.NoError(t, mock.ExpectationsWereMet())
+			assert.Len(t, got, 1)
+		})
+	}
+}
+
+func TestDeliverDueClaimsEachDeliveryBeforeSendingU(t *testing.T) {
+	// Arrange
+	d, mock := setupDispatcher(t)
+	d.BatchSize = 2
+	srv, got := setupReceiver(t, http.StatusOK)
+	lease := (d.Client.Timeout + 30*time.Second).Seconds()
+	for _, id := range []int{7, 8} {
+		mock.ExpectQuery("WITH due AS").WithArgs(lease).
+			WillReturnRows(sqlmock.NewRows(claimColumns).AddRow(id, 1, "evt_1", expenses.EventCreated, []byte(testPayload), 0, srv.URL, "whsec_test"))
+		mock.ExpectExec("UPDATE webhook_deliveries SET status = 'delivered'").WithArgs(id, http.StatusOK).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+
+	// Act
+	n, err := d.DeliverDue(context.Background())
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n, "stops at BatchSize")
+	assert.Len(t, got, 2)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeliverDueUnreachableReceiverU(t *testing.T) {
+	// Arrange
+	d, mock := setupDispatcher(t)
+	srv, _ := setupReceiver(t, http.StatusOK)
+	srv.Close()
+	mock.ExpectQuery("WITH due AS").
+		WillReturnRows(sqlmock.NewRows(claimColumns).AddRow(7, 1, "evt_1", expenses.EventCreated, []byte(testPayload), 0, srv.URL, "whsec_test"))
+	mock.ExpectExec("UPDATE webhook_deliveries SET status = \\$2").
+		WithArgs(7, StatusPending, sql.NullInt64{}, sqlmock.AnyArg(), 60.0).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectNothingDue(mock)
+
+	// Act
+	_, err := d.DeliverDue(context.Background())
+
+	// Assert
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeliverDuePrivateReceiverU(t *testing.T) {
+	// Arrange
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	d := NewDispatcher(db, 3, time.Second, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	srv, got := setupReceiver(t, http.StatusOK)
+	mock.ExpectQuery("WITH due AS").
+		WillReturnRows(sqlmock.NewRows(claimColumns).AddRow(7, 1, "evt_1", expenses.EventCreated, []byte(testPayload), 0, srv.URL, "whsec_test"))
+	mock.ExpectExec("UPDATE webhook_deliveries SET status = \\$2").
+		WithArgs(7, StatusPending, sql.NullInt64{}, sqlmock.AnyArg(), 30.0).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectNothingDue(mock)
+
+	// Act
+	_, err := d.DeliverDue(context.Background())
+
+	// Assert
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Len(t, got, 0, "the receiver on loopback was dialled")
+}
+
+func TestPublicAddrU(t *testing.T) {
+	tests := []struct {
+		addr     string
+		expected bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.0.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00:ec2::254", false},
+		{"100.100.100.200", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"224.0.0.1", false},
+		{"::ffff:127.0.0.1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			// Act
+			got := publicAddr(netip.MustParseAddr(tt.addr))
+
+			// Assert
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+}
+
+func TestGetWebhooksU(t *testing.T) {
+	// Arrange
+	rec, c := setupTestServer(http.MethodGet, "/webhooks", &bytes.Buffer{})
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("SELECT id, url, events, created_at FROM webhooks").WithArgs("Patchara").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "url", "events", "created_at"}).
+			AddRow(1, "https://reimburse.example.com/hooks", pq.Array([]string{expenses.EventCreated}), created))
+	h := Handler{db}
+
+	// Act
+	err := respond(c, h.GetWebhooksHandler(c))
+
+	// Assert
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, `[{"id":1,"url":"https://reimburse.example.com/hooks","events":["expense.created"],"created_at":"2024-01-01T00:00:00Z"}]`, strings.TrimSpace(rec.Body.String()))
+	}
+}
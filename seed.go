@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/PatcharaKL/assessment/rest/auth"
+	"github.com/PatcharaKL/assessment/rest/expenses"
+)
+
+// demoExpenses is what seed loads without -file: enough variety in tags and
+// amounts to try out filtering and the totals reports.
+var demoExpenses = []expenses.Expenses{
+	{Title: "strawberry smoothie", Amount: 79, Note: "night market promotion discount 10 bath", Tags: []string{"food", "beverage"}},
+	{Title: "iPhone 14 Pro Max 1TB", Amount: 66900, Note: "birthday gift from my love", Tags: []string{"gadget"}},
+	{Title: "apple smoothie", Amount: 89, Note: "no discount", Tags: []string{"beverage"}},
+	{Title: "pad thai", Amount: 60, Note: "lunch", Tags: []string{"food"}},
+	{Title: "BTS skytrain", Amount: 44, Tags: []string{"transport"}},
+	{Title: "taxi to the airport", Amount: 350, Note: "late for the flight", Tags: []string{"transport", "travel"}},
+	{Title: "hotel in Chiang Mai", Amount: 2400, Note: "2 nights", Tags: []string{"travel"}},
+	{Title: "electricity bill", Amount: 1280, Tags: []string{"bills"}},
+}
+
+// seed creates expenses owned by -owner, from a JSON array like the one
+// expensectl export -format json writes or else the demo set. It refuses an
+// owner who already has expenses unless -force is given, so running it
+// twice doesn't duplicate the data. With -unowned it creates nothing and
+// gives -owner the expenses created before expenses had owners instead.
+func seed(ctx context.Context, args []string) error {
+	fs := newFlagSet("seed")
+	owner := fs.String("owner", "", "username that will own the expenses")
+	file := fs.String("file", "", "JSON file of expenses to load instead of the demo set")
+	force := fs.Bool("force", false, "load even if the owner already has expenses")
+	unowned := fs.Bool("unowned", false, "instead of loading expenses, give the owner those that have none, which only admins can see")
+	cfg, err := parseConfig(fs, args)
+	if err != nil {
+		return err
+	}
+	if *owner == "" {
+		return usageError{errors.New("-owner is required")}
+	}
+	if *unowned && *file != "" {
+		return usageError{errors.New("-unowned and -file can't be used together")}
+	}
+
+	list := demoExpenses
+	if *file != "" {
+		if list, err = readFixtures(*file); err != nil {
+			return err
+		}
+	}
+
+	db, err := connect(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	svc := expenses.NewService(db)
+	if *unowned {
+		n, err := svc.AssignUnowned(ctx, *owner)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "gave %d unowned expenses to %s\n", n, *owner)
+		return nil
+	}
+
+	p := auth.Principal{User: *owner, Role: auth.RoleMember}
+	if !*force {
+		existing, err := svc.List(ctx, p)
+		if err != nil {
+			return err
+		}
+		if len(existing) > 0 {
+			return fmt.Errorf("%s already has %d expenses, use -force to add more", *owner, len(existing))
+		}
+	}
+
+	for i, e := range list {
+		e.ID = 0
+		if _, err := svc.Create(ctx, p, e); err != nil {
+			return fmt.Errorf("expense %d (%q): %w", i+1, e.Title, err)
+		}
+	}
+	fmt.Fprintf(stdout, "seeded %d expenses for %s\n", len(list), *owner)
+	return nil
+}
+
+func readFixtures(path string) ([]expenses.Expenses, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	list := []expenses.Expenses{}
+	if err := json.Unmarshal(b, &list); err != nil {
+		return nil, fmt.Errorf("can't parse %s: %w", path, err)
+	}
+	return list, nil
+}
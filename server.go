@@ -2,66 +2,318 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
-	"os/signal"
-	"syscall"
 	"time"
 
+	"github.com/PatcharaKL/assessment/cache"
+	"github.com/PatcharaKL/assessment/compress"
+	"github.com/PatcharaKL/assessment/config"
+	"github.com/PatcharaKL/assessment/graph"
+	"github.com/PatcharaKL/assessment/grpcapi"
+	"github.com/PatcharaKL/assessment/health"
+	"github.com/PatcharaKL/assessment/logging"
+	"github.com/PatcharaKL/assessment/metrics"
+	"github.com/PatcharaKL/assessment/migrations"
+	"github.com/PatcharaKL/assessment/notify"
+	"github.com/PatcharaKL/assessment/openapi"
+	"github.com/PatcharaKL/assessment/problem"
+	"github.com/PatcharaKL/assessment/rest/auth"
+	"github.com/PatcharaKL/assessment/rest/events"
 	"github.com/PatcharaKL/assessment/rest/expenses"
+	"github.com/PatcharaKL/assessment/rest/groups"
+	"github.com/PatcharaKL/assessment/rest/idempotency"
+	"github.com/PatcharaKL/assessment/rest/webhooks"
+	"github.com/PatcharaKL/assessment/sqlhooks"
+	"github.com/PatcharaKL/assessment/tracing"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
-	"github.com/labstack/gommon/log"
 )
 
 func healthHandler(c echo.Context) error {
 	return c.JSON(http.StatusOK, "OK")
 }
 
-func authenticationHandler(username, password string, c echo.Context) (bool, error) {
-	if username == "Patchara" && password == "Password" {
-		return true, nil
+func middlewareHandler(e *echo.Echo, a *auth.Handler, spec *openapi.Spec, authMode string, logger *slog.Logger) {
+	// Compression comes first so the logs and the spec check see the
+	// responses as the handlers wrote them.
+	e.Use(compress.Middleware)
+	e.Use(logging.Middleware(logger))
+	e.Use(tracing.Middleware)
+	e.Use(metrics.Middleware)
+	if authMode != config.AuthAPIKey {
+		e.Use(middleware.BasicAuthWithConfig(middleware.BasicAuthConfig{
+			Skipper: func(c echo.Context) bool {
+				return public(c) || (authMode == config.AuthBoth && auth.HasBearerToken(c))
+			},
+			Validator: a.ValidateBasicAuth,
+		}))
 	}
-	return false, nil
+	if authMode != config.AuthBasic {
+		e.Use(middleware.KeyAuthWithConfig(middleware.KeyAuthConfig{
+			Skipper: func(c echo.Context) bool {
+				return public(c) || (authMode == config.AuthBoth && auth.NoBearerToken(c))
+			},
+			Validator: a.ValidateAPIKey,
+		}))
+	}
+	e.Use(spec.Middleware(logger))
+	e.Use(middleware.RecoverWithConfig(middleware.RecoverConfig{
+		LogErrorFunc: logging.RecoverLogger(logger),
+	}))
+}
+
+// healthPath is the plain health check, older than the probes.
+const healthPath = "/health"
+
+// public reports whether the request is served without authentication. It
+// must agree with the public routes of the route table.
+func public(c echo.Context) bool {
+	return c.Request().URL.Path == healthPath || health.IsProbe(c) || openapi.IsDocs(c)
+}
+
+// route is an endpoint and who may call it: callers whose role and
+// credentials allow scope, or anyone when it is public. Every route sets one
+// or the other, so that none is left open by omission.
+type route struct {
+	method     string
+	path       string
+	handler    echo.HandlerFunc
+	scope      string
+	public     bool
+	middleware []echo.MiddlewareFunc
 }
 
-func middlewareHandler(e *echo.Echo) {
-	e.Use(middleware.BasicAuth(authenticationHandler))
-	e.Use(middleware.Logger())
-	e.Use(middleware.Recover())
+func routes(h *expenses.Handler, a *auth.Handler, g *groups.Handler, wh *webhooks.Handler, es *events.Stream, idem *idempotency.Store, hc *health.Checker, spec *openapi.Spec, gql echo.HandlerFunc) []route {
+	return []route{
+		{method: http.MethodGet, path: healthPath, handler: healthHandler, public: true},
+		{method: http.MethodGet, path: health.LivezPath, handler: hc.LivezHandler, public: true},
+		{method: http.MethodGet, path: health.ReadyzPath, handler: hc.ReadyzHandler, public: true},
+		{method: http.MethodGet, path: openapi.SpecPath, handler: spec.SpecHandler, public: true},
+		{method: http.MethodGet, path: openapi.DocsPath, handler: spec.DocsHandler, public: true},
+		{method: http.MethodGet, path: "/metrics", handler: metrics.Handler(), scope: auth.ScopeReportsRead},
+		{method: http.MethodGet, path: "/expenses", handler: h.GetExpensesHandler, scope: auth.ScopeExpensesRead},
+		{method: http.MethodGet, path: "/expenses/:id", handler: h.GetExpenseByIdHandler, scope: auth.ScopeExpensesRead},
+		{method: http.MethodPut, path: "/expenses/:id", handler: h.UpdateExpensesHandler, scope: auth.ScopeExpensesWrite},
+		{method: http.MethodPost, path: "/expenses", handler: h.CreateExpensesHandler, scope: auth.ScopeExpensesWrite, middleware: []echo.MiddlewareFunc{idem.Middleware}},
+		{method: http.MethodPost, path: "/groups", handler: g.CreateGroupHandler, scope: auth.ScopeExpensesWrite},
+		{method: http.MethodGet, path: "/groups/:id", handler: g.GetGroupHandler, scope: auth.ScopeExpensesRead},
+		{method: http.MethodPost, path: "/groups/:id/members", handler: g.AddGroupMemberHandler, scope: auth.ScopeExpensesWrite},
+		{method: http.MethodGet, path: "/groups/:id/expenses", handler: g.GetGroupExpensesHandler, scope: auth.ScopeExpensesRead},
+		{method: http.MethodPost, path: "/groups/:id/expenses", handler: g.CreateGroupExpenseHandler, scope: auth.ScopeExpensesWrite},
+		{method: http.MethodGet, path: "/groups/:id/balances", handler: g.GetBalancesHandler, scope: auth.ScopeExpensesRead},
+		{method: http.MethodGet, path: "/groups/:id/settle-up", handler: g.SettleUpHandler, scope: auth.ScopeExpensesRead},
+		{method: http.MethodPost, path: "/groups/:id/settlements", handler: g.CreateSettlementHandler, scope: auth.ScopeExpensesWrite},
+		{method: http.MethodGet, path: "/api-keys", handler: a.GetAPIKeysHandler, scope: auth.ScopeKeysManage},
+		{method: http.MethodPost, path: "/api-keys", handler: a.CreateAPIKeyHandler, scope: auth.ScopeKeysManage},
+		{method: http.MethodDelete, path: "/api-keys/:id", handler: a.RevokeAPIKeyHandler, scope: auth.ScopeKeysManage},
+		{method: http.MethodGet, path: "/users", handler: a.GetUsersHandler, scope: auth.ScopeUsersManage},
+		{method: http.MethodPost, path: "/users", handler: a.CreateUserHandler, scope: auth.ScopeUsersManage},
+		{method: http.MethodPut, path: "/users/:id", handler: a.UpdateUserHandler, scope: auth.ScopeUsersManage},
+		{method: http.MethodGet, path: "/webhooks", handler: wh.GetWebhooksHandler, scope: auth.ScopeWebhooksManage},
+		{method: http.MethodPost, path: "/webhooks", handler: wh.CreateWebhookHandler, scope: auth.ScopeWebhooksManage},
+		{method: http.MethodDelete, path: "/webhooks/:id", handler: wh.DeleteWebhookHandler, scope: auth.ScopeWebhooksManage},
+		{method: http.MethodGet, path: "/webhooks/:id/deliveries", handler: wh.GetDeliveriesHandler, scope: auth.ScopeWebhooksManage},
+		{method: http.MethodPost, path: "/webhooks/:id/deliveries/:delivery_id/redeliver", handler: wh.RedeliverHandler, scope: auth.ScopeWebhooksManage},
+		{method: http.MethodGet, path: events.StreamPath, handler: es.StreamHandler, scope: auth.ScopeExpensesRead},
+		{method: http.MethodPost, path: graph.Path, handler: gql, scope: auth.ScopeExpensesRead},
+	}
+}
+
+func endpointHandler(e *echo.Echo, h *expenses.Handler, a *auth.Handler, g *groups.Handler, wh *webhooks.Handler, es *events.Stream, idem *idempotency.Store, hc *health.Checker, spec *openapi.Spec, gql echo.HandlerFunc) {
+	for _, r := range routes(h, a, g, wh, es, idem, hc, spec, gql) {
+		m := r.middleware
+		if !r.public {
+			m = append([]echo.MiddlewareFunc{auth.RequirePermission(r.scope)}, m...)
+		}
+		e.Add(r.method, r.path, r.handler, m...)
+	}
 }
 
-func endpointHandler(e *echo.Echo, h *expenses.Handler) {
-	e.GET("/health", healthHandler)
-	e.GET("/expenses", h.GetExpensesHandler)
-	e.GET("/expenses/:id", h.GetExpenseByIdHandler)
-	e.PUT("/expenses/:id", h.UpdateExpensesHandler)
-	e.POST("/expenses", h.CreateExpensesHandler)
+// openCache returns the cache of expense reads cfg asks for, or nil for none.
+// A Redis server that doesn't answer yet is only logged: until it does,
+// reads go to the database.
+func openCache(ctx context.Context, cfg config.Config, logger *slog.Logger) (*expenses.Cache, error) {
+	var backend cache.Backend
+	switch cfg.Cache.Backend {
+	case config.CacheMemory:
+		backend = cache.NewLRU(cfg.Cache.Size)
+	case config.CacheRedis:
+		r, err := cache.NewRedis(cfg.Cache.RedisURL)
+		if err != nil {
+			return nil, err
+		}
+		if err := r.Ping(ctx); err != nil {
+			logger.Warn("can't reach redis cache", "error", err)
+		}
+		backend = r
+	default:
+		return nil, nil
+	}
+	return expenses.NewCache(backend, cfg.Cache.TTL, logger), nil
 }
 
-func main() {
-	db := expenses.InitDB()
+// serve runs the REST, GraphQL and gRPC APIs until ctx is done, on SIGINT or
+// SIGTERM, then shuts them down gracefully. It applies pending migrations first.
+func serve(ctx context.Context, args []string) error {
+	cfg, err := parseConfig(newFlagSet("serve"), args)
+	if err != nil {
+		return err
+	}
+
+	logger := logging.New(os.Stdout, cfg.LogLevel)
+	slog.SetDefault(logger)
+	// The background workers stop with serve, even when it fails.
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	spec, err := openapi.Load()
+	if err != nil {
+		return fmt.Errorf("can't load openapi spec: %w", err)
+	}
+
+	shutdownTracing, err := tracing.Setup(ctx, cfg.Tracing.Exporter, cfg.Tracing.Endpoint, cfg.Tracing.ServiceName)
+	if err != nil {
+		return fmt.Errorf("can't set up tracing: %w", err)
+	}
+	// Traces are flushed last, whether serve stops cleanly or fails.
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeouts.Shutdown)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			logger.Error("can't flush traces", "error", err)
+		}
+	}()
+
+	db, err := openDB(cfg, logger)
+	if err != nil {
+		return usageError{fmt.Errorf("invalid database url: %w", err)}
+	}
 	defer db.Close()
+	if err := waitForDB(ctx, db, cfg, logger); err != nil {
+		return err
+	}
+	metrics.RegisterDB(db, "expenses")
+	if _, err := migrations.Up(sqlhooks.WithoutTimeout(ctx), db); err != nil {
+		return fmt.Errorf("can't migrate database: %w", err)
+	}
+	stmts, err := expenses.PrepareStatements(ctx, db)
+	if err != nil {
+		return fmt.Errorf("can't prepare statements: %w", err)
+	}
+	defer stmts.Close()
+	if err := auth.Bootstrap(ctx, db, cfg.Bootstrap.User, cfg.Bootstrap.Password); err != nil {
+		return fmt.Errorf("can't bootstrap admin: %w", err)
+	}
+	a := auth.NewApplication(db)
+	hc := health.New(db, cfg.Timeouts.Readiness)
 
 	e := echo.New()
-	e.Logger.SetLevel(log.INFO)
+	e.HideBanner = true
+	e.HidePort = true
+	e.HTTPErrorHandler = problem.HTTPErrorHandler
+	e.Server.ReadTimeout = cfg.Timeouts.Read
+	e.Server.WriteTimeout = cfg.Timeouts.Write
+	e.Server.IdleTimeout = cfg.Timeouts.Idle
+	// Requests run with a context of their own rather than Background, so
+	// that those still running when the shutdown timeout expires are
+	// cancelled along with their queries.
+	requests, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+	e.Server.BaseContext = func(net.Listener) context.Context { return requests }
+
+	middlewareHandler(e, a, spec, cfg.AuthMode, logger)
+
+	idem := idempotency.New(db, cfg.IdempotencyTTL)
+	go idem.Run(ctx, logger)
+
+	dispatcher := webhooks.NewDispatcher(db, cfg.Webhooks.MaxAttempts, cfg.Webhooks.Timeout, logger)
+	go dispatcher.Run(ctx)
+
+	stream := events.NewStream(db)
+	e.Server.RegisterOnShutdown(stream.Close)
+	// Changes reach the streams of every replica through the notifier; each
+	// is queued for webhooks once, in the transaction that makes it.
+	notifier := notify.New(cfg.DB.URL, cfg.Notify.MinReconnect, cfg.Notify.MaxReconnect, logger)
+	notifier.Subscribe(stream)
+	expenseCache, err := openCache(ctx, cfg, logger)
+	if err != nil {
+		return fmt.Errorf("can't set up cache: %w", err)
+	}
+	if expenseCache != nil {
+		if c, ok := expenseCache.Backend.(io.Closer); ok {
+			defer c.Close()
+		}
+		notifier.Subscribe(expenseCache)
+	}
+	go func() {
+		if err := notifier.Run(ctx); err != nil {
+			logger.Error("stopped listening for expense changes", "error", err)
+		}
+	}()
+	// Deliveries and notifications are queued in the transaction of the
+	// change; once it commits, the dispatcher is woken to send them.
+	recorders := expenses.Recorders{dispatcher, notifier}
 
-	middlewareHandler(e)
+	// Every API shares one Service.
+	svc := &expenses.Service{DB: db, Stmts: stmts, Recorder: recorders, Events: dispatcher, Cache: expenseCache}
+	endpointHandler(e, &expenses.Handler{Service: svc}, a, &groups.Handler{DB: db, Expenses: svc}, webhooks.NewApplication(db), stream, idem, hc, spec, graph.Handler(svc, cfg.GraphQLComplexity))
 
-	endpointHandler(e, expenses.NewApplication(db))
+	gs, grpcHealth := grpcapi.New(svc, a, cfg.AuthMode, logger)
+	lis, err := net.Listen("tcp", cfg.GRPCAddr)
+	if err != nil {
+		return fmt.Errorf("can't listen for grpc on %s: %w", cfg.GRPCAddr, err)
+	}
 
+	// Either server failing stops both, as a signal would.
+	failed := make(chan error, 2)
+	go func() {
+		logger.Info("server started", "addr", cfg.Addr)
+		if err := e.Start(cfg.Addr); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			failed <- fmt.Errorf("can't serve http: %w", err)
+		}
+	}()
 	go func() {
-		if err := e.Start(":2565"); err != nil && err != http.ErrServerClosed {
-			e.Logger.Fatal("shutting down server")
+		logger.Info("grpc server started", "addr", cfg.GRPCAddr)
+		if err := gs.Serve(lis); err != nil {
+			failed <- fmt.Errorf("can't serve grpc: %w", err)
 		}
 	}()
-	shutdown := make(chan os.Signal, 1)
-	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
-	<-shutdown
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+
+	var serveErr error
+	select {
+	case <-ctx.Done():
+	case serveErr = <-failed:
+		logger.Error("shutting down server", "error", serveErr)
+	}
+	hc.Drain()
+	grpcHealth.Shutdown()
+	time.Sleep(cfg.Timeouts.Drain)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeouts.Shutdown)
 	defer cancel()
-	if err := e.Shutdown(ctx); err != nil {
-		e.Logger.Fatal(err)
+	context.AfterFunc(ctx, cancelRequests)
+	stopped := make(chan struct{})
+	go func() {
+		gs.GracefulStop()
+		close(stopped)
+	}()
+	shutdownErr := e.Shutdown(ctx)
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		gs.Stop()
+	}
+	if shutdownErr != nil {
+		return fmt.Errorf("can't shut down: %w", shutdownErr)
+	}
+	if serveErr != nil {
+		return serveErr
 	}
-	e.Logger.Print("Server shuted down")
+	logger.Info("server shut down")
+	return nil
 }
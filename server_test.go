@@ -0,0 +1,225 @@
+//go:build unit
+// +build unit
+
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/PatcharaKL/assessment/graph"
+	"github.com/PatcharaKL/assessment/health"
+	"github.com/PatcharaKL/assessment/logging"
+	"github.com/PatcharaKL/assessment/openapi"
+	"github.com/PatcharaKL/assessment/problem"
+	"github.com/PatcharaKL/assessment/rest/auth"
+	"github.com/PatcharaKL/assessment/rest/events"
+	"github.com/PatcharaKL/assessment/rest/expenses"
+	"github.com/PatcharaKL/assessment/rest/groups"
+	"github.com/PatcharaKL/assessment/rest/idempotency"
+	"github.com/PatcharaKL/assessment/rest/webhooks"
+	"github.com/PatcharaKL/assessment/validation"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+var echoParam = regexp.MustCompile(`:(\w+)`)
+
+func setupSpec(t *testing.T) *openapi.Spec {
+	spec, err := openapi.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return spec
+}
+
+func setupRoutes(t *testing.T, spec *openapi.Spec) (*echo.Echo, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	e := echo.New()
+	e.HTTPErrorHandler = problem.HTTPErrorHandler
+	endpointHandler(e, expenses.NewApplication(db), auth.NewApplication(db), groups.NewApplication(db), webhooks.NewApplication(db), events.NewStream(db),
+		idempotency.New(db, time.Hour), health.New(db, time.Second), spec, graph.Handler(expenses.NewService(db), 1000))
+	return e, mock
+}
+
+// setupRouteTable returns the route table endpointHandler registers.
+func setupRouteTable(t *testing.T, spec *openapi.Spec) []route {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return routes(expenses.NewApplication(db), auth.NewApplication(db), groups.NewApplication(db), webhooks.NewApplication(db), events.NewStream(db),
+		idempotency.New(db, time.Hour), health.New(db, time.Second), spec, graph.Handler(expenses.NewService(db), 1000))
+}
+
+func TestSpecDescribesEveryRoute(t *testing.T) {
+	spec := setupSpec(t)
+	e, _ := setupRoutes(t, spec)
+
+	routes := []string{}
+	for _, r := range e.Routes() {
+		routes = append(routes, r.Method+" "+echoParam.ReplaceAllString(r.Path, "{$1}"))
+	}
+	documented := []string{}
+	for path, item := range spec.Doc.Paths.Map() {
+		for method := range item.Operations() {
+			documented = append(documented, method+" "+path)
+		}
+	}
+	sort.Strings(routes)
+	sort.Strings(documented)
+
+	assert.Equal(t, documented, routes, "routes in endpointHandler and openapi.yaml differ")
+
+	for _, r := range setupRouteTable(t, spec) {
+		name := r.method + " " + r.path
+		if r.public == (r.scope != "") {
+			t.Errorf("%s must have either a scope or the public marker", name)
+			continue
+		}
+		if r.scope != "" {
+			assert.Contains(t, auth.AllScopes, r.scope, "%s requires an unknown scope", name)
+		}
+		c := echo.New().NewContext(httptest.NewRequest(r.method, r.path, nil), httptest.NewRecorder())
+		assert.Equal(t, r.public, public(c), "%s is public in the route table but not to the auth middleware, or the other way round", name)
+		if op := spec.Doc.Paths.Find(echoParam.ReplaceAllString(r.path, "{$1}")).GetOperation(r.method); op != nil {
+			documentedPublic := op.Security != nil && len(*op.Security) == 0
+			assert.Equal(t, r.public, documentedPublic, "%s is public in the route table but not in openapi.yaml, or the other way round", name)
+		}
+	}
+}
+
+func TestSpecSchemasMatchTypes(t *testing.T) {
+	spec := setupSpec(t)
+	schemas := map[string]any{
+		"Problem":         problem.Problem{},
+		"FieldError":      validation.FieldError{},
+		"Expenses":        expenses.Expenses{},
+		"Group":           groups.Group{},
+		"Split":           groups.Split{},
+		"SplitLine":       groups.SplitLine{},
+		"Balance":         groups.Balance{},
+		"Transfer":        groups.Transfer{},
+		"Settlement":      groups.Settlement{},
+		"APIKey":          auth.APIKey{},
+		"User":            auth.User{},
+		"Check":           health.Check{},
+		"HealthReport":    health.Report{},
+		"Webhook":         webhooks.Webhook{},
+		"WebhookDelivery": webhooks.Delivery{},
+		"WebhookPayload":  webhooks.Payload{},
+	}
+
+	for name, v := range schemas {
+		t.Run(name, func(t *testing.T) {
+			ref, ok := spec.Doc.Components.Schemas[name]
+			if !assert.True(t, ok, "schema %s is missing", name) {
+				return
+			}
+			assert.ElementsMatch(t, properties(ref.Value), jsonFields(reflect.TypeOf(v)))
+		})
+	}
+}
+
+func properties(s *openapi3.Schema) []string {
+	names := []string{}
+	for name := range s.Properties {
+		names = append(names, name)
+	}
+	for _, ref := range s.AllOf {
+		names = append(names, properties(ref.Value)...)
+	}
+	return names
+}
+
+func jsonFields(t reflect.Type) []string {
+	names := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous {
+			names = append(names, jsonFields(f.Type)...)
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if f.IsExported() && name != "-" && name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func TestHandlersConformToSpec(t *testing.T) {
+	spec := setupSpec(t)
+	e, mock := setupRoutes(t, spec)
+	var logs bytes.Buffer
+	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			auth.SetPrincipal(c, auth.Principal{User: "Patchara", Role: auth.RoleAdmin, Scopes: auth.AllScopes})
+			return next(c)
+		}
+	})
+	e.Use(spec.Middleware(logging.New(&logs, "info")))
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO expenses").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("INSERT INTO outbox").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+	mock.ExpectCommit()
+	mock.ExpectQuery("SELECT id, title, amount, note, tags, version, updated_at FROM expenses").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags", "version", "updated_at"}).
+			AddRow(1, "strawberry smoothie", 79, "night market promotion discount 10 bath", pq.Array([]string{"food", "beverage"}), 1, time.Now()))
+	mock.ExpectQuery("SELECT id, title, amount, note, tags, version, updated_at FROM expenses").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags", "version", "updated_at"}).
+			AddRow(1, "strawberry smoothie", 79, "night market promotion discount 10 bath", pq.Array([]string{"food", "beverage"}), 1, time.Now()))
+	mock.ExpectQuery("SELECT count").
+		WillReturnRows(sqlmock.NewRows([]string{"count", "sum"}).AddRow(1, 79))
+	mock.ExpectQuery("INSERT INTO webhooks").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+
+	tests := []struct {
+		method string
+		uri    string
+		body   string
+		code   int
+	}{
+		{http.MethodPost, "/expenses", `{"title": "strawberry smoothie", "amount": 79, "note": "night market promotion discount 10 bath", "tags": ["food", "beverage"]}`, http.StatusCreated},
+		{http.MethodGet, "/expenses", ``, http.StatusOK},
+		{http.MethodGet, "/expenses/1", ``, http.StatusOK},
+		{http.MethodGet, "/livez", ``, http.StatusOK},
+		{http.MethodGet, "/openapi.json", ``, http.StatusOK},
+		{http.MethodGet, "/docs", ``, http.StatusOK},
+		{http.MethodPost, "/graphql", `{"query": "{ totals { count sum average } }"}`, http.StatusOK},
+		{http.MethodPost, "/graphql", `{"query": "{ unknown }"}`, http.StatusUnprocessableEntity},
+		{http.MethodPost, "/webhooks", `{"url": "https://93.184.216.34/hooks", "events": ["expense.created"]}`, http.StatusCreated},
+		{http.MethodPost, "/webhooks", `{"url": "ftp://reimburse.example.com"}`, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.uri, strings.NewReader(tt.body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+
+		e.ServeHTTP(rec, req)
+
+		assert.Equal(t, tt.code, rec.Code, tt.method+" "+tt.uri)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.NotContains(t, logs.String(), "response does not match openapi spec")
+}
@@ -0,0 +1,287 @@
+// Package sqlhooks wraps a database/sql driver so that every statement runs
+// through a list of hooks. It is how logging, metrics and tracing observe
+// queries without the handlers knowing about them, and how every statement
+// is bounded by the statement timeout and a read that fails on a broken
+// connection is retried on another.
+package sqlhooks
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Hook observes a statement. Before may return a derived context, which is
+// the one passed to After.
+type Hook interface {
+	Before(ctx context.Context, query string) context.Context
+	After(ctx context.Context, query string, elapsed time.Duration, err error)
+}
+
+// Open returns a *sql.DB that runs every statement through hooks. A positive
+// timeout cancels statements that run longer, including the reading of their
+// rows; zero leaves them to the caller's context.
+func Open(c driver.Connector, timeout time.Duration, hooks ...Hook) *sql.DB {
+	return sql.OpenDB(&connector{c, timeout, hooks})
+}
+
+type connector struct {
+	driver.Connector
+	timeout time.Duration
+	hooks   []Hook
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	cn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{cn, c.timeout, c.hooks}, nil
+}
+
+type noTimeoutKey struct{}
+
+// WithoutTimeout returns a context whose statements aren't bounded by the
+// statement timeout, for work such as migrations that may rightly take long.
+func WithoutTimeout(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noTimeoutKey{}, true)
+}
+
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 || ctx.Value(noTimeoutKey{}) != nil {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// contextError reports a statement that failed because ctx is done as the
+// context's error, so callers can tell a timeout or a cancellation from a
+// failed statement: the driver reports them as its own errors.
+func contextError(ctx context.Context, err error) error {
+	if err == nil || err == driver.ErrSkip || ctx.Err() == nil || errors.Is(err, ctx.Err()) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ctx.Err(), err)
+}
+
+func run[T any](ctx context.Context, hooks []Hook, query string, fn func(ctx context.Context) (T, error)) (T, error) {
+	for _, h := range hooks {
+		ctx = h.Before(ctx, query)
+	}
+	start := time.Now()
+	v, err := fn(ctx)
+	err = contextError(ctx, err)
+	elapsed := time.Since(start)
+	if err != driver.ErrSkip {
+		for i := len(hooks) - 1; i >= 0; i-- {
+			hooks[i].After(ctx, query, elapsed, err)
+		}
+	}
+	return v, err
+}
+
+// timedRows keeps a query's timeout running while its rows are read, and
+// stops it when they are closed.
+type timedRows struct {
+	driver.Rows
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (r *timedRows) Next(dest []driver.Value) error {
+	err := r.Rows.Next(dest)
+	if err == io.EOF {
+		return err
+	}
+	return contextError(r.ctx, err)
+}
+
+func (r *timedRows) Close() error {
+	defer r.cancel()
+	return r.Rows.Close()
+}
+
+// isRead reports whether query only reads, so that running it again is safe.
+func isRead(query string) bool {
+	word, _, _ := strings.Cut(strings.TrimSpace(query), " ")
+	return strings.EqualFold(word, "SELECT")
+}
+
+// isTransient reports whether err means the connection broke, rather than
+// that the statement failed, so that another connection may well succeed.
+func isTransient(err error) bool {
+	var opErr *net.OpError
+	var pqErr *pq.Error
+	switch {
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE), errors.Is(err, io.ErrUnexpectedEOF):
+		return true
+	case errors.As(err, &opErr):
+		return true
+	case errors.As(err, &pqErr):
+		// Class 08 is connection exceptions; 57P01 to 57P03 are the
+		// server shutting down or starting up.
+		return pqErr.Code.Class() == "08" || pqErr.Code == "57P01" || pqErr.Code == "57P02" || pqErr.Code == "57P03"
+	}
+	return false
+}
+
+// query runs fn under the statement timeout, which runs on until the rows it
+// returns are closed. A read that fails on a broken connection is reported
+// as driver.ErrBadConn, which has database/sql drop the connection and run
+// the read again on another, up to three times. Reads already returning rows
+// and statements that write aren't retried.
+func query(ctx context.Context, timeout time.Duration, hooks []Hook, q string, fn func(ctx context.Context) (driver.Rows, error)) (driver.Rows, error) {
+	ctx, cancel := withTimeout(ctx, timeout)
+	rows, err := run(ctx, hooks, q, fn)
+	if err != nil {
+		cancel()
+		if ctx.Err() == nil && isRead(q) && isTransient(err) && !errors.Is(err, driver.ErrBadConn) {
+			err = fmt.Errorf("%w: %w", driver.ErrBadConn, err)
+		}
+		return nil, err
+	}
+	if timeout <= 0 {
+		return rows, nil
+	}
+	return &timedRows{rows, ctx, cancel}, nil
+}
+
+type conn struct {
+	driver.Conn
+	timeout time.Duration
+	hooks   []Hook
+}
+
+func (c *conn) QueryContext(ctx context.Context, q string, args []driver.NamedValue) (driver.Rows, error) {
+	qc, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	return query(ctx, c.timeout, c.hooks, q, func(ctx context.Context) (driver.Rows, error) {
+		return qc.QueryContext(ctx, q, args)
+	})
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	ctx, cancel := withTimeout(ctx, c.timeout)
+	defer cancel()
+	return run(ctx, c.hooks, query, func(ctx context.Context) (driver.Result, error) {
+		return e.ExecContext(ctx, query, args)
+	})
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	ctx, cancel := withTimeout(ctx, c.timeout)
+	defer cancel()
+	var st driver.Stmt
+	var err error
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		st, err = p.PrepareContext(ctx, query)
+	} else {
+		st, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return &stmt{st, query, c.timeout, c.hooks}, nil
+}
+
+// BeginTx passes the caller's context on as it is: drivers such as pq watch
+// it for the whole transaction and abort the transaction when it is done, so
+// a timeout here would end it as soon as BEGIN returned. The transaction
+// lasts as long as the caller's context, and each of its statements gets a
+// timeout of its own.
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		tx, err := b.BeginTx(ctx, opts)
+		return tx, contextError(ctx, err)
+	}
+	return c.Conn.Begin()
+}
+
+func (c *conn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *conn) CheckNamedValue(nv *driver.NamedValue) error {
+	if ch, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return ch.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+func (c *conn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+type stmt struct {
+	driver.Stmt
+	query   string
+	timeout time.Duration
+	hooks   []Hook
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	return run(ctx, s.hooks, s.query, func(ctx context.Context) (driver.Result, error) {
+		if e, ok := s.Stmt.(driver.StmtExecContext); ok {
+			return e.ExecContext(ctx, args)
+		}
+		values, err := toValues(args)
+		if err != nil {
+			return nil, err
+		}
+		return s.Stmt.Exec(values)
+	})
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return query(ctx, s.timeout, s.hooks, s.query, func(ctx context.Context) (driver.Rows, error) {
+		if q, ok := s.Stmt.(driver.StmtQueryContext); ok {
+			return q.QueryContext(ctx, args)
+		}
+		values, err := toValues(args)
+		if err != nil {
+			return nil, err
+		}
+		return s.Stmt.Query(values)
+	})
+}
+
+func (s *stmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if ch, ok := s.Stmt.(driver.NamedValueChecker); ok {
+		return ch.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+func toValues(args []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(args))
+	for i, a := range args {
+		if a.Name != "" {
+			return nil, driver.ErrSkip
+		}
+		values[i] = a.Value
+	}
+	return values, nil
+}
@@ -0,0 +1,268 @@
+//go:build unit
+// +build unit
+
+package sqlhooks
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+type dsnConnector struct {
+	dsn string
+	d   driver.Driver
+}
+
+func (c dsnConnector) Connect(context.Context) (driver.Conn, error) { return c.d.Open(c.dsn) }
+func (c dsnConnector) Driver() driver.Driver                        { return c.d }
+
+type call struct {
+	query string
+	err   error
+}
+
+type recordingHook struct {
+	calls []call
+}
+
+func (h *recordingHook) Before(ctx context.Context, query string) context.Context { return ctx }
+
+func (h *recordingHook) After(ctx context.Context, query string, elapsed time.Duration, err error) {
+	h.calls = append(h.calls, call{query, err})
+}
+
+func TestHooksU(t *testing.T) {
+	// Arrange
+	mockDB, mock, err := sqlmock.NewWithDSN("sqlhooks_test")
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	hook := &recordingHook{}
+	db := Open(dsnConnector{"sqlhooks_test", mockDB.Driver()}, 0, hook)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT 1").WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(1))
+	mock.ExpectExec("DELETE FROM expenses").WillReturnError(errors.New("boom"))
+	mock.ExpectPrepare("UPDATE expenses").ExpectExec().WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// Act
+	var n int
+	scanErr := db.QueryRowContext(context.Background(), "SELECT 1").Scan(&n)
+	_, execErr := db.ExecContext(context.Background(), "DELETE FROM expenses")
+	stmt, prepErr := db.Prepare("UPDATE expenses SET title = $1")
+	_, stmtErr := stmt.Exec("tea")
+
+	// Assert
+	assert.NoError(t, scanErr)
+	assert.EqualError(t, execErr, "boom")
+	assert.NoError(t, prepErr)
+	assert.NoError(t, stmtErr)
+	assert.Equal(t, []call{
+		{"SELECT 1", nil},
+		{"DELETE FROM expenses", errors.New("boom")},
+		{"UPDATE expenses SET title = $1", nil},
+	}, hook.calls)
+}
+
+func TestStatementTimeoutU(t *testing.T) {
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		wantErr error
+	}{
+		{name: "testSlowStatementTimesOut", ctx: context.Background(), wantErr: context.DeadlineExceeded},
+		{name: "testWithoutTimeout", ctx: WithoutTimeout(context.Background())},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			mockDB, mock, err := sqlmock.NewWithDSN("sqlhooks_timeout_" + tt.name)
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer mockDB.Close()
+			hook := &recordingHook{}
+			db := Open(dsnConnector{"sqlhooks_timeout_" + tt.name, mockDB.Driver()}, 20*time.Millisecond, hook)
+			defer db.Close()
+			mock.ExpectQuery("SELECT pg_sleep").WillDelayFor(100 * time.Millisecond).
+				WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(1))
+
+			// Act
+			var n int
+			err = db.QueryRowContext(tt.ctx, "SELECT pg_sleep(0.1)").Scan(&n)
+
+			// Assert
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, tt.wantErr)
+			if assert.Len(t, hook.calls, 1) {
+				assert.ErrorIs(t, hook.calls[0].err, tt.wantErr, "hooks see the timeout")
+			}
+		})
+	}
+}
+
+func TestStatementTimeoutLastsUntilRowsCloseU(t *testing.T) {
+	// Arrange
+	mockDB, mock, err := sqlmock.NewWithDSN("sqlhooks_rows")
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	db := Open(dsnConnector{"sqlhooks_rows", mockDB.Driver()}, time.Second)
+	defer db.Close()
+	mock.ExpectQuery("SELECT n").WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(1).AddRow(2))
+
+	// Act
+	rows, err := db.QueryContext(context.Background(), "SELECT n FROM numbers")
+	var got []int
+	if err == nil {
+		for rows.Next() {
+			var n int
+			rows.Scan(&n)
+			got = append(got, n)
+		}
+		err = rows.Err()
+		rows.Close()
+	}
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, got)
+}
+
+func TestTransientReadRetriesU(t *testing.T) {
+	reset := &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+	tests := []struct {
+		name      string
+		query     string
+		wantTries int
+		wantErr   bool
+	}{
+		{name: "testReadRetried", query: "SELECT title FROM expenses", wantTries: 2},
+		{name: "testWriteNotRetried", query: "INSERT INTO expenses (title) values ('tea') RETURNING id", wantTries: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			dsn := "sqlhooks_retry_" + tt.name
+			mockDB, mock, err := sqlmock.NewWithDSN(dsn, sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer mockDB.Close()
+			hook := &recordingHook{}
+			db := Open(dsnConnector{dsn, mockDB.Driver()}, 0, hook)
+			defer db.Close()
+			mock.ExpectQuery(tt.query).WillReturnError(reset)
+			mock.ExpectQuery(tt.query).WillReturnRows(sqlmock.NewRows([]string{"title"}).AddRow("apple smoothie"))
+
+			// Act
+			var title string
+			err = db.QueryRowContext(context.Background(), tt.query).Scan(&title)
+
+			// Assert
+			assert.Len(t, hook.calls, tt.wantTries)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, syscall.ECONNRESET)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "apple smoothie", title)
+		})
+	}
+}
+
+// watchingConn is a connection that, like pq's, watches the context of
+// BeginTx until the transaction ends, and fails the transaction's statements
+// once that context is done.
+type watchingConn struct {
+	txCtx context.Context
+}
+
+func (c *watchingConn) Connect(context.Context) (driver.Conn, error) { return c, nil }
+func (c *watchingConn) Driver() driver.Driver                        { return nil }
+
+func (c *watchingConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c *watchingConn) Close() error                        { return nil }
+func (c *watchingConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (c *watchingConn) BeginTx(ctx context.Context, _ driver.TxOptions) (driver.Tx, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c.txCtx = ctx
+	return c, nil
+}
+
+func (c *watchingConn) ExecContext(context.Context, string, []driver.NamedValue) (driver.Result, error) {
+	if c.txCtx != nil && c.txCtx.Err() != nil {
+		return nil, errors.New("pq: canceling statement due to user request")
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (c *watchingConn) Commit() error {
+	defer func() { c.txCtx = nil }()
+	if c.txCtx.Err() != nil {
+		return errors.New("pq: canceling statement due to user request")
+	}
+	return nil
+}
+
+func (c *watchingConn) Rollback() error {
+	c.txCtx = nil
+	return nil
+}
+
+func TestTransactionOutlivesBeginU(t *testing.T) {
+	tests := []struct {
+		name    string
+		cancel  bool
+		wantErr bool
+	}{
+		{name: "testStatementsAfterBegin"},
+		{name: "testCallerCancels", cancel: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			db := Open(&watchingConn{}, time.Second)
+			defer db.Close()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			tx, err := db.BeginTx(ctx, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tx.Rollback()
+			if tt.cancel {
+				cancel()
+			}
+
+			// Act
+			_, err = tx.ExecContext(context.Background(), "UPDATE expenses SET title = 'iced tea' WHERE id = 1")
+			if err == nil {
+				err = tx.Commit()
+			}
+
+			// Assert
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
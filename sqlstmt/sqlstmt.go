@@ -0,0 +1,110 @@
+// Package sqlstmt prepares a package's queries once, when the server starts,
+// rather than on every request, and closes them when it stops. A prepared
+// statement is planned once per connection and then runs in a single round
+// trip, where an unprepared query with arguments is parsed every time.
+package sqlstmt
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// Querier runs queries. *sql.DB, *sql.Tx and *Registry are Queriers, so code
+// can take one and be given whichever it runs with.
+type Querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Registry runs each query it was prepared with as its prepared statement,
+// looked up by the query's SQL, and any other query unprepared. database/sql
+// prepares a statement again, once, on each connection that runs it.
+type Registry struct {
+	db    *sql.DB
+	stmts map[string]*sql.Stmt
+}
+
+// Prepare prepares queries on db. If one fails, those already prepared are
+// closed.
+func Prepare(ctx context.Context, db *sql.DB, queries ...string) (*Registry, error) {
+	r := &Registry{db: db, stmts: make(map[string]*sql.Stmt, len(queries))}
+	for _, q := range queries {
+		if _, ok := r.stmts[q]; ok {
+			continue
+		}
+		stmt, err := db.PrepareContext(ctx, q)
+		if err != nil {
+			r.Close()
+			return nil, fmt.Errorf("can't prepare %q: %w", q, err)
+		}
+		r.stmts[q] = stmt
+	}
+	return r, nil
+}
+
+// Close closes every statement. Queries still running finish first.
+func (r *Registry) Close() error {
+	var errs []error
+	for q, stmt := range r.stmts {
+		if err := stmt.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("can't close %q: %w", q, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (r *Registry) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	if stmt, ok := r.stmts[query]; ok {
+		return stmt.ExecContext(ctx, args...)
+	}
+	return r.db.ExecContext(ctx, query, args...)
+}
+
+func (r *Registry) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	if stmt, ok := r.stmts[query]; ok {
+		return stmt.QueryContext(ctx, args...)
+	}
+	return r.db.QueryContext(ctx, query, args...)
+}
+
+func (r *Registry) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	if stmt, ok := r.stmts[query]; ok {
+		return stmt.QueryRowContext(ctx, args...)
+	}
+	return r.db.QueryRowContext(ctx, query, args...)
+}
+
+// Tx returns a Querier that runs the statements in tx. It is valid until tx
+// is committed or rolled back.
+func (r *Registry) Tx(tx *sql.Tx) Querier {
+	return &txQuerier{r: r, tx: tx}
+}
+
+type txQuerier struct {
+	r  *Registry
+	tx *sql.Tx
+}
+
+func (t *txQuerier) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	if stmt, ok := t.r.stmts[query]; ok {
+		return t.tx.StmtContext(ctx, stmt).ExecContext(ctx, args...)
+	}
+	return t.tx.ExecContext(ctx, query, args...)
+}
+
+func (t *txQuerier) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	if stmt, ok := t.r.stmts[query]; ok {
+		return t.tx.StmtContext(ctx, stmt).QueryContext(ctx, args...)
+	}
+	return t.tx.QueryContext(ctx, query, args...)
+}
+
+func (t *txQuerier) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	if stmt, ok := t.r.stmts[query]; ok {
+		return t.tx.StmtContext(ctx, stmt).QueryRowContext(ctx, args...)
+	}
+	return t.tx.QueryRowContext(ctx, query, args...)
+}
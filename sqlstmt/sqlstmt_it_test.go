@@ -0,0 +1,66 @@
+//go:build integration
+// +build integration
+
+package sqlstmt
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/PatcharaKL/assessment/migrations"
+	_ "github.com/lib/pq"
+)
+
+const benchSQL = "SELECT id, title FROM expenses WHERE id = $1"
+
+func query(b *testing.B, rows *sql.Rows, err error) {
+	if err != nil {
+		b.Fatal(err)
+	}
+	for rows.Next() {
+	}
+	if err := rows.Close(); err != nil {
+		b.Fatal(err)
+	}
+}
+
+// BenchmarkQuery compares preparing a statement for every request with
+// preparing it once, against the integration database. Preparing per
+// request adds the round trips to prepare and close the statement.
+func BenchmarkQuery(b *testing.B) {
+	ctx := context.Background()
+	db, err := sql.Open("postgres", "postgresql://root:root@db/go-example-db?sslmode=disable")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := migrations.Up(ctx, db); err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("prepare per request", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			stmt, err := db.PrepareContext(ctx, benchSQL)
+			if err != nil {
+				b.Fatal(err)
+			}
+			rows, err := stmt.QueryContext(ctx, 1)
+			query(b, rows, err)
+			stmt.Close()
+		}
+	})
+
+	b.Run("prepared once", func(b *testing.B) {
+		r, err := Prepare(ctx, db, benchSQL)
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer r.Close()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			rows, err := r.QueryContext(ctx, benchSQL, 1)
+			query(b, rows, err)
+		}
+	})
+}
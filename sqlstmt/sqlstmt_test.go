@@ -0,0 +1,116 @@
+//go:build unit
+// +build unit
+
+package sqlstmt
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	getSQL    = "SELECT title FROM expenses WHERE id = $1"
+	updateSQL = "UPDATE expenses SET title = $2 WHERE id = $1"
+)
+
+func setupRegistry(t *testing.T) (*Registry, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	mock.ExpectPrepare(regexp.QuoteMeta(getSQL)).WillBeClosed()
+	mock.ExpectPrepare(regexp.QuoteMeta(updateSQL)).WillBeClosed()
+	r, err := Prepare(context.Background(), db, getSQL, updateSQL, getSQL)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when preparing statements", err)
+	}
+	return r, mock
+}
+
+func TestPrepareOnceU(t *testing.T) {
+	// Arrange
+	r, mock := setupRegistry(t)
+	for i := 0; i < 2; i++ {
+		mock.ExpectQuery(regexp.QuoteMeta(getSQL)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"title"}).AddRow("apple smoothie"))
+	}
+	mock.ExpectExec(regexp.QuoteMeta(updateSQL)).WithArgs(1, "strawberry smoothie").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// Act
+	titles := []string{}
+	for i := 0; i < 2; i++ {
+		var title string
+		err := r.QueryRowContext(context.Background(), getSQL, 1).Scan(&title)
+		assert.NoError(t, err)
+		titles = append(titles, title)
+	}
+	_, execErr := r.ExecContext(context.Background(), updateSQL, 1, "strawberry smoothie")
+	closeErr := r.Close()
+
+	// Assert
+	assert.Equal(t, []string{"apple smoothie", "apple smoothie"}, titles)
+	assert.NoError(t, execErr)
+	assert.NoError(t, closeErr)
+	assert.NoError(t, mock.ExpectationsWereMet(), "a query listed twice is prepared once")
+}
+
+func TestPrepareFailureClosesPreparedU(t *testing.T) {
+	// Arrange
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	mock.ExpectPrepare(regexp.QuoteMeta(getSQL)).WillBeClosed()
+	mock.ExpectPrepare(regexp.QuoteMeta(updateSQL)).WillReturnError(errors.New(`relation "expenses" does not exist`))
+
+	// Act
+	r, err := Prepare(context.Background(), db, getSQL, updateSQL)
+
+	// Assert
+	assert.Nil(t, r)
+	assert.ErrorContains(t, err, "can't prepare")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUnregisteredQueryRunsUnpreparedU(t *testing.T) {
+	// Arrange
+	r, mock := setupRegistry(t)
+	mock.ExpectQuery("SELECT count").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+
+	// Act
+	rows, err := r.QueryContext(context.Background(), "SELECT count(*) FROM expenses")
+
+	// Assert
+	if assert.NoError(t, err) {
+		assert.True(t, rows.Next())
+		rows.Close()
+	}
+	r.Close()
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTxRunsStatementsU(t *testing.T) {
+	// Arrange
+	r, mock := setupRegistry(t)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(updateSQL)).WithArgs(1, "strawberry smoothie").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM expenses").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	tx, _ := r.db.Begin()
+
+	// Act
+	q := r.Tx(tx)
+	_, updateErr := q.ExecContext(context.Background(), updateSQL, 1, "strawberry smoothie")
+	_, deleteErr := q.ExecContext(context.Background(), "DELETE FROM expenses WHERE id = $1", 2)
+	commitErr := tx.Commit()
+	r.Close()
+
+	// Assert
+	assert.NoError(t, updateErr)
+	assert.NoError(t, deleteErr)
+	assert.NoError(t, commitErr)
+	assert.NoError(t, mock.ExpectationsWereMet(), "the transaction reuses the statement")
+}
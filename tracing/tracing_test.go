@@ -0,0 +1,99 @@
+//go:build unit
+// +build unit
+
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/PatcharaKL/assessment/logging"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func setupRecorder() *tracetest.SpanRecorder {
+	sr := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return sr
+}
+
+func TestMiddlewareU(t *testing.T) {
+	// Arrange
+	sr := setupRecorder()
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/expenses", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/expenses")
+	r := &logging.Request{ID: "req-1", Route: "/expenses"}
+	c.SetRequest(req.WithContext(logging.WithRequest(req.Context(), r)))
+
+	next := func(c echo.Context) error {
+		ctx := QueryHook{}.Before(c.Request().Context(), "SELECT 1")
+		QueryHook{}.After(ctx, "SELECT 1", time.Millisecond, errors.New("boom"))
+		return c.JSON(http.StatusInternalServerError, "boom")
+	}
+
+	// Act
+	err := Middleware(next)(c)
+
+	// Assert
+	assert.NoError(t, err)
+	spans := sr.Ended()
+	if assert.Len(t, spans, 2) {
+		query, server := spans[0], spans[1]
+		assert.Equal(t, "GET /expenses", server.Name())
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.SpanContext().TraceID().String())
+		assert.Equal(t, "00f067aa0ba902b7", server.Parent().SpanID().String())
+		assert.Contains(t, server.Attributes(), attribute.String("request.id", "req-1"))
+		assert.Equal(t, codes.Error, server.Status().Code)
+
+		assert.Equal(t, "db.query", query.Name())
+		assert.Equal(t, server.SpanContext().SpanID(), query.Parent().SpanID())
+		assert.Contains(t, query.Attributes(), attribute.String("db.statement", "SELECT 1"))
+		assert.Equal(t, codes.Error, query.Status().Code)
+	}
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", r.TraceID())
+}
+
+func TestMiddlewareWithoutTracingU(t *testing.T) {
+	// Arrange
+	otel.SetTracerProvider(noop.NewTracerProvider())
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/expenses", nil)
+	c := e.NewContext(req, httptest.NewRecorder())
+	r := &logging.Request{ID: "req-1", Route: "/expenses"}
+	c.SetRequest(req.WithContext(logging.WithRequest(req.Context(), r)))
+
+	// Act
+	err := Middleware(func(c echo.Context) error { return c.NoContent(http.StatusOK) })(c)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Empty(t, r.TraceID(), "no all-zero trace ID in the logs")
+}
+
+func TestSetupU(t *testing.T) {
+	// Act
+	noop, noopErr := Setup(context.Background(), ExporterNone, "", "expenses")
+	_, unknownErr := Setup(context.Background(), "zipkin", "", "expenses")
+
+	// Assert
+	assert.NoError(t, noopErr)
+	assert.NoError(t, noop(context.Background()))
+	assert.EqualError(t, unknownErr, `tracing: unknown exporter "zipkin"`)
+}
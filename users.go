@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/PatcharaKL/assessment/rest/auth"
+)
+
+// user administers accounts without going through the API, for the first
+// admin of an install and for when every admin is locked out.
+func user(ctx context.Context, args []string) error {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return usageError{errors.New("expected create, reset-password or disable")}
+	}
+	action, args := args[0], args[1:]
+
+	fs := newFlagSet("user " + action)
+	username := fs.String("username", "", "username of the account")
+	var role, ledger *string
+	var passwordStdin *bool
+	switch action {
+	case "create":
+		role = fs.String("role", auth.RoleMember, "role: admin, member or viewer")
+		ledger = fs.String("ledger", "", "for viewers, the user whose expenses they may read")
+		fallthrough
+	case "reset-password":
+		passwordStdin = fs.Bool("password-stdin", false, "read the password from stdin instead of generating one")
+	case "disable":
+	default:
+		return usageError{fmt.Errorf("unknown action %q, use create, reset-password or disable", action)}
+	}
+	cfg, err := parseConfig(fs, args)
+	if err != nil {
+		return err
+	}
+	if *username == "" {
+		return usageError{errors.New("-username is required")}
+	}
+
+	var password string
+	if passwordStdin != nil {
+		if password, err = readPassword(*passwordStdin); err != nil {
+			return err
+		}
+	}
+
+	db, err := connect(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	h := auth.NewApplication(db)
+
+	switch action {
+	case "create":
+		u, err := h.CreateUser(ctx, auth.User{Username: *username, Password: password, Role: *role, Ledger: *ledger})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "created %s user %s (id %d)\n", u.Role, u.Username, u.ID)
+	case "reset-password":
+		if err := h.ResetPassword(ctx, *username, password); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "reset the password of %s\n", *username)
+	case "disable":
+		if err := h.DisableUser(ctx, *username); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "disabled %s\n", *username)
+		return nil
+	}
+	if !*passwordStdin {
+		fmt.Fprintf(stdout, "password: %s\n", password)
+	}
+	return nil
+}
+
+// readPassword reads the first line of stdin, or generates a password when
+// fromStdin is false. Generated passwords are printed once and not stored.
+func readPassword(fromStdin bool) (string, error) {
+	if !fromStdin {
+		b := make([]byte, 18)
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		return base64.RawURLEncoding.EncodeToString(b), nil
+	}
+
+	line, err := bufio.NewReader(stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return "", usageError{errors.New("no password on stdin")}
+	}
+	return password, nil
+}
@@ -0,0 +1,100 @@
+// Package validation checks request input against the `validate` struct tags
+// and reports every violation by field.
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+const Message = "request has invalid fields"
+
+// tagPattern allows letters in any script, digits, spaces, '-' and '_'.
+var tagPattern = regexp.MustCompile(`^[\p{L}\p{N} _-]+$`)
+
+// embedded names anonymous struct fields so that field can drop them: their
+// JSON fields are promoted to the parent object.
+const embedded = "~"
+
+var validate = newValidator()
+
+func newValidator() *validator.Validate {
+	v := validator.New(validator.WithRequiredStructEnabled())
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		if f.Anonymous {
+			return embedded
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	v.RegisterValidation("tag", func(fl validator.FieldLevel) bool {
+		return tagPattern.MatchString(fl.Field().String())
+	})
+	return v
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Errors is the 422 response body.
+type Errors struct {
+	Message string       `json:"message"`
+	Errors  []FieldError `json:"errors"`
+}
+
+func (e *Errors) Error() string {
+	msgs := []string{}
+	for _, f := range e.Errors {
+		msgs = append(msgs, f.Field+" "+f.Message)
+	}
+	return e.Message + ": " + strings.Join(msgs, "; ")
+}
+
+// Struct validates v. It returns *Errors when a field breaks a rule.
+func Struct(v any) error {
+	err := validate.Struct(v)
+	var verrs validator.ValidationErrors
+	if !errors.As(err, &verrs) {
+		return err
+	}
+
+	fields := []FieldError{}
+	for _, fe := range verrs {
+		fields = append(fields, FieldError{Field: field(fe), Message: message(fe)})
+	}
+	return &Errors{Message: Message, Errors: fields}
+}
+
+// field drops the struct name and embedded structs from the namespace,
+// leaving the JSON path such as tags[3].
+func field(fe validator.FieldError) string {
+	_, path, _ := strings.Cut(fe.Namespace(), ".")
+	return strings.ReplaceAll(path, embedded+".", "")
+}
+
+func message(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "gt":
+		return "must be greater than " + fe.Param()
+	case "max":
+		if fe.Kind() == reflect.Slice {
+			return fmt.Sprintf("must have at most %s items", fe.Param())
+		}
+		return fmt.Sprintf("must be at most %s characters", fe.Param())
+	case "tag":
+		return "may only contain letters, digits, spaces, '-' and '_'"
+	}
+	return "is invalid"
+}
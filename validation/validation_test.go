@@ -0,0 +1,57 @@
+//go:build unit
+// +build unit
+
+package validation
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type item struct {
+	Title  string   `json:"title" validate:"required,max=10"`
+	Amount float64  `json:"amount" validate:"gt=0"`
+	Tags   []string `json:"tags" validate:"max=2,dive,max=5,tag"`
+}
+
+type wrapper struct {
+	item
+	Owner string `json:"owner" validate:"required"`
+}
+
+func TestStruct(t *testing.T) {
+	tests := []struct {
+		name string
+		v    any
+		want []FieldError
+	}{
+		{"valid", item{Title: "lunch", Amount: 1, Tags: []string{"food", "อาหาร"}}, nil},
+		{"required", item{Amount: 1}, []FieldError{{"title", "is required"}}},
+		{"too long", item{Title: strings.Repeat("a", 11), Amount: 1}, []FieldError{{"title", "must be at most 10 characters"}}},
+		{"negative amount", item{Title: "lunch", Amount: -1}, []FieldError{{"amount", "must be greater than 0"}}},
+		{"NaN amount", item{Title: "lunch", Amount: math.NaN()}, []FieldError{{"amount", "must be greater than 0"}}},
+		{"too many tags", item{Title: "lunch", Amount: 1, Tags: []string{"a", "b", "c"}}, []FieldError{{"tags", "must have at most 2 items"}}},
+		{"bad tags", item{Title: "lunch", Amount: 1, Tags: []string{"a;b", "abcdef"}}, []FieldError{
+			{"tags[0]", "may only contain letters, digits, spaces, '-' and '_'"},
+			{"tags[1]", "must be at most 5 characters"},
+		}},
+		{"embedded", wrapper{item: item{Amount: 1}}, []FieldError{{"title", "is required"}, {"owner", "is required"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Struct(tt.v)
+
+			if tt.want == nil {
+				assert.NoError(t, err)
+				return
+			}
+			if assert.IsType(t, &Errors{}, err) {
+				assert.Equal(t, Message, err.(*Errors).Message)
+				assert.Equal(t, tt.want, err.(*Errors).Errors)
+			}
+		})
+	}
+}